@@ -10,19 +10,68 @@ import (
 	"syscall"
 	"time"
 
+	goredis "github.com/go-redis/redis/v8"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"scbunn.org/tmp/gps-tracking-service/pkg/auth"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geofence"
+	"scbunn.org/tmp/gps-tracking-service/pkg/ingest"
+	"scbunn.org/tmp/gps-tracking-service/pkg/lifecycle"
 	"scbunn.org/tmp/gps-tracking-service/pkg/models"
 	"scbunn.org/tmp/gps-tracking-service/pkg/models/inmem"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models/redis"
+	"scbunn.org/tmp/gps-tracking-service/pkg/odometer"
+	"scbunn.org/tmp/gps-tracking-service/pkg/pubsub"
+	"scbunn.org/tmp/gps-tracking-service/pkg/ratelimit"
 	"scbunn.org/tmp/gps-tracking-service/pkg/service"
+	"scbunn.org/tmp/gps-tracking-service/pkg/smooth"
+	"scbunn.org/tmp/gps-tracking-service/pkg/status"
+	"scbunn.org/tmp/gps-tracking-service/pkg/stops"
+	"scbunn.org/tmp/gps-tracking-service/pkg/trips"
+	"scbunn.org/tmp/gps-tracking-service/pkg/wal"
 )
 
+// writeTimeout is the longest the server spends writing a response
+const writeTimeout = 30 * time.Second
+
 func main() {
 
 	addr := flag.String("addr", ":5000", "HTTP network address")
 	datastore := flag.String("datastore", "inmemdb", "backend datastore to use")
-	ttl := flag.Duration("object-ttl", 60*time.Second, "TTL of Object Telemetry")
+	ttl := flag.Duration("object-ttl", models.DefaultTTL, "TTL of Object Telemetry")
+	sourceTTL := flag.String("source-ttl", "", "per-source TTL overrides as source=duration pairs separated by commas")
+	expireInterval := flag.Duration("expire-interval", 5*time.Second, "how often the datastore expires stale objects")
+	historySize := flag.Int("history-size", inmem.DefaultHistorySize, "maximum number of positions kept per object")
+	historyAge := flag.Duration("history-age", inmem.DefaultHistoryAge, "maximum age of positions kept per object")
+	streamBuffer := flag.Int("stream-buffer", pubsub.DefaultBuffer, "number of updates queued for each stream subscriber")
+	redisAddr := flag.String("redis-addr", "localhost:6379", "address of the redis server")
+	redisPassword := flag.String("redis-password", "", "password of the redis server")
+	redisDB := flag.Int("redis-db", 0, "redis database to select")
+	snapshotPath := flag.String("snapshot-path", "", "file the inmemdb datastore is snapshotted to and restored from")
+	snapshotInterval := flag.Duration("snapshot-interval", time.Minute, "how often the inmemdb datastore is snapshotted")
+	walDir := flag.String("wal-dir", "", "directory of the inmemdb write-ahead log; requires snapshot-path")
+	walSync := flag.String("wal-sync", "interval", "when the write-ahead log is synced to disk: always, interval, or none")
+	walSyncInterval := flag.Duration("wal-sync-interval", wal.DefaultSyncInterval, "how often the write-ahead log is synced with -wal-sync interval")
+	walSegmentSize := flag.Int64("wal-segment-size", wal.DefaultSegmentSize, "size in bytes a write-ahead log segment grows to before rotating")
+	authConfig := flag.String("auth-config", "", "JSON file of the credentials accepted by the API; authentication is disabled when empty")
+	maxSpeed := flag.Float64("max-speed", 340, "fastest speed, in meters per second, an object may move between fixes; unchecked when 0")
+	maxFutureSkew := flag.Duration("max-future-skew", 5*time.Minute, "how far ahead of the server clock an update's timestamp may be; unchecked when 0")
+	statusTransitions := flag.String("status-transitions", "", "allowed status changes overriding the defaults as status=status|status pairs separated by commas")
+	tripDwell := flag.Duration("trip-dwell", trips.DefaultDwell, "how long an object must be at rest before its trip ends")
+	tripAge := flag.Duration("trip-age", inmem.DefaultTripAge, "how long the trips of an object are kept after they ended")
+	stopRadius := flag.Float64("stop-radius", stops.DefaultRadius, "how far, in meters, an object may wander and still be stopped")
+	stopDuration := flag.Duration("stop-duration", stops.DefaultDuration, "how long an object must stay within -stop-radius to be stopped")
+	stopAge := flag.Duration("stop-age", inmem.DefaultStopAge, "how long the stops of an object are kept after it departed")
+	minMovement := flag.Float64("odometer-min-movement", odometer.DefaultMinMovement, "how far, in meters, an object must move before it counts toward its odometer")
+	smoothing := flag.String("smoothing", "", "per-source position smoothing as source=speed pairs separated by commas, speed being how fast in m/s its objects are expected to move")
+	sourceRate := flag.Float64("source-rate", 0, "telemetry updates per second accepted from each source; unlimited when 0")
+	sourceBurst := flag.Int("source-burst", 100, "telemetry updates a source may send at once before -source-rate applies")
+	sourceRates := flag.String("source-rates", "", "per-source rate limit overrides as source=rate:burst pairs separated by commas")
+	clientRate := flag.Float64("client-rate", 0, "read requests per second accepted from each client address; unlimited when 0")
+	clientBurst := flag.Int("client-burst", 20, "read requests a client may send at once before -client-rate applies")
+	drainDelay := flag.Duration("drain-delay", 5*time.Second, "how long to report not ready before draining on shutdown")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "maximum time to drain and stop the service")
 
 	flag.Parse()
 
@@ -30,34 +79,135 @@ func main() {
 	zerolog.DurationFieldUnit = time.Second
 	//	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC1123})
 
+	sourceTTLs, err := models.ParseSourceTTLs(*sourceTTL)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	sourceLimits, err := ratelimit.ParseLimits(*sourceRates)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	smoothingSpeeds, err := smooth.ParseSpeeds(*smoothing)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	transitions := models.DefaultTransitions()
+	transitionOverrides, err := models.ParseTransitions(*statusTransitions)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	for from, to := range transitionOverrides {
+		transitions[from] = to
+	}
+
+	lifecycleLogger := log.With().Str("component", "lifecycle").Logger()
+	manager := lifecycle.New(&lifecycleLogger, *drainDelay)
+
 	var db models.TelemetryReaderWriterChecker
+	var fences models.GeofenceReaderWriter
+	var statuses models.StatusEventReaderWriter
+	var journeys models.TripReaderWriter
+	var stays models.StopReaderWriter
 
 	switch *datastore {
 	case "inmemdb":
-		db = createInMemoryDatabase(*ttl)
+		opts := []inmem.Option{
+			inmem.WithTTL(*ttl),
+			inmem.WithSourceTTL(sourceTTLs),
+			inmem.WithHistory(*historySize, *historyAge),
+			inmem.WithTripAge(*tripAge),
+			inmem.WithStopAge(*stopAge),
+			inmem.WithSnapshot(*snapshotPath),
+		}
+		if *walDir != "" {
+			if *snapshotPath == "" {
+				log.Fatal().Msg("wal-dir requires snapshot-path so the log can be truncated")
+			}
+			opts = append(opts, inmem.WithWAL(openWAL(*walDir, *walSync, *walSyncInterval, *walSegmentSize)))
+		}
+		memdb := createInMemoryDatabase(manager, *expireInterval, opts...)
+		if *snapshotPath != "" {
+			restoreSnapshot(manager, memdb, *snapshotInterval)
+		}
+		db, fences, statuses, journeys, stays = memdb, memdb, memdb, memdb, memdb
 	case "redis":
-		log.Fatal().Str("datastore", *datastore).Err(errors.New("datastore not implemented")).Msg("")
+		rdb := createRedisDatabase(&goredis.Options{
+			Addr:     *redisAddr,
+			Password: *redisPassword,
+			DB:       *redisDB,
+		}, *ttl, redis.WithSourceTTL(sourceTTLs), redis.WithHistory(*historySize, *historyAge), redis.WithTripAge(*tripAge), redis.WithStopAge(*stopAge))
+		sweep(manager, "expire", *expireInterval, func() { rdb.Expire() })
+		db, fences, statuses, journeys, stays = rdb, rdb, rdb, rdb, rdb
 	default:
 		log.Fatal().Str("datastore", *datastore).Err(errors.New("unknown datastore")).Msg("")
 	}
 	log.Info().Str("datastore", *datastore).Msg("datastore created")
+	manager.OnClose("datastore", db.Close)
+
+	var authenticators []auth.Authenticator
+	if *authConfig != "" {
+		authenticators = loadAuthenticators(*authConfig)
+	}
 
 	log.Info().Msg("starting location tracking service")
-	service := service.New(*addr, db, &log.Logger)
+	fenceLogger := log.With().Str("component", "geofence").Logger()
+	statusLogger := log.With().Str("component", "status").Logger()
+	tripLogger := log.With().Str("component", "trips").Logger()
+	stopLogger := log.With().Str("component", "stops").Logger()
+	broker := pubsub.NewBroker(*streamBuffer)
+	tripDetector := trips.NewDetector(journeys, *tripDwell, &tripLogger)
+	stopDetector := stops.NewDetector(stays, *stopRadius, *stopDuration, &stopLogger)
+	sweep(manager, "trips", trips.SweepInterval, tripDetector.Sweep)
+	sweep(manager, "stops", stops.SweepInterval, stopDetector.Sweep)
+	// fences are changed through the monitor so it sees every change made here
+	monitor := geofence.NewMonitor(fences, &fenceLogger)
+	sweep(manager, "geofences", geofence.RefreshInterval, monitor.Refresh)
+	pipeline := ingest.New(db,
+		monitor,
+		status.NewRecorder(statuses, &statusLogger),
+		tripDetector,
+		stopDetector,
+		broker,
+	)
+	pipeline.Rules = models.Plausibility{MaxSpeed: *maxSpeed, MaxFutureSkew: *maxFutureSkew}
+	pipeline.Transitions = transitions
+	pipeline.Smoothing = smooth.New(smoothingSpeeds)
+	pipeline.Odometer = odometer.New(*minMovement)
+	service := service.New(*addr, pipeline, &log.Logger,
+		service.WithGeofences(monitor),
+		service.WithStatusEvents(statuses),
+		service.WithHistory(*historySize, *historyAge),
+		service.WithTrips(journeys),
+		service.WithStops(stays),
+		service.WithHealthChecker(manager.HealthChecker(db)),
+		service.WithAuth(authenticators...),
+		service.WithRateLimits(
+			newLimiter(ratelimit.Limit{Rate: *sourceRate, Burst: *sourceBurst}, sourceLimits),
+			newLimiter(ratelimit.Limit{Rate: *clientRate, Burst: *clientBurst}, nil),
+		),
+		// streams end before the write timeout would cut them off
+		service.WithStream(broker, writeTimeout-5*time.Second),
+	)
 
 	svr := http.Server{
 		Addr:         *addr,
 		Handler:      service.Routes(),
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		WriteTimeout: writeTimeout,
 		IdleTimeout:  60 * time.Second,
 	}
+	// live streams would otherwise hold the server open until they time out
+	svr.RegisterOnShutdown(broker.Close)
+	manager.OnDrain("http", svr.Shutdown)
 
 	go func() {
 		log.Info().Str("host", *addr).Msg("starting http server")
 		err := svr.ListenAndServe()
-		if err != nil {
-			log.Fatal().Err(err)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal().Err(err).Msg("http server failed")
 		}
 	}()
 
@@ -71,26 +221,106 @@ func main() {
 	log.Info().Str("signal", sig.String()).Msg("recived a signal to shutdown...")
 
 	// Try and shutdown the telemety service cleanly
-	_, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
 	defer cancel()
 
+	if err := manager.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msg("unclean shutdown")
+		return
+	}
+	log.Info().Msg("shutdown complete")
 }
 
-func createInMemoryDatabase(expire time.Duration) *inmem.InMemoryDB {
+// sweep calls fn every interval until the manager shuts down
+func sweep(manager *lifecycle.Manager, name string, interval time.Duration, fn func()) {
+	manager.Go(name, func(ctx context.Context) {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+				fn()
+			}
+		}
+	})
+}
+
+func createInMemoryDatabase(manager *lifecycle.Manager, interval time.Duration, opts ...inmem.Option) *inmem.InMemoryDB {
 	dbLogger := log.With().Str("component", "database").Logger()
-	memdb := inmem.New(&dbLogger)
+	memdb := inmem.New(&dbLogger, opts...)
 
-	dbLogger.Info().Dur("Object TTL", expire).Msg("Starting expiration goroutine")
-	go func() {
-		tick := time.Tick(expire)
-		for range tick {
-			memdb.Expire()
-		}
-	}()
+	dbLogger.Info().Dur("Expire interval", interval).Msg("Starting expiration goroutine")
+	sweep(manager, "expire", interval, func() { memdb.Expire() })
 
 	return memdb
 }
 
+// restoreSnapshot restores memdb from its snapshot file and write-ahead log
+// and keeps the snapshot up to date every interval.  A snapshot that can not be restored
+// is logged and replaced, since the fleet repopulates the service as it
+// reports.
+func restoreSnapshot(manager *lifecycle.Manager, memdb *inmem.InMemoryDB, interval time.Duration) {
+	if _, err := memdb.RestoreSnapshot(); err != nil {
+		log.Error().Err(err).Msg("unable to restore snapshot; starting empty")
+	}
+	if _, err := memdb.ReplayWAL(); err != nil {
+		log.Fatal().Err(err).Msg("unable to replay write-ahead log")
+	}
+
+	sweep(manager, "snapshot", interval, func() {
+		if err := memdb.SaveSnapshot(); err != nil {
+			log.Error().Err(err).Msg("unable to save snapshot")
+		}
+	})
+}
+
+func openWAL(dir, mode string, interval time.Duration, segmentSize int64) *wal.Log {
+	walLogger := log.With().Str("component", "wal").Logger()
+	sync, err := wal.ParseSyncMode(mode)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	l, err := wal.Open(dir, wal.Options{SegmentSize: segmentSize, Sync: sync, SyncInterval: interval}, &walLogger)
+	if err != nil {
+		log.Fatal().Err(err).Str("dir", dir).Msg("unable to open write-ahead log")
+	}
+	walLogger.Info().Str("dir", dir).Str("sync", sync.String()).Msg("opened write-ahead log")
+	return l
+}
+
+func loadAuthenticators(path string) []auth.Authenticator {
+	c, err := auth.LoadConfig(path)
+	if err != nil {
+		log.Fatal().Err(err).Str("path", path).Msg("unable to load auth config")
+	}
+	authenticators, err := c.Authenticators()
+	if err != nil {
+		log.Fatal().Err(err).Str("path", path).Msg("")
+	}
+	log.Info().Str("path", path).Int("authenticators", len(authenticators)).Msg("authentication enabled")
+	return authenticators
+}
+
+// newLimiter returns a limiter enforcing limit, or nil when neither limit nor
+// any override limits requests
+func newLimiter(limit ratelimit.Limit, overrides map[string]ratelimit.Limit) *ratelimit.Limiter {
+	if limit.Unlimited() && len(overrides) == 0 {
+		return nil
+	}
+	return ratelimit.New(limit, overrides)
+}
+
+func createRedisDatabase(opts *goredis.Options, expire time.Duration, dbOpts ...redis.Option) *redis.RedisDB {
+	dbLogger := log.With().Str("component", "database").Logger()
+	client := goredis.NewClient(opts)
+
+	dbLogger.Info().Str("addr", opts.Addr).Dur("Object TTL", expire).Msg("Connecting to redis")
+	return redis.New(client, expire, &dbLogger, dbOpts...)
+}
+
 func init() {
 	// Register the prometheus build info collector
 	prometheus.MustRegister(prometheus.NewBuildInfoCollector())
@@ -3,10 +3,12 @@ module scbunn.org/tmp/gps-tracking-service
 go 1.15
 
 require (
+	github.com/alicebob/miniredis/v2 v2.14.1
 	github.com/go-chi/chi v1.5.1
 	github.com/go-chi/cors v1.1.1
 	github.com/go-playground/universal-translator v0.17.0 // indirect
 	github.com/go-playground/validator v9.31.0+incompatible
+	github.com/go-redis/redis/v8 v8.4.4
 	github.com/google/uuid v1.1.2
 	github.com/leodido/go-urn v1.2.0 // indirect
 	github.com/nedscode/memdb v0.0.0-20190730235322-b1504ff22569
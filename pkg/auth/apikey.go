@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"strings"
+)
+
+// APIKeyHeader is the header an API key is sent in
+const APIKeyHeader = "X-API-Key"
+
+// APIKeys authenticates requests carrying a known API key in the X-API-Key
+// header or as an "ApiKey" Authorization header.
+type APIKeys struct {
+	keys map[[sha256.Size]byte]Principal
+}
+
+// NewAPIKeys returns an authenticator accepting the given keys.  Keys are
+// held and looked up by their SHA-256 hash so the lookup does not leak the
+// key through timing.
+func NewAPIKeys(keys map[string]Principal) *APIKeys {
+	a := &APIKeys{keys: make(map[[sha256.Size]byte]Principal, len(keys))}
+	for key, p := range keys {
+		a.keys[sha256.Sum256([]byte(key))] = p
+	}
+	return a
+}
+
+func (a *APIKeys) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if v := r.Header.Get("Authorization"); key == "" && strings.HasPrefix(v, "ApiKey ") {
+		key = strings.TrimSpace(strings.TrimPrefix(v, "ApiKey "))
+	}
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	p, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return &p, nil
+}
@@ -0,0 +1,129 @@
+// Package auth authenticates telemetry sources and API consumers.
+//
+// Sources authenticate with an API key or an HMAC-signed request, readers
+// with a JWT bearer token.  Each successful authentication yields a
+// Principal that the service uses to decide which telemetry sources the
+// caller may report for.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when the request does
+	// not carry credentials of the kind it checks
+	ErrNoCredentials = errors.New("auth: no credentials")
+
+	// ErrInvalidCredentials is returned when the request carries credentials
+	// that can not be verified
+	ErrInvalidCredentials = errors.New("auth: invalid credentials")
+)
+
+// AnySource may be listed in Principal.Sources to allow every source, and
+// AnyTenant in Principal.Tenants to allow reading every tenant
+const (
+	AnySource = "*"
+	AnyTenant = "*"
+)
+
+// Scopes granted to principals
+const (
+	ScopeLocationRead  = "location:read"
+	ScopeLocationWrite = "location:write"
+
+	// ScopeAdmin grants every other scope and access to every tenant
+	ScopeAdmin = "admin"
+)
+
+// Principal is an authenticated caller
+type Principal struct {
+	// Subject identifies the caller, such as a gateway name or token subject
+	Subject string `json:"subject"`
+
+	// Tenant is the tenant the caller belongs to.  Telemetry the caller
+	// reports is stored under this tenant.
+	Tenant string `json:"tenant,omitempty"`
+
+	// Tenants are further tenants whose telemetry the caller may read
+	Tenants []string `json:"tenants,omitempty"`
+
+	// Scopes are the operations the caller may perform
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Sources are the telemetry sources the caller may report for
+	Sources []string `json:"sources,omitempty"`
+}
+
+// HasScope reports whether the principal was granted scope
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadableTenants returns the tenants whose telemetry the principal may
+// read, or nil when it may read every tenant.
+func (p Principal) ReadableTenants() []string {
+	if p.HasScope(ScopeAdmin) {
+		return nil
+	}
+	tenants := []string{p.Tenant}
+	for _, t := range p.Tenants {
+		if t == AnyTenant {
+			return nil
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants
+}
+
+// CanRead reports whether the principal may read telemetry of tenant
+func (p Principal) CanRead(tenant string) bool {
+	tenants := p.ReadableTenants()
+	if tenants == nil {
+		return true
+	}
+	for _, t := range tenants {
+		if t == tenant {
+			return true
+		}
+	}
+	return false
+}
+
+// Owns reports whether the principal may report telemetry for source
+func (p Principal) Owns(source string) bool {
+	for _, s := range p.Sources {
+		if s == source || s == AnySource {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator verifies the credentials of a request
+type Authenticator interface {
+	// Authenticate returns the principal the request authenticates as.
+	// ErrNoCredentials is returned when the request carries no credentials
+	// this authenticator understands.
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying p
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal carried by ctx, if any
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok
+}
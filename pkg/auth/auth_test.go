@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrincipalOwns(t *testing.T) {
+	p := Principal{Sources: []string{"gateway-1"}}
+	if !p.Owns("gateway-1") || p.Owns("gateway-2") {
+		t.Errorf("unexpected ownership for %v", p.Sources)
+	}
+	if !(Principal{Sources: []string{AnySource}}).Owns("gateway-2") {
+		t.Errorf("expected the wildcard to own every source")
+	}
+	if (Principal{}).Owns("") {
+		t.Errorf("expected a principal without sources to own nothing")
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	a := NewAPIKeys(map[string]Principal{"secret-key": {Subject: "gateway-1"}})
+
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		subject string
+		err     error
+	}{
+		{name: "Header", header: APIKeyHeader, value: "secret-key", subject: "gateway-1"},
+		{name: "Authorization", header: "Authorization", value: "ApiKey secret-key", subject: "gateway-1"},
+		{name: "Unknown", header: APIKeyHeader, value: "guess", err: ErrInvalidCredentials},
+		{name: "Missing", err: ErrNoCredentials},
+		{name: "OtherScheme", header: "Authorization", value: "Bearer token", err: ErrNoCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			p, err := a.Authenticate(r)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v; got %v", tt.err, err)
+			}
+			if err == nil && p.Subject != tt.subject {
+				t.Errorf("expected subject %s; got %s", tt.subject, p.Subject)
+			}
+		})
+	}
+}
+
+func signedRequest(secret, keyID string, ts time.Time, body string) *http.Request {
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/location/?x=1", strings.NewReader(body))
+	signature := Sign([]byte(secret), http.MethodPost, "/api/v1/location/?x=1", timestamp, []byte(body))
+	r.Header.Set("Authorization", HMACScheme+" keyId="+keyID+", signature="+signature)
+	r.Header.Set(TimestampHeader, timestamp)
+	return r
+}
+
+func TestHMAC(t *testing.T) {
+	h := NewHMAC(map[string]HMACKey{
+		"gw-1": {Secret: []byte("shh"), Principal: Principal{Subject: "gateway-1"}},
+	}, time.Minute)
+	now := time.Now()
+	body := `{"source":"gateway-1"}`
+
+	r := signedRequest("shh", "gw-1", now, body)
+	p, err := h.Authenticate(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if p.Subject != "gateway-1" {
+		t.Errorf("unexpected subject: %s", p.Subject)
+	}
+	if data, err := ioutil.ReadAll(r.Body); err != nil || string(data) != body {
+		t.Errorf("expected the body to be readable after verification; got %q", data)
+	}
+
+	tampered := signedRequest("shh", "gw-1", now, body)
+	tampered.Body = http.NoBody
+
+	tests := []struct {
+		name string
+		r    *http.Request
+	}{
+		{name: "WrongSecret", r: signedRequest("guess", "gw-1", now, body)},
+		{name: "UnknownKey", r: signedRequest("shh", "gw-2", now, body)},
+		{name: "Stale", r: signedRequest("shh", "gw-1", now.Add(-time.Hour), body)},
+		{name: "Future", r: signedRequest("shh", "gw-1", now.Add(time.Hour), body)},
+		{name: "TamperedBody", r: tampered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := h.Authenticate(tt.r); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("expected invalid credentials; got %v", err)
+			}
+		})
+	}
+
+	if _, err := h.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil)); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("expected no credentials; got %v", err)
+	}
+}
+
+func TestJWT(t *testing.T) {
+	secret := []byte("jwt-secret")
+	j := NewJWT(secret, "issuer", "gps")
+	now := time.Now()
+
+	valid := Claims{Subject: "dashboard", Issuer: "issuer", Audience: audience{"gps"}, ExpiresAt: now.Add(time.Hour).Unix()}
+
+	sign := func(key []byte, c Claims) string {
+		token, err := SignJWT(key, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	withClaims := func(f func(c *Claims)) string {
+		c := valid
+		f(&c)
+		return sign(secret, c)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{name: "Valid", token: sign(secret, valid)},
+		{name: "WrongSecret", token: sign([]byte("guess"), valid), err: ErrInvalidCredentials},
+		{name: "Expired", token: withClaims(func(c *Claims) { c.ExpiresAt = now.Add(-time.Hour).Unix() }), err: ErrInvalidCredentials},
+		{name: "NoExpiry", token: withClaims(func(c *Claims) { c.ExpiresAt = 0 }), err: ErrInvalidCredentials},
+		{name: "NotYetValid", token: withClaims(func(c *Claims) { c.NotBefore = now.Add(time.Hour).Unix() }), err: ErrInvalidCredentials},
+		{name: "WrongIssuer", token: withClaims(func(c *Claims) { c.Issuer = "other" }), err: ErrInvalidCredentials},
+		{name: "WrongAudience", token: withClaims(func(c *Claims) { c.Audience = audience{"other"} }), err: ErrInvalidCredentials},
+		{name: "AlgNone", token: "eyJhbGciOiJub25lIn0.eyJzdWIiOiJ4In0.", err: ErrInvalidCredentials},
+		{name: "Malformed", token: "not-a-token", err: ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			p, err := j.Authenticate(r)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v; got %v", tt.err, err)
+			}
+			if err == nil && p.Subject != "dashboard" {
+				t.Errorf("unexpected subject: %s", p.Subject)
+			}
+		})
+	}
+}
+
+func TestAudienceString(t *testing.T) {
+	secret := []byte("s")
+	header := rawURL.EncodeToString([]byte(`{"alg":"HS256"}`))
+	claims := rawURL.EncodeToString([]byte(`{"sub":"x","aud":"gps","exp":4102444800}`))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(header + "." + claims))
+	token := header + "." + claims + "." + rawURL.EncodeToString(mac.Sum(nil))
+
+	c, err := NewJWT(secret, "", "gps").Verify(token)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if !c.Audience.contains("gps") {
+		t.Errorf("expected a string audience to be accepted; got %v", c.Audience)
+	}
+}
+
+func TestConfigAuthenticators(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "auth.json")
+	ioutil.WriteFile(path, []byte(`{
+		"apiKeys": [{"key": "k1", "subject": "gateway-1", "sources": ["gateway-1"]}],
+		"hmacKeys": [{"id": "gw-2", "secret": "s2", "subject": "gateway-2", "sources": ["gateway-2"]}],
+		"jwt": {"secret": "s3"},
+		"maxSkew": "1m"
+	}`), 0o600)
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("could not load config: %s", err.Error())
+	}
+	authenticators, err := c.Authenticators()
+	if err != nil || len(authenticators) != 3 {
+		t.Fatalf("expected 3 authenticators; got %d, %v", len(authenticators), err)
+	}
+
+	for _, bad := range []Config{
+		{},
+		{APIKeys: []APIKeyConfig{{Key: ""}}},
+		{HMACKeys: []HMACKeyConfig{{Id: "gw"}}},
+		{JWT: &JWTConfig{}},
+		{HMACKeys: []HMACKeyConfig{{Id: "gw", Secret: "s"}}, MaxSkew: "soon"},
+	} {
+		if _, err := bad.Authenticators(); err == nil {
+			t.Errorf("expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestPrincipalScopesAndTenants(t *testing.T) {
+	reader := Principal{Tenant: "acme", Tenants: []string{"globex"}, Scopes: []string{ScopeLocationRead}}
+	if !reader.HasScope(ScopeLocationRead) || reader.HasScope(ScopeLocationWrite) {
+		t.Errorf("unexpected scopes for %v", reader.Scopes)
+	}
+	if !reader.CanRead("acme") || !reader.CanRead("globex") || reader.CanRead("initech") {
+		t.Errorf("unexpected tenants readable by %+v", reader)
+	}
+
+	admin := Principal{Tenant: "acme", Scopes: []string{ScopeAdmin}}
+	if !admin.HasScope(ScopeLocationWrite) || admin.ReadableTenants() != nil {
+		t.Errorf("expected admin to hold every scope and tenant")
+	}
+	if (Principal{Tenants: []string{AnyTenant}}).ReadableTenants() != nil {
+		t.Errorf("expected the tenant wildcard to allow every tenant")
+	}
+}
+
+func TestClaimsPrincipal(t *testing.T) {
+	p := Claims{Subject: "dashboard", Scope: "location:read  admin", Tenant: "acme", Tenants: []string{"globex"}}.Principal()
+	if p.Subject != "dashboard" || p.Tenant != "acme" || len(p.Tenants) != 1 || len(p.Scopes) != 2 || !p.HasScope(ScopeAdmin) {
+		t.Errorf("unexpected principal: %+v", p)
+	}
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Config lists the credentials accepted by the service.  It is loaded from a
+// JSON file such as
+//
+//	{
+//	  "apiKeys": [{"key": "...", "subject": "gateway-1", "tenant": "acme", "scopes": ["location:write"], "sources": ["gateway-1"]}],
+//	  "hmacKeys": [{"id": "gw-2", "secret": "...", "subject": "gateway-2", "sources": ["gateway-2"]}],
+//	  "jwt": {"secret": "...", "issuer": "https://auth.example.com", "audience": "gps-tracking"}
+//	}
+type Config struct {
+	APIKeys  []APIKeyConfig  `json:"apiKeys"`
+	HMACKeys []HMACKeyConfig `json:"hmacKeys"`
+	JWT      *JWTConfig      `json:"jwt"`
+
+	// MaxSkew is how far the timestamp of a signed request may be from the
+	// server clock, such as "5m"
+	MaxSkew string `json:"maxSkew"`
+}
+
+type APIKeyConfig struct {
+	Key string `json:"key"`
+	Principal
+}
+
+type HMACKeyConfig struct {
+	Id     string `json:"id"`
+	Secret string `json:"secret"`
+	Principal
+}
+
+type JWTConfig struct {
+	Secret   string `json:"secret"`
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+}
+
+// LoadConfig reads the credentials config from the JSON file at path
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid auth config: %w", err)
+	}
+	return &c, nil
+}
+
+// Authenticators returns the authenticators for the configured credentials
+func (c *Config) Authenticators() ([]Authenticator, error) {
+	var authenticators []Authenticator
+
+	if len(c.APIKeys) > 0 {
+		keys := make(map[string]Principal, len(c.APIKeys))
+		for _, k := range c.APIKeys {
+			if k.Key == "" {
+				return nil, fmt.Errorf("invalid auth config: api key of %q is empty", k.Subject)
+			}
+			keys[k.Key] = k.Principal
+		}
+		authenticators = append(authenticators, NewAPIKeys(keys))
+	}
+
+	if len(c.HMACKeys) > 0 {
+		var skew time.Duration
+		if c.MaxSkew != "" {
+			d, err := time.ParseDuration(c.MaxSkew)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth config: maxSkew: %w", err)
+			}
+			skew = d
+		}
+		keys := make(map[string]HMACKey, len(c.HMACKeys))
+		for _, k := range c.HMACKeys {
+			if k.Id == "" || k.Secret == "" {
+				return nil, fmt.Errorf("invalid auth config: hmac key of %q needs an id and secret", k.Subject)
+			}
+			keys[k.Id] = HMACKey{Secret: []byte(k.Secret), Principal: k.Principal}
+		}
+		authenticators = append(authenticators, NewHMAC(keys, skew))
+	}
+
+	if c.JWT != nil {
+		if c.JWT.Secret == "" {
+			return nil, errors.New("invalid auth config: jwt secret is empty")
+		}
+		authenticators = append(authenticators, NewJWT([]byte(c.JWT.Secret), c.JWT.Issuer, c.JWT.Audience))
+	}
+
+	if len(authenticators) == 0 {
+		return nil, errors.New("invalid auth config: no credentials configured")
+	}
+	return authenticators, nil
+}
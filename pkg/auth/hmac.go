@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HMACScheme is the Authorization scheme of signed requests
+	HMACScheme = "HMAC-SHA256"
+
+	// TimestampHeader carries the unix time a signed request was made
+	TimestampHeader = "X-Timestamp"
+
+	// DefaultMaxSkew is how far the timestamp of a signed request may be
+	// from the server clock
+	DefaultMaxSkew = 5 * time.Minute
+
+	// maxSignedBody is the largest body read to verify a signature
+	maxSignedBody = 10 << 20
+)
+
+// HMACKey is a shared secret and the principal that signs with it
+type HMACKey struct {
+	Secret    []byte
+	Principal Principal
+}
+
+// HMAC authenticates requests signed with a shared secret.  A signed request
+// carries
+//
+//	Authorization: HMAC-SHA256 keyId=<id>, signature=<hex>
+//	X-Timestamp: <unix seconds>
+//
+// where the signature is the hex encoded HMAC-SHA256 of StringToSign.
+type HMAC struct {
+	keys    map[string]HMACKey
+	maxSkew time.Duration
+	now     func() time.Time
+}
+
+// NewHMAC returns an authenticator accepting requests signed with the given
+// keys, indexed by key id, and made within maxSkew of the server clock.
+func NewHMAC(keys map[string]HMACKey, maxSkew time.Duration) *HMAC {
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	return &HMAC{keys: keys, maxSkew: maxSkew, now: time.Now}
+}
+
+// StringToSign returns the string a request is signed over: the method,
+// request URI, timestamp, and hex encoded SHA-256 of the body separated by
+// newlines.
+func StringToSign(method, uri, timestamp string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return strings.Join([]string{method, uri, timestamp, hex.EncodeToString(sum[:])}, "\n")
+}
+
+// Sign returns the signature of a request for secret
+func Sign(secret []byte, method, uri, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(StringToSign(method, uri, timestamp, body)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *HMAC) Authenticate(r *http.Request) (*Principal, error) {
+	v := r.Header.Get("Authorization")
+	if !strings.HasPrefix(v, HMACScheme+" ") {
+		return nil, ErrNoCredentials
+	}
+
+	params := parseParams(strings.TrimPrefix(v, HMACScheme+" "))
+	key, ok := h.keys[params["keyId"]]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key", ErrInvalidCredentials)
+	}
+	signature, err := hex.DecodeString(params["signature"])
+	if err != nil || len(signature) == 0 {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidCredentials)
+	}
+
+	timestamp := r.Header.Get(TimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing or malformed %s", ErrInvalidCredentials, TimestampHeader)
+	}
+	if skew := h.now().Sub(time.Unix(unix, 0)); skew > h.maxSkew || skew < -h.maxSkew {
+		return nil, fmt.Errorf("%w: request timestamp outside the allowed skew", ErrInvalidCredentials)
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	expected, _ := hex.DecodeString(Sign(key.Secret, r.Method, r.URL.RequestURI(), timestamp, body))
+	if !hmac.Equal(signature, expected) {
+		return nil, fmt.Errorf("%w: signature mismatch", ErrInvalidCredentials)
+	}
+
+	p := key.Principal
+	return &p, nil
+}
+
+// readBody reads the request body and replaces it so handlers can read it
+// again
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSignedBody {
+		return nil, fmt.Errorf("%w: signed body too large", ErrInvalidCredentials)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// parseParams parses comma separated key=value pairs
+func parseParams(v string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range strings.Split(v, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return params
+}
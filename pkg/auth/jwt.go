@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultLeeway is the clock skew tolerated when checking token times
+const DefaultLeeway = 30 * time.Second
+
+// Claims are the JWT claims understood by the service
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+
+	// Scope is the space separated list of scopes granted to the bearer
+	Scope string `json:"scope,omitempty"`
+
+	// Tenant is the tenant of the bearer and Tenants the further tenants
+	// it may read
+	Tenant  string   `json:"tenant,omitempty"`
+	Tenants []string `json:"tenants,omitempty"`
+
+	// Sources are the telemetry sources the bearer may report for
+	Sources []string `json:"sources,omitempty"`
+}
+
+// Principal returns the principal the claims describe
+func (c Claims) Principal() *Principal {
+	return &Principal{
+		Subject: c.Subject,
+		Tenant:  c.Tenant,
+		Tenants: c.Tenants,
+		Scopes:  strings.Fields(c.Scope),
+		Sources: c.Sources,
+	}
+}
+
+// audience is a JWT audience, which may be a single string or a list
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(v string) bool {
+	for _, aud := range a {
+		if aud == v {
+			return true
+		}
+	}
+	return false
+}
+
+// JWT authenticates requests carrying an HS256 signed JSON Web Token as a
+// bearer token.
+type JWT struct {
+	secret   []byte
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+// NewJWT returns an authenticator accepting tokens signed with secret.  When
+// issuer or audience are not empty the token must carry them.
+func NewJWT(secret []byte, issuer, audience string) *JWT {
+	return &JWT{
+		secret:   secret,
+		issuer:   issuer,
+		audience: audience,
+		leeway:   DefaultLeeway,
+		now:      time.Now,
+	}
+}
+
+var rawURL = base64.RawURLEncoding
+
+// SignJWT returns claims as a token signed with secret using HS256
+func SignJWT(secret []byte, claims Claims) (string, error) {
+	header := rawURL.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + rawURL.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + rawURL.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (j *JWT) Authenticate(r *http.Request) (*Principal, error) {
+	v := r.Header.Get("Authorization")
+	if !strings.HasPrefix(v, "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := j.Verify(strings.TrimSpace(strings.TrimPrefix(v, "Bearer ")))
+	if err != nil {
+		return nil, err
+	}
+	return claims.Principal(), nil
+}
+
+// Verify checks the signature and times of token and returns its claims
+func (j *JWT) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidCredentials)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	data, err := rawURL.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(data, &header) != nil {
+		return nil, fmt.Errorf("%w: malformed token header", ErrInvalidCredentials)
+	}
+	// only accept the algorithm we sign with; never "none"
+	if header.Alg != "HS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidCredentials, header.Alg)
+	}
+
+	signature, err := rawURL.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed token signature", ErrInvalidCredentials)
+	}
+	mac := hmac.New(sha256.New, j.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, fmt.Errorf("%w: signature mismatch", ErrInvalidCredentials)
+	}
+
+	var claims Claims
+	data, err = rawURL.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(data, &claims) != nil {
+		return nil, fmt.Errorf("%w: malformed token claims", ErrInvalidCredentials)
+	}
+
+	now := j.now()
+	if claims.ExpiresAt == 0 {
+		return nil, fmt.Errorf("%w: token has no expiry", ErrInvalidCredentials)
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(j.leeway)) {
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidCredentials)
+	}
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-j.leeway)) {
+		return nil, fmt.Errorf("%w: token not yet valid", ErrInvalidCredentials)
+	}
+	if j.issuer != "" && claims.Issuer != j.issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidCredentials)
+	}
+	if j.audience != "" && !claims.Audience.contains(j.audience) {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidCredentials)
+	}
+	return &claims, nil
+}
@@ -0,0 +1,132 @@
+// Package geo provides the spherical geometry used to index and query
+// fleet positions.
+package geo
+
+import "math"
+
+// EarthRadius is the mean radius of the earth in meters
+const EarthRadius = 6371008.8
+
+// Point is a location on the globe in decimal degrees
+type Point struct {
+	Lat float64 `json:"latitude"`
+	Lon float64 `json:"longitude"`
+}
+
+// Shape is an area on the globe that can be searched through a spatial index
+type Shape interface {
+	// Bounds returns boxes that together cover the whole shape.  None of the
+	// returned boxes cross the antimeridian.
+	Bounds() []BBox
+
+	// Contains reports whether p lies within the shape
+	Contains(p Point) bool
+}
+
+// BBox is a latitude/longitude aligned rectangle in decimal degrees.  A box
+// whose MinLon is greater than its MaxLon crosses the antimeridian.
+type BBox struct {
+	MinLat float64 `json:"minLatitude"`
+	MinLon float64 `json:"minLongitude"`
+	MaxLat float64 `json:"maxLatitude"`
+	MaxLon float64 `json:"maxLongitude"`
+}
+
+// Contains reports whether p lies within the box, edges included.
+func (b BBox) Contains(p Point) bool {
+	if p.Lat < b.MinLat || p.Lat > b.MaxLat {
+		return false
+	}
+	if b.MinLon > b.MaxLon {
+		return p.Lon >= b.MinLon || p.Lon <= b.MaxLon
+	}
+	return p.Lon >= b.MinLon && p.Lon <= b.MaxLon
+}
+
+// Bounds returns the box itself, split in two if it crosses the antimeridian
+func (b BBox) Bounds() []BBox {
+	if b.MinLon > b.MaxLon {
+		return []BBox{
+			{MinLat: b.MinLat, MinLon: b.MinLon, MaxLat: b.MaxLat, MaxLon: 180},
+			{MinLat: b.MinLat, MinLon: -180, MaxLat: b.MaxLat, MaxLon: b.MaxLon},
+		}
+	}
+	return []BBox{b}
+}
+
+// Circle is every point within Radius meters of Center
+type Circle struct {
+	Center Point
+	Radius float64
+}
+
+// Contains reports whether p is within the radius of the circle
+func (c Circle) Contains(p Point) bool {
+	return Distance(c.Center, p) <= c.Radius
+}
+
+// Bounds returns the boxes covering the circle
+func (c Circle) Bounds() []BBox {
+	return BoundingBoxes(c.Center, c.Radius)
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func degrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// Distance returns the great-circle distance in meters between a and b
+// using the haversine formula.
+func Distance(a, b Point) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat := lat2 - lat1
+	dLon := radians(b.Lon - a.Lon)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// BoundingBoxes returns the boxes that together cover every point within
+// radius meters of center.  A single box is returned unless the circle
+// crosses the antimeridian.
+func BoundingBoxes(center Point, radius float64) []BBox {
+	angular := radius / EarthRadius
+	lat := radians(center.Lat)
+	lon := radians(center.Lon)
+
+	minLat := lat - angular
+	maxLat := lat + angular
+
+	// the circle covers a pole, so every longitude is in range
+	if maxLat >= math.Pi/2 || minLat <= -math.Pi/2 {
+		return []BBox{{
+			MinLat: math.Max(degrees(minLat), -90),
+			MinLon: -180,
+			MaxLat: math.Min(degrees(maxLat), 90),
+			MaxLon: 180,
+		}}
+	}
+
+	dLon := math.Asin(math.Sin(angular) / math.Cos(lat))
+	minLon := degrees(lon - dLon)
+	maxLon := degrees(lon + dLon)
+
+	switch {
+	case minLon < -180:
+		return []BBox{
+			{MinLat: degrees(minLat), MinLon: minLon + 360, MaxLat: degrees(maxLat), MaxLon: 180},
+			{MinLat: degrees(minLat), MinLon: -180, MaxLat: degrees(maxLat), MaxLon: maxLon},
+		}
+	case maxLon > 180:
+		return []BBox{
+			{MinLat: degrees(minLat), MinLon: minLon, MaxLat: degrees(maxLat), MaxLon: 180},
+			{MinLat: degrees(minLat), MinLon: -180, MaxLat: degrees(maxLat), MaxLon: maxLon - 360},
+		}
+	}
+
+	return []BBox{{MinLat: degrees(minLat), MinLon: minLon, MaxLat: degrees(maxLat), MaxLon: maxLon}}
+}
@@ -0,0 +1,64 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Point
+		want float64
+	}{
+		{name: "SamePoint", a: Point{45, -122}, b: Point{45, -122}, want: 0},
+		{name: "OneDegreeOfLatitude", a: Point{0, 0}, b: Point{1, 0}, want: 111195},
+		{name: "PortlandToSeattle", a: Point{45.5152, -122.6784}, b: Point{47.6062, -122.3321}, want: 233700},
+		{name: "AcrossAntimeridian", a: Point{0, 179.5}, b: Point{0, -179.5}, want: 111195},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Distance(tt.a, tt.b)
+			// within 0.5% of the expected distance
+			if math.Abs(got-tt.want) > tt.want*0.005+1 {
+				t.Errorf("got %f meters; want %f meters", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBoundingBoxes(t *testing.T) {
+	tests := []struct {
+		name   string
+		center Point
+		radius float64
+		boxes  int
+		inside []Point
+	}{
+		{name: "Simple", center: Point{45, -122}, radius: 5000, boxes: 1, inside: []Point{{45.04, -122}, {45, -122.06}}},
+		{name: "Antimeridian", center: Point{0, 179.99}, radius: 5000, boxes: 2, inside: []Point{{0, -179.99}, {0, 179.97}}},
+		{name: "Pole", center: Point{89.99, 0}, radius: 5000, boxes: 1, inside: []Point{{89.99, 180}, {89.98, -90}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			boxes := BoundingBoxes(tt.center, tt.radius)
+			if len(boxes) != tt.boxes {
+				t.Fatalf("got %d boxes; want %d: %v", len(boxes), tt.boxes, boxes)
+			}
+			for _, p := range tt.inside {
+				if Distance(tt.center, p) > tt.radius {
+					t.Fatalf("test point %v is not within the radius", p)
+				}
+				covered := false
+				for _, b := range boxes {
+					covered = covered || b.Contains(p)
+				}
+				if !covered {
+					t.Errorf("point %v is not covered by %v", p, boxes)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package geo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrInvalidGeometry is returned when a geometry can not be used as an area
+var ErrInvalidGeometry = errors.New("geo: invalid geometry")
+
+// Polygon is an area enclosed by an exterior ring with optional holes.  The
+// first ring is the exterior and any further rings are holes.  Rings are
+// treated as planar in latitude/longitude, which is accurate for areas the
+// size of a depot or city.
+type Polygon [][]Point
+
+// Contains reports whether p is inside the exterior ring and outside of
+// every hole.
+func (poly Polygon) Contains(p Point) bool {
+	if len(poly) == 0 || !inRing(poly[0], p) {
+		return false
+	}
+	for _, hole := range poly[1:] {
+		if inRing(hole, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Bounds returns the box enclosing the exterior ring
+func (poly Polygon) Bounds() []BBox {
+	if len(poly) == 0 {
+		return nil
+	}
+	b := BBox{MinLat: 90, MinLon: 180, MaxLat: -90, MaxLon: -180}
+	for _, p := range poly[0] {
+		b.MinLat = math.Min(b.MinLat, p.Lat)
+		b.MinLon = math.Min(b.MinLon, p.Lon)
+		b.MaxLat = math.Max(b.MaxLat, p.Lat)
+		b.MaxLon = math.Max(b.MaxLon, p.Lon)
+	}
+	return []BBox{b}
+}
+
+// inRing uses ray casting to determine if p is enclosed by ring
+func inRing(ring []Point, p Point) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lon < (b.Lon-a.Lon)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// ParsePolygon decodes a GeoJSON Polygon geometry, or a Feature holding one,
+// into a Polygon.  GeoJSON positions are ordered longitude, latitude.
+func ParsePolygon(data []byte) (Polygon, error) {
+	var geometry struct {
+		Type        string          `json:"type"`
+		Coordinates [][][]float64   `json:"coordinates"`
+		Geometry    json.RawMessage `json:"geometry"`
+	}
+	if err := json.Unmarshal(data, &geometry); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidGeometry, err)
+	}
+
+	switch geometry.Type {
+	case "Feature":
+		return ParsePolygon(geometry.Geometry)
+	case "Polygon":
+	default:
+		return nil, fmt.Errorf("%w: unsupported type %q", ErrInvalidGeometry, geometry.Type)
+	}
+
+	if len(geometry.Coordinates) == 0 {
+		return nil, fmt.Errorf("%w: polygon has no rings", ErrInvalidGeometry)
+	}
+
+	poly := make(Polygon, 0, len(geometry.Coordinates))
+	for _, coords := range geometry.Coordinates {
+		// a closed ring needs at least 3 distinct positions plus the closing one
+		if len(coords) < 4 {
+			return nil, fmt.Errorf("%w: ring has fewer than 4 positions", ErrInvalidGeometry)
+		}
+		ring := make([]Point, 0, len(coords))
+		for _, c := range coords {
+			if len(c) < 2 || math.Abs(c[0]) > 180 || math.Abs(c[1]) > 90 {
+				return nil, fmt.Errorf("%w: invalid position %v", ErrInvalidGeometry, c)
+			}
+			ring = append(ring, Point{Lat: c[1], Lon: c[0]})
+		}
+		poly = append(poly, ring)
+	}
+	return poly, nil
+}
+
+// MarshalJSON encodes the polygon as a GeoJSON Polygon geometry
+func (poly Polygon) MarshalJSON() ([]byte, error) {
+	coordinates := make([][][]float64, 0, len(poly))
+	for _, ring := range poly {
+		coords := make([][]float64, 0, len(ring))
+		for _, p := range ring {
+			coords = append(coords, []float64{p.Lon, p.Lat})
+		}
+		coordinates = append(coordinates, coords)
+	}
+	return json.Marshal(struct {
+		Type        string        `json:"type"`
+		Coordinates [][][]float64 `json:"coordinates"`
+	}{Type: "Polygon", Coordinates: coordinates})
+}
+
+// UnmarshalJSON decodes a GeoJSON Polygon geometry into the polygon
+func (poly *Polygon) UnmarshalJSON(data []byte) error {
+	parsed, err := ParsePolygon(data)
+	if err != nil {
+		return err
+	}
+	*poly = parsed
+	return nil
+}
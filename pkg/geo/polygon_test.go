@@ -0,0 +1,104 @@
+package geo
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const depot = `{
+	"type": "Polygon",
+	"coordinates": [
+		[[-122.70, 45.50], [-122.60, 45.50], [-122.60, 45.60], [-122.70, 45.60], [-122.70, 45.50]],
+		[[-122.66, 45.54], [-122.64, 45.54], [-122.64, 45.56], [-122.66, 45.56], [-122.66, 45.54]]
+	]
+}`
+
+func TestParsePolygon(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   bool
+	}{
+		{name: "Polygon", input: depot},
+		{name: "Feature", input: `{"type": "Feature", "properties": {}, "geometry": ` + depot + `}`},
+		{name: "Point", input: `{"type": "Point", "coordinates": [1, 2]}`, err: true},
+		{name: "OpenRing", input: `{"type": "Polygon", "coordinates": [[[0, 0], [1, 1], [0, 0]]]}`, err: true},
+		{name: "OutOfRange", input: `{"type": "Polygon", "coordinates": [[[0, 0], [0, 95], [1, 1], [0, 0]]]}`, err: true},
+		{name: "NotJSON", input: `polygon`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePolygon([]byte(tt.input))
+			if tt.err && !errors.Is(err, ErrInvalidGeometry) {
+				t.Errorf("expected an invalid geometry error; got %v", err)
+			}
+			if !tt.err && err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+			}
+		})
+	}
+}
+
+func TestPolygonContains(t *testing.T) {
+	poly, err := ParsePolygon([]byte(depot))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		p    Point
+		want bool
+	}{
+		{name: "Inside", p: Point{Lat: 45.52, Lon: -122.68}, want: true},
+		{name: "Outside", p: Point{Lat: 45.40, Lon: -122.68}, want: false},
+		{name: "InHole", p: Point{Lat: 45.55, Lon: -122.65}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := poly.Contains(tt.p); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+
+	bounds := poly.Bounds()
+	if len(bounds) != 1 || bounds[0] != (BBox{MinLat: 45.50, MinLon: -122.70, MaxLat: 45.60, MaxLon: -122.60}) {
+		t.Errorf("unexpected bounds: %v", bounds)
+	}
+}
+
+func TestBBoxAntimeridian(t *testing.T) {
+	box := BBox{MinLat: -10, MinLon: 170, MaxLat: 10, MaxLon: -170}
+	if !box.Contains(Point{Lat: 0, Lon: 175}) || !box.Contains(Point{Lat: 0, Lon: -175}) {
+		t.Errorf("box should contain both sides of the antimeridian")
+	}
+	if box.Contains(Point{Lat: 0, Lon: 0}) {
+		t.Errorf("box should not contain the prime meridian")
+	}
+	if len(box.Bounds()) != 2 {
+		t.Errorf("expected box to be split at the antimeridian: %v", box.Bounds())
+	}
+}
+
+func TestPolygonJSON(t *testing.T) {
+	var poly Polygon
+	if err := json.Unmarshal([]byte(depot), &poly); err != nil {
+		t.Fatalf("could not unmarshal polygon: %s", err.Error())
+	}
+
+	data, err := json.Marshal(poly)
+	if err != nil {
+		t.Fatalf("could not marshal polygon: %s", err.Error())
+	}
+
+	again, err := ParsePolygon(data)
+	if err != nil {
+		t.Fatalf("marshaled polygon is not valid GeoJSON: %s", err.Error())
+	}
+	if len(again) != 2 || again[0][1] != (Point{Lat: 45.50, Lon: -122.60}) {
+		t.Errorf("polygon did not survive a round trip: %v", again)
+	}
+}
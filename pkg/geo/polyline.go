@@ -0,0 +1,39 @@
+package geo
+
+import (
+	"math"
+	"strings"
+)
+
+// DefaultPrecision is the number of decimal places of coordinates in an
+// encoded polyline, as used by Google.  Mapbox also reads polylines with a
+// precision of 6.
+const DefaultPrecision = 5
+
+// EncodePolyline encodes points with the Google encoded polyline algorithm
+// keeping precision decimal places of each coordinate
+func EncodePolyline(points []Point, precision int) string {
+	factor := math.Pow10(precision)
+	var b strings.Builder
+	var lat, lon int64
+	for _, p := range points {
+		nextLat, nextLon := int64(math.Round(p.Lat*factor)), int64(math.Round(p.Lon*factor))
+		encodeValue(&b, nextLat-lat)
+		encodeValue(&b, nextLon-lon)
+		lat, lon = nextLat, nextLon
+	}
+	return b.String()
+}
+
+// encodeValue writes the delta v of a coordinate as 5 bit chunks
+func encodeValue(b *strings.Builder, v int64) {
+	u := uint64(v) << 1
+	if v < 0 {
+		u = ^u
+	}
+	for u >= 0x20 {
+		b.WriteByte(byte(0x20|(u&0x1f)) + 63)
+		u >>= 5
+	}
+	b.WriteByte(byte(u) + 63)
+}
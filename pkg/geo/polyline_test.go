@@ -0,0 +1,26 @@
+package geo
+
+import "testing"
+
+func TestEncodePolyline(t *testing.T) {
+	// the example of the Google encoded polyline algorithm
+	points := []Point{{Lat: 38.5, Lon: -120.2}, {Lat: 40.7, Lon: -120.95}, {Lat: 43.252, Lon: -126.453}}
+
+	tests := []struct {
+		name      string
+		points    []Point
+		precision int
+		want      string
+	}{
+		{name: "Google", points: points, precision: DefaultPrecision, want: "_p~iF~ps|U_ulLnnqC_mqNvxq`@"},
+		{name: "Precision6", points: points[:1], precision: 6, want: "_izlhA~rlgdF"},
+		{name: "Empty", precision: DefaultPrecision, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EncodePolyline(tt.points, tt.precision); got != tt.want {
+				t.Errorf("expected %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package geo
+
+import (
+	"container/heap"
+	"math"
+)
+
+// planar projects points onto a plane, in meters, centered on the first
+// point.  The projection is accurate enough over the extent of a track.
+func planar(points []Point) [][2]float64 {
+	xy := make([][2]float64, len(points))
+	if len(points) == 0 {
+		return xy
+	}
+	origin := points[0]
+	scale := math.Cos(radians(origin.Lat))
+	for i, p := range points {
+		dLon := p.Lon - origin.Lon
+		// keep tracks crossing the antimeridian continuous
+		if dLon > 180 {
+			dLon -= 360
+		} else if dLon < -180 {
+			dLon += 360
+		}
+		xy[i] = [2]float64{EarthRadius * radians(dLon) * scale, EarthRadius * radians(p.Lat-origin.Lat)}
+	}
+	return xy
+}
+
+// segmentDistance returns the distance from p to the segment from a to b
+func segmentDistance(p, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	if dx == 0 && dy == 0 {
+		return math.Hypot(p[0]-a[0], p[1]-a[1])
+	}
+	t := ((p[0]-a[0])*dx + (p[1]-a[1])*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+	return math.Hypot(p[0]-a[0]-t*dx, p[1]-a[1]-t*dy)
+}
+
+// DouglasPeucker simplifies a line with the Douglas-Peucker algorithm and
+// returns the indexes of the points kept, in order.  Every dropped point
+// lies within tolerance meters of the simplified line.  The first and last
+// points are always kept.
+func DouglasPeucker(points []Point, tolerance float64) []int {
+	if len(points) < 3 {
+		return allIndexes(len(points))
+	}
+
+	xy := planar(points)
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+	stack := [][2]int{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		span := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		farthest, distance := -1, tolerance
+		for i := span[0] + 1; i < span[1]; i++ {
+			if d := segmentDistance(xy[i], xy[span[0]], xy[span[1]]); d > distance {
+				farthest, distance = i, d
+			}
+		}
+		if farthest < 0 {
+			continue
+		}
+		keep[farthest] = true
+		stack = append(stack, [2]int{span[0], farthest}, [2]int{farthest, span[1]})
+	}
+
+	var kept []int
+	for i, k := range keep {
+		if k {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
+
+// vertex is a point of a line being simplified with Visvalingam-Whyatt
+type vertex struct {
+	index      int
+	area       float64
+	prev, next *vertex
+	// position is the place of the vertex in the heap, or -1 once dropped
+	position int
+}
+
+type vertexHeap []*vertex
+
+func (h vertexHeap) Len() int           { return len(h) }
+func (h vertexHeap) Less(i, j int) bool { return h[i].area < h[j].area }
+func (h vertexHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].position, h[j].position = i, j
+}
+func (h *vertexHeap) Push(x interface{}) {
+	v := x.(*vertex)
+	v.position = len(*h)
+	*h = append(*h, v)
+}
+func (h *vertexHeap) Pop() interface{} {
+	old := *h
+	v := old[len(old)-1]
+	*h = old[:len(old)-1]
+	v.position = -1
+	return v
+}
+
+// triangleArea returns the area of the triangle of a, b and c
+func triangleArea(a, b, c [2]float64) float64 {
+	return math.Abs((b[0]-a[0])*(c[1]-a[1])-(c[0]-a[0])*(b[1]-a[1])) / 2
+}
+
+// Visvalingam simplifies a line with the Visvalingam-Whyatt algorithm and
+// returns the indexes of the points kept, in order.  Points are dropped
+// while the triangle a point forms with its neighbours is smaller than a
+// tolerance meters square, so both algorithms take the same scale of
+// tolerance.  The first and last points are always kept.
+func Visvalingam(points []Point, tolerance float64) []int {
+	if len(points) < 3 {
+		return allIndexes(len(points))
+	}
+
+	xy := planar(points)
+	vertices := make([]*vertex, len(points))
+	for i := range points {
+		vertices[i] = &vertex{index: i, position: -1}
+		if i > 0 {
+			vertices[i].prev = vertices[i-1]
+			vertices[i-1].next = vertices[i]
+		}
+	}
+	h := make(vertexHeap, 0, len(points)-2)
+	for _, v := range vertices[1 : len(points)-1] {
+		v.area = triangleArea(xy[v.prev.index], xy[v.index], xy[v.next.index])
+		heap.Push(&h, v)
+	}
+
+	threshold := tolerance * tolerance
+	// the effective area of a point is never less than that of a point
+	// dropped before it, so points are dropped in order of significance
+	floor := 0.0
+	for h.Len() > 0 && h[0].area < threshold {
+		v := heap.Pop(&h).(*vertex)
+		floor = math.Max(floor, v.area)
+		v.prev.next, v.next.prev = v.next, v.prev
+		for _, n := range []*vertex{v.prev, v.next} {
+			if n.position < 0 {
+				continue
+			}
+			n.area = math.Max(floor, triangleArea(xy[n.prev.index], xy[n.index], xy[n.next.index]))
+			heap.Fix(&h, n.position)
+		}
+	}
+
+	var kept []int
+	for v := vertices[0]; v != nil; v = v.next {
+		kept = append(kept, v.index)
+	}
+	return kept
+}
+
+func allIndexes(n int) []int {
+	indexes := make([]int, n)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	return indexes
+}
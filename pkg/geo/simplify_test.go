@@ -0,0 +1,61 @@
+package geo
+
+import (
+	"reflect"
+	"testing"
+)
+
+// zigzag is a track heading north with a wobble of about 5m on every other
+// point and a 200m detour to the east in the middle
+var zigzag = []Point{
+	{Lat: 45.000, Lon: -122},
+	{Lat: 45.001, Lon: -121.99994},
+	{Lat: 45.002, Lon: -122},
+	{Lat: 45.003, Lon: -121.99994},
+	{Lat: 45.004, Lon: -121.99746},
+	{Lat: 45.005, Lon: -122},
+	{Lat: 45.006, Lon: -121.99994},
+	{Lat: 45.007, Lon: -122},
+}
+
+func TestDouglasPeucker(t *testing.T) {
+	tests := []struct {
+		name      string
+		points    []Point
+		tolerance float64
+		want      []int
+	}{
+		{name: "Empty", want: []int{}},
+		{name: "Short", points: zigzag[:2], tolerance: 10, want: []int{0, 1}},
+		{name: "Wobble", points: zigzag, tolerance: 10, want: []int{0, 3, 4, 5, 7}},
+		{name: "Detour", points: zigzag, tolerance: 150, want: []int{0, 4, 7}},
+		{name: "Straight", points: zigzag, tolerance: 1000, want: []int{0, 7}},
+		{name: "Exact", points: zigzag, tolerance: 0, want: []int{0, 1, 2, 3, 4, 5, 6, 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DouglasPeucker(tt.points, tt.tolerance); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v; got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestVisvalingam(t *testing.T) {
+	tests := []struct {
+		name      string
+		tolerance float64
+		want      []int
+	}{
+		{name: "Wobble", tolerance: 30, want: []int{0, 3, 4, 5, 7}},
+		{name: "Straight", tolerance: 1000, want: []int{0, 7}},
+		{name: "Exact", tolerance: 0, want: []int{0, 1, 2, 3, 4, 5, 6, 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Visvalingam(zigzag, tt.tolerance); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v; got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Package geofence detects objects entering and leaving registered
+// geofences.
+package geofence
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// RefreshInterval is how often Refresh should be called to pick up fences
+// changed by other instances of the service
+const RefreshInterval = time.Minute
+
+// fence is a geofence along with its shape
+type fence struct {
+	models.Geofence
+	shape geo.Shape
+}
+
+// Monitor compares each accepted update against the previous position of the
+// object and records an event for every geofence it entered or exited.  The
+// fences are cached rather than read for every update, and the cache is
+// refreshed by every fence added, updated, or deleted through the monitor.
+// Reads and events go straight to the wrapped datastore.
+type Monitor struct {
+	models.GeofenceReaderWriter
+	log *zerolog.Logger
+
+	// refreshMu orders refreshes so an older list of fences never replaces
+	// a newer one
+	refreshMu sync.Mutex
+
+	// mu guards fences, which is nil until the fences are first loaded
+	mu     sync.RWMutex
+	fences []fence
+}
+
+func NewMonitor(fences models.GeofenceReaderWriter, logger *zerolog.Logger) *Monitor {
+	return &Monitor{
+		GeofenceReaderWriter: fences,
+		log:                  logger,
+	}
+}
+
+// AddGeofence stores f, replacing the fence with the same id, and refreshes
+// the cached fences
+func (m *Monitor) AddGeofence(f models.Geofence) (string, error) {
+	id, err := m.GeofenceReaderWriter.AddGeofence(f)
+	if err != nil {
+		return id, err
+	}
+	m.Refresh()
+	return id, nil
+}
+
+// DeleteGeofence deletes the fence with the passed id and refreshes the
+// cached fences
+func (m *Monitor) DeleteGeofence(id string) error {
+	if err := m.GeofenceReaderWriter.DeleteGeofence(id); err != nil {
+		return err
+	}
+	m.Refresh()
+	return nil
+}
+
+// Refresh reloads the cached fences from the datastore.  It should be called
+// every RefreshInterval when other instances share the datastore.
+func (m *Monitor) Refresh() {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+
+	stored := m.GeofenceReaderWriter.GetAllGeofences()
+	fences := make([]fence, len(stored))
+	for i, f := range stored {
+		fences[i] = fence{Geofence: f, shape: f.Shape()}
+	}
+
+	m.mu.Lock()
+	m.fences = fences
+	m.mu.Unlock()
+}
+
+// cached returns the cached fences, loading them on first use
+func (m *Monitor) cached() []fence {
+	m.mu.RLock()
+	fences := m.fences
+	m.mu.RUnlock()
+	if fences != nil {
+		return fences
+	}
+
+	m.Refresh()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.fences
+}
+
+// Observe implements models.TelemetryObserver.  An object seen for the first
+// time is treated as entering every fence it is inside of.  Fences of other
+// tenants are ignored.
+func (m *Monitor) Observe(previous *models.Telemetry, current models.Telemetry) {
+	for _, fence := range m.cached() {
+		if !fence.Applies(current.Tenant) {
+			continue
+		}
+		wasInside := previous != nil && fence.shape.Contains(previous.Position.Point())
+		isInside := fence.shape.Contains(current.Position.Point())
+		if wasInside == isInside {
+			continue
+		}
+
+		event := models.GeofenceEvent{
+			GeofenceId: fence.Id,
+			ObjectId:   current.Id,
+			Type:       models.GeofenceEnter,
+			Position:   current.Position,
+			Time:       current.Updated,
+			Tenant:     current.Tenant,
+		}
+		if wasInside {
+			event.Type = models.GeofenceExit
+		}
+
+		if err := m.GeofenceReaderWriter.AddGeofenceEvent(event); err != nil {
+			m.log.Error().Err(err).Str("geofence", fence.Id).Str("obj", current.Id).Msg("unable to record geofence event")
+			continue
+		}
+		m.log.Debug().Str("geofence", fence.Id).Str("obj", current.Id).Str("event", event.Type).Msg("geofence event")
+	}
+}
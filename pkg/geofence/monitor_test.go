@@ -0,0 +1,129 @@
+package geofence
+
+import (
+	"os"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models/inmem"
+)
+
+func TestMonitor(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := inmem.New(&logger)
+	db.AddGeofence(models.Geofence{
+		Id:     "depot",
+		Name:   "Depot",
+		Type:   models.CircleGeofence,
+		Center: &geo.Point{Lat: 45.5, Lon: -122.6},
+		Radius: 500,
+	})
+	monitor := NewMonitor(db, &logger)
+
+	outside := models.Telemetry{Id: "vehicle", Position: models.Position{Latitude: 45.6, Longitude: -122.6}}
+	inside := models.Telemetry{Id: "vehicle", Position: models.Position{Latitude: 45.501, Longitude: -122.6}}
+
+	tests := []struct {
+		name     string
+		previous *models.Telemetry
+		current  models.Telemetry
+		event    string
+	}{
+		{name: "NewObjectOutside", previous: nil, current: outside},
+		{name: "Enter", previous: &outside, current: inside, event: models.GeofenceEnter},
+		{name: "StayInside", previous: &inside, current: inside},
+		{name: "Exit", previous: &inside, current: outside, event: models.GeofenceExit},
+		{name: "StayOutside", previous: &outside, current: outside},
+		{name: "NewObjectInside", previous: nil, current: inside, event: models.GeofenceEnter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, _ := db.GeofenceEvents("depot", models.HistoryQuery{})
+			monitor.Observe(tt.previous, tt.current)
+			after, _ := db.GeofenceEvents("depot", models.HistoryQuery{})
+
+			if tt.event == "" {
+				if len(after) != len(before) {
+					t.Errorf("expected no event; got %v", after[len(after)-1])
+				}
+				return
+			}
+			if len(after) != len(before)+1 {
+				t.Fatalf("expected a %s event; got none", tt.event)
+			}
+			if e := after[len(after)-1]; e.Type != tt.event || e.ObjectId != "vehicle" || e.Tenant != tt.current.Tenant {
+				t.Errorf("expected a %s event; got %+v", tt.event, e)
+			}
+		})
+	}
+}
+
+func TestMonitorTenants(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := inmem.New(&logger)
+	db.AddGeofence(models.Geofence{
+		Id:     "depot",
+		Name:   "Depot",
+		Type:   models.CircleGeofence,
+		Center: &geo.Point{Lat: 45.5, Lon: -122.6},
+		Radius: 500,
+		Tenant: "acme",
+	})
+	monitor := NewMonitor(db, &logger)
+
+	inside := models.Position{Latitude: 45.501, Longitude: -122.6}
+	monitor.Observe(nil, models.Telemetry{Id: "globex:vehicle", Tenant: "globex", Position: inside})
+	monitor.Observe(nil, models.Telemetry{Id: "acme:vehicle", Tenant: "acme", Position: inside})
+
+	events, _ := db.GeofenceEvents("depot", models.HistoryQuery{})
+	if len(events) != 1 || events[0].ObjectId != "acme:vehicle" || events[0].Tenant != "acme" {
+		t.Errorf("expected only objects of the fence's tenant to cross it; got %+v", events)
+	}
+}
+
+// countingFences counts the reads of every fence
+type countingFences struct {
+	models.GeofenceReaderWriter
+	reads int
+}
+
+func (c *countingFences) GetAllGeofences() []models.Geofence {
+	c.reads++
+	return c.GeofenceReaderWriter.GetAllGeofences()
+}
+
+func TestMonitorCache(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := &countingFences{GeofenceReaderWriter: inmem.New(&logger)}
+	monitor := NewMonitor(db, &logger)
+
+	inside := models.Telemetry{Id: "vehicle", Position: models.Position{Latitude: 45.501, Longitude: -122.6}}
+	for i := 0; i < 3; i++ {
+		monitor.Observe(nil, inside)
+	}
+	if db.reads != 1 {
+		t.Errorf("expected the fences to be read once; got %d reads", db.reads)
+	}
+
+	// a fence added through the monitor applies to the next update
+	monitor.AddGeofence(models.Geofence{
+		Id:     "depot",
+		Name:   "Depot",
+		Type:   models.CircleGeofence,
+		Center: &geo.Point{Lat: 45.5, Lon: -122.6},
+		Radius: 500,
+	})
+	monitor.Observe(nil, inside)
+	if events, _ := db.GeofenceEvents("depot", models.HistoryQuery{}); len(events) != 1 {
+		t.Errorf("expected the added fence to be monitored; got %+v", events)
+	}
+
+	monitor.DeleteGeofence("depot")
+	monitor.Observe(nil, inside)
+	if events, _ := db.GeofenceEvents("depot", models.HistoryQuery{}); len(events) > 1 {
+		t.Errorf("expected the deleted fence to be dropped; got %+v", events)
+	}
+}
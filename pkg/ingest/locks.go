@@ -0,0 +1,70 @@
+package ingest
+
+import (
+	"sort"
+	"sync"
+)
+
+// objectLocks serializes the updates of each object while updates of
+// different objects go ahead.  Locks are only kept while they are held or
+// waited on.
+type objectLocks struct {
+	mu    sync.Mutex
+	locks map[string]*objectLock
+}
+
+type objectLock struct {
+	sync.Mutex
+
+	// refs is the number of callers holding or waiting on the lock
+	refs int
+}
+
+// lock locks the objects with the passed ids, in order so that callers
+// locking overlapping objects can not deadlock, and returns the function
+// unlocking them
+func (l *objectLocks) lock(ids ...string) func() {
+	ids = distinct(ids)
+	held := make([]*objectLock, len(ids))
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*objectLock)
+	}
+	for i, id := range ids {
+		lock, ok := l.locks[id]
+		if !ok {
+			lock = &objectLock{}
+			l.locks[id] = lock
+		}
+		lock.refs++
+		held[i] = lock
+	}
+	l.mu.Unlock()
+
+	for _, lock := range held {
+		lock.Lock()
+	}
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for i, lock := range held {
+			lock.Unlock()
+			if lock.refs--; lock.refs == 0 {
+				delete(l.locks, ids[i])
+			}
+		}
+	}
+}
+
+// distinct returns the sorted ids without repeats
+func distinct(ids []string) []string {
+	sorted := append([]string(nil), ids...)
+	sort.Strings(sorted)
+	results := sorted[:0]
+	for i, id := range sorted {
+		if i == 0 || id != sorted[i-1] {
+			results = append(results, id)
+		}
+	}
+	return results
+}
@@ -0,0 +1,210 @@
+// Package ingest wraps a datastore so that every accepted telemetry update
+// is passed on to the rest of the service.
+package ingest
+
+import (
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+	"scbunn.org/tmp/gps-tracking-service/pkg/odometer"
+	"scbunn.org/tmp/gps-tracking-service/pkg/smooth"
+)
+
+// Pipeline is a datastore that checks the plausibility and status change of
+// every update, smooths its position, stamps it with the time it was
+// received and the odometer reading of its object, and notifies its
+// observers once it is written.  Reads and health checks go straight to the wrapped
+// datastore.
+type Pipeline struct {
+	models.TelemetryReaderWriterChecker
+	observers []models.TelemetryObserver
+
+	// Rules are the plausibility checks updates must pass to be written.
+	// The zero value checks nothing.
+	Rules models.Plausibility
+
+	// Transitions are the status changes updates may make.  A nil value
+	// allows every change.
+	Transitions models.Transitions
+
+	// Smoothing smooths the positions of updates before they are written
+	// and measured.  A nil value stores positions as reported.
+	Smoothing *smooth.Filter
+
+	// Odometer advances the odometer of the object of each update.  Only
+	// updates that pass the checks are measured, and an object seen again
+	// after its telemetry expired carries on from the newest point of its
+	// stored track.  A nil value counts every movement.
+	Odometer *odometer.Meter
+
+	// locks serializes the updates of each object from reading its
+	// previous telemetry to notifying the observers, so checks and running
+	// state such as the odometer never see an update that is being written
+	locks objectLocks
+}
+
+func New(store models.TelemetryReaderWriterChecker, observers ...models.TelemetryObserver) *Pipeline {
+	return &Pipeline{
+		TelemetryReaderWriterChecker: store,
+		observers:                    observers,
+	}
+}
+
+// Add writes t to the wrapped datastore and, once it has been accepted,
+// notifies every observer with the previous and new telemetry of the object.
+// An implausible update, or one making a status change that is not allowed,
+// is not written and its FieldErrors are returned.
+func (p *Pipeline) Add(t models.Telemetry) (string, error) {
+	defer p.locks.lock(t.Id)()
+
+	now := time.Now()
+	previous := p.previous(t.Id)
+	keepStatus(previous, &t)
+	if err := p.check(previous, t, now); err != nil {
+		return "", err
+	}
+	stamp(&t, now)
+	p.Smoothing.Smooth(previous, &t)
+	p.Odometer.Advance(p.measured(previous, t.Id), &t)
+
+	id, err := p.TelemetryReaderWriterChecker.Add(t)
+	if err != nil {
+		return "", err
+	}
+
+	for _, o := range p.observers {
+		o.Observe(previous, t)
+	}
+	return id, nil
+}
+
+// AddBatch writes the acceptable updates of the batch to the wrapped
+// datastore and notifies every observer of each update that was accepted.
+// Updates to the same object within a batch see the earlier accepted update
+// as their previous telemetry.  An update is only written once the earlier
+// update of its object is, so one the datastore refuses is never taken as
+// the previous telemetry of the next.
+func (p *Pipeline) AddBatch(ts []models.Telemetry) []models.WriteResult {
+	ids := make([]string, len(ts))
+	for i, t := range ts {
+		ids[i] = t.Id
+	}
+	defer p.locks.lock(ids...)()
+
+	now := time.Now()
+	results := make([]models.WriteResult, len(ts))
+	latest := make(map[string]*models.Telemetry)
+	var previous []*models.Telemetry
+	var accepted []models.Telemetry
+	var positions []int
+	pending := make(map[string]bool)
+
+	// flush writes the pending updates, each of a different object, and
+	// takes those accepted as the previous telemetry of their objects
+	flush := func() {
+		if len(accepted) == 0 {
+			return
+		}
+		for j, r := range p.TelemetryReaderWriterChecker.AddBatch(accepted) {
+			results[positions[j]] = r
+			if r.Err != nil {
+				continue
+			}
+			t := accepted[j]
+			latest[t.Id] = &t
+			for _, o := range p.observers {
+				o.Observe(previous[j], t)
+			}
+		}
+		previous, accepted, positions = nil, nil, nil
+		pending = make(map[string]bool)
+	}
+
+	for i, t := range ts {
+		if pending[t.Id] {
+			flush()
+		}
+		prev, ok := latest[t.Id]
+		if !ok {
+			prev = p.previous(t.Id)
+		}
+		t := t
+		keepStatus(prev, &t)
+		if err := p.check(prev, t, now); err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		stamp(&t, now)
+		p.Smoothing.Smooth(prev, &t)
+		p.Odometer.Advance(p.measured(prev, t.Id), &t)
+		previous = append(previous, prev)
+		accepted = append(accepted, t)
+		positions = append(positions, i)
+		pending[t.Id] = true
+	}
+	flush()
+	return results
+}
+
+// check returns the FieldErrors of every rule t breaks, or nil when it may
+// be written
+func (p *Pipeline) check(previous *models.Telemetry, t models.Telemetry, now time.Time) error {
+	var errs models.FieldErrors
+	for _, err := range []error{p.Rules.Check(previous, t, now), p.Transitions.Check(previous, t)} {
+		if err == nil {
+			continue
+		}
+		invalid, ok := err.(models.FieldErrors)
+		if !ok {
+			return err
+		}
+		errs = append(errs, invalid...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// keepStatus gives an update without a status the status of the previous
+// telemetry of its object
+func keepStatus(previous *models.Telemetry, t *models.Telemetry) {
+	if t.Status == "" && previous != nil {
+		t.Status = previous.Status
+	}
+}
+
+// stamp records that t was received at now, which is also taken as its
+// recorded time when the source did not report one
+func stamp(t *models.Telemetry, now time.Time) {
+	t.Updated = now
+	if t.RecordedAt.IsZero() {
+		t.RecordedAt = now
+	}
+}
+
+// measured returns the telemetry the odometer of the object advances from:
+// previous, or the newest point of its stored track once its telemetry has
+// expired.  It is nil when the datastore keeps nothing of the object.
+func (p *Pipeline) measured(previous *models.Telemetry, id string) *models.Telemetry {
+	if previous != nil {
+		return previous
+	}
+	track, err := p.TelemetryReaderWriterChecker.History(id, models.HistoryQuery{Limit: 1})
+	if err != nil || len(track) == 0 {
+		return nil
+	}
+	return &track[len(track)-1]
+}
+
+// previous returns a copy of the stored telemetry of the object, or nil if
+// the object is unknown
+func (p *Pipeline) previous(id string) *models.Telemetry {
+	stored, err := p.TelemetryReaderWriterChecker.Get(id)
+	if err != nil {
+		return nil
+	}
+	previous := *stored
+	return &previous
+}
@@ -0,0 +1,304 @@
+package ingest
+
+import (
+	"errors"
+	"math"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models/inmem"
+	"scbunn.org/tmp/gps-tracking-service/pkg/odometer"
+	"scbunn.org/tmp/gps-tracking-service/pkg/smooth"
+)
+
+type recorder struct {
+	previous []*models.Telemetry
+	current  []models.Telemetry
+}
+
+func (r *recorder) Observe(previous *models.Telemetry, current models.Telemetry) {
+	r.previous = append(r.previous, previous)
+	r.current = append(r.current, current)
+}
+
+func TestPipelineNotifiesObservers(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	rec := &recorder{}
+	p := New(inmem.New(&logger), rec)
+
+	first := models.Telemetry{Id: "vehicle", Position: models.Position{Latitude: 1}}
+	second := models.Telemetry{Id: "vehicle", Position: models.Position{Latitude: 2}}
+	for _, tm := range []models.Telemetry{first, second} {
+		if _, err := p.Add(tm); err != nil {
+			t.Fatalf("could not add telemetry: %s", err.Error())
+		}
+	}
+
+	if len(rec.current) != 2 {
+		t.Fatalf("expected 2 notifications; got %d", len(rec.current))
+	}
+	if rec.previous[0] != nil {
+		t.Errorf("expected no previous telemetry for a new object; got %+v", rec.previous[0])
+	}
+	if rec.previous[1] == nil || rec.previous[1].Position.Latitude != 1 {
+		t.Errorf("expected the first position as previous; got %+v", rec.previous[1])
+	}
+	if rec.current[1].Position.Latitude != 2 {
+		t.Errorf("expected the second position as current; got %+v", rec.current[1])
+	}
+
+	// reads pass through to the wrapped datastore
+	stored, err := p.Get("vehicle")
+	if err != nil || stored.Position.Latitude != 2 {
+		t.Errorf("expected the latest position from the datastore; got %+v (%v)", stored, err)
+	}
+}
+
+func TestPipelineBatch(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	rec := &recorder{}
+	p := New(inmem.New(&logger), rec)
+
+	p.Add(models.Telemetry{Id: "a", Position: models.Position{Latitude: 1}})
+	results := p.AddBatch([]models.Telemetry{
+		{Id: "a", Position: models.Position{Latitude: 2}},
+		{Id: "b", Position: models.Position{Latitude: 3}},
+		{Id: "a", Position: models.Position{Latitude: 4}},
+	})
+
+	for i, r := range results {
+		if r.Err != nil {
+			t.Errorf("update %d failed: %s", i, r.Err.Error())
+		}
+	}
+	if len(rec.current) != 4 {
+		t.Fatalf("expected 4 notifications; got %d", len(rec.current))
+	}
+
+	want := []float64{1, 0, 2}
+	for i, prev := range rec.previous[1:] {
+		if want[i] == 0 {
+			if prev != nil {
+				t.Errorf("update %d: expected no previous telemetry; got %+v", i, prev)
+			}
+			continue
+		}
+		if prev == nil || prev.Position.Latitude != want[i] {
+			t.Errorf("update %d: expected previous latitude %f; got %+v", i, want[i], prev)
+		}
+	}
+}
+
+func TestPipelineBatchRefused(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	rec := &recorder{}
+	p := New(inmem.New(&logger), rec)
+	p.Odometer = odometer.New(odometer.DefaultMinMovement)
+
+	now := time.Now()
+	results := p.AddBatch([]models.Telemetry{
+		{Id: "a", RecordedAt: now, Position: models.Position{Latitude: 45, Longitude: -122}},
+		// recorded before the update above, so the datastore refuses it
+		{Id: "a", RecordedAt: now.Add(-time.Minute), Position: models.Position{Latitude: 46, Longitude: -122}},
+		{Id: "a", RecordedAt: now.Add(time.Second), Position: models.Position{Latitude: 45.001, Longitude: -122}},
+	})
+
+	if results[0].Err != nil || !errors.Is(results[1].Err, models.ErrStaleUpdate) || results[2].Err != nil {
+		t.Fatalf("expected only the out of order update to be refused; got %+v", results)
+	}
+	if len(rec.current) != 2 {
+		t.Fatalf("expected 2 notifications; got %d", len(rec.current))
+	}
+	if prev := rec.previous[1]; prev == nil || prev.Position.Latitude != 45 {
+		t.Errorf("expected the refused update not to be the previous telemetry; got %+v", prev)
+	}
+	stored, _ := p.Get("a")
+	// 0.001 degrees of latitude is roughly 111m
+	if math.Abs(stored.Odometer-111.2) > 0.5 {
+		t.Errorf("expected the refused update not to be measured; got %f", stored.Odometer)
+	}
+}
+
+func TestPipelinePlausibility(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	rec := &recorder{}
+	p := New(inmem.New(&logger), rec)
+	p.Rules = models.Plausibility{MaxSpeed: 100, MaxFutureSkew: time.Minute}
+
+	if _, err := p.Add(models.Telemetry{Id: "a", Position: models.Position{Latitude: 45, Longitude: -122}}); err != nil {
+		t.Fatalf("could not add telemetry: %s", err.Error())
+	}
+	stored, _ := p.Get("a")
+	if stored.Updated.IsZero() {
+		t.Errorf("expected the receive time to be stamped")
+	}
+
+	// a degree of latitude is roughly 111km, far more than 100 m/s allows
+	_, err := p.Add(models.Telemetry{Id: "a", Position: models.Position{Latitude: 46, Longitude: -122}})
+	if !errors.Is(err, models.ValidationError) {
+		t.Errorf("expected a teleport to be rejected; got %v", err)
+	}
+
+	results := p.AddBatch([]models.Telemetry{
+		{Id: "a", Position: models.Position{Latitude: 45.0001, Longitude: -122}},
+		{Id: "a", Position: models.Position{Latitude: 50, Longitude: -122}},
+		{Id: "b", Position: models.Position{Latitude: 50, Longitude: -122}, RecordedAt: time.Now().Add(time.Hour)},
+		{Id: "c", Position: models.Position{Latitude: 50, Longitude: -122}},
+	})
+	for i, want := range []bool{true, false, false, true} {
+		if accepted := results[i].Err == nil; accepted != want {
+			t.Errorf("update %d: expected accepted to be %v; got %v", i, want, results[i].Err)
+		}
+	}
+	if len(rec.current) != 3 {
+		t.Errorf("expected only accepted updates to notify observers; got %d notifications", len(rec.current))
+	}
+}
+
+func TestPipelineOdometer(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	p := New(inmem.New(&logger))
+	p.Rules = models.Plausibility{MaxSpeed: 100}
+	p.Odometer = odometer.New(odometer.DefaultMinMovement)
+
+	add := func(lat float64) {
+		p.Add(models.Telemetry{Id: "a", Odometer: 1e6, Position: models.Position{Latitude: lat, Longitude: -122}})
+	}
+	add(45)
+	// an implausible fix is not measured
+	add(46)
+	p.AddBatch([]models.Telemetry{
+		{Id: "a", Position: models.Position{Latitude: 45.0005, Longitude: -122}},
+		{Id: "a", Position: models.Position{Latitude: 45.001, Longitude: -122}},
+	})
+
+	stored, _ := p.Get("a")
+	// 0.001 degrees of latitude is roughly 111m
+	if math.Abs(stored.Odometer-111.2) > 0.5 {
+		t.Errorf("expected about 111m on the odometer; got %f", stored.Odometer)
+	}
+}
+
+func TestPipelineOdometerAfterExpiry(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := inmem.New(&logger, inmem.WithTTL(time.Millisecond), inmem.WithHistory(10, time.Hour))
+	p := New(db)
+	p.Odometer = odometer.New(odometer.DefaultMinMovement)
+
+	p.Add(models.Telemetry{Id: "a", Position: models.Position{Latitude: 45, Longitude: -122}})
+	p.Add(models.Telemetry{Id: "a", Position: models.Position{Latitude: 45.001, Longitude: -122}})
+	time.Sleep(5 * time.Millisecond)
+	if db.Expire() != 1 {
+		t.Fatal("expected the object to expire")
+	}
+
+	p.Add(models.Telemetry{Id: "a", Position: models.Position{Latitude: 45.002, Longitude: -122}})
+	stored, _ := p.Get("a")
+	// 0.002 degrees of latitude is roughly 222m
+	if math.Abs(stored.Odometer-222.4) > 1 {
+		t.Errorf("expected the odometer to carry on after expiry; got %f", stored.Odometer)
+	}
+}
+
+func TestPipelineSmoothing(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	p := New(inmem.New(&logger))
+	p.Smoothing = smooth.New(map[string]float64{"gateway": 1})
+
+	start := time.Now().Add(-time.Minute)
+	for i, lat := range []float64{45, 45.0002} {
+		p.Add(models.Telemetry{Id: "a", Source: "gateway", RecordedAt: start.Add(time.Duration(i) * time.Second), Position: models.Position{Latitude: lat, Longitude: -122}})
+	}
+
+	stored, _ := p.Get("a")
+	if stored.Position.Latitude <= 45 || stored.Position.Latitude >= 45.0002 {
+		t.Errorf("expected the stored position to be smoothed; got %+v", stored.Position)
+	}
+}
+
+func TestPipelineTransitions(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	p := New(inmem.New(&logger))
+	p.Transitions = models.DefaultTransitions()
+
+	add := func(status models.Status) error {
+		_, err := p.Add(models.Telemetry{Id: "a", Status: status, Position: models.Position{Latitude: 45, Longitude: -122}})
+		return err
+	}
+
+	if err := add(models.StatusMaintenance); err != nil {
+		t.Fatalf("expected the first status to be accepted; got %v", err)
+	}
+	if err := add(models.StatusMoving); !errors.Is(err, models.ValidationError) {
+		t.Errorf("expected maintenance to moving to be rejected; got %v", err)
+	}
+
+	// an update without a status keeps the status of the object
+	if err := add(""); err != nil {
+		t.Fatalf("expected an update without a status to be accepted; got %v", err)
+	}
+	if stored, _ := p.Get("a"); stored.Status != models.StatusMaintenance {
+		t.Errorf("expected the status to be kept; got %q", stored.Status)
+	}
+
+	results := p.AddBatch([]models.Telemetry{
+		{Id: "a", Status: models.StatusIdle, Position: models.Position{Latitude: 45, Longitude: -122}},
+		{Id: "a", Status: models.StatusMoving, Position: models.Position{Latitude: 45, Longitude: -122}},
+		{Id: "a", Status: models.StatusMaintenance, Position: models.Position{Latitude: 45, Longitude: -122}},
+	})
+	for i, want := range []bool{true, true, false} {
+		if accepted := results[i].Err == nil; accepted != want {
+			t.Errorf("update %d: expected accepted to be %v; got %v", i, want, results[i].Err)
+		}
+	}
+}
+
+// slowStore takes a while to write, as a remote datastore would
+type slowStore struct {
+	models.TelemetryReaderWriterChecker
+}
+
+func (s slowStore) Add(t models.Telemetry) (string, error) {
+	time.Sleep(time.Millisecond)
+	return s.TelemetryReaderWriterChecker.Add(t)
+}
+
+func (s slowStore) AddBatch(ts []models.Telemetry) []models.WriteResult {
+	time.Sleep(time.Millisecond)
+	return s.TelemetryReaderWriterChecker.AddBatch(ts)
+}
+
+func TestPipelineSerializesObjects(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	rec := &recorder{}
+	p := New(slowStore{inmem.New(&logger)}, rec)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tm := models.Telemetry{Id: "vehicle", Position: models.Position{Latitude: 45 + float64(i)*0.001, Longitude: -122}}
+			if i%2 == 0 {
+				p.AddBatch([]models.Telemetry{tm})
+			} else {
+				p.Add(tm)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// every update sees the one written before it as its previous telemetry
+	if len(rec.current) != 50 {
+		t.Fatalf("expected 50 notifications; got %d", len(rec.current))
+	}
+	for i := 1; i < len(rec.current); i++ {
+		if rec.previous[i] == nil || rec.previous[i].Position != rec.current[i-1].Position || rec.previous[i].Odometer != rec.current[i-1].Odometer {
+			t.Fatalf("expected update %d to follow the update before it; got %+v after %+v", i, rec.previous[i], rec.current[i-1])
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Package lifecycle coordinates the orderly shutdown of the service.
+//
+// Shutdown happens in stages: the service first reports itself as not ready
+// so load balancers stop sending it traffic, then drains in-flight work,
+// stops background workers, and finally closes the datastore.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// ErrShuttingDown is reported by the readiness check once shutdown has begun
+var ErrShuttingDown = errors.New("lifecycle: shutting down")
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Manager runs background workers and the shutdown hooks of the service
+type Manager struct {
+	log        *zerolog.Logger
+	drainDelay time.Duration
+
+	stopping int32
+	ctx      context.Context
+	cancel   context.CancelFunc
+	workers  sync.WaitGroup
+
+	mu     sync.Mutex
+	drain  []hook
+	closer []hook
+}
+
+// New returns a manager that waits drainDelay after flipping readiness
+// before draining, giving load balancers time to notice.
+func New(logger *zerolog.Logger, drainDelay time.Duration) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		log:        logger,
+		drainDelay: drainDelay,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Stopping reports whether shutdown has begun
+func (m *Manager) Stopping() bool {
+	return atomic.LoadInt32(&m.stopping) == 1
+}
+
+// Go runs a background worker.  The context passed to fn is cancelled once
+// in-flight requests have drained, and shutdown waits for fn to return.
+func (m *Manager) Go(name string, fn func(ctx context.Context)) {
+	m.workers.Add(1)
+	go func() {
+		defer m.workers.Done()
+		fn(m.ctx)
+		m.log.Debug().Str("worker", name).Msg("worker stopped")
+	}()
+}
+
+// OnDrain registers fn to drain in-flight work, such as an http server's
+// Shutdown.  Drain hooks run in the order they were registered.
+func (m *Manager) OnDrain(name string, fn func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.drain = append(m.drain, hook{name: name, fn: fn})
+}
+
+// OnClose registers fn to release a resource, such as a datastore, after
+// every worker has stopped.  Close hooks run in the order they were
+// registered.
+func (m *Manager) OnClose(name string, fn func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closer = append(m.closer, hook{name: name, fn: fn})
+}
+
+// Shutdown flips readiness, drains, stops workers, and closes resources.
+// Every stage runs even when an earlier one fails; the first error is
+// returned.  Calling Shutdown more than once has no further effect.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&m.stopping, 0, 1) {
+		return nil
+	}
+	m.log.Info().Dur("delay", m.drainDelay).Msg("not ready; waiting before draining")
+
+	select {
+	case <-time.After(m.drainDelay):
+	case <-ctx.Done():
+	}
+
+	m.mu.Lock()
+	drain, closer := m.drain, m.closer
+	m.mu.Unlock()
+
+	err := m.run(ctx, "drain", drain)
+
+	m.cancel()
+	done := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		m.log.Info().Msg("background workers stopped")
+	case <-ctx.Done():
+		m.log.Warn().Msg("gave up waiting for background workers")
+		if err == nil {
+			err = fmt.Errorf("stopping workers: %w", ctx.Err())
+		}
+	}
+
+	if cerr := m.run(ctx, "close", closer); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (m *Manager) run(ctx context.Context, stage string, hooks []hook) error {
+	var first error
+	for _, h := range hooks {
+		if err := h.fn(ctx); err != nil {
+			m.log.Error().Err(err).Str("stage", stage).Str("hook", h.name).Msg("shutdown hook failed")
+			if first == nil {
+				first = fmt.Errorf("%s %s: %w", stage, h.name, err)
+			}
+			continue
+		}
+		m.log.Info().Str("stage", stage).Str("hook", h.name).Msg("shutdown hook finished")
+	}
+	return first
+}
+
+// HealthChecker wraps hc so that it reports not ready once shutdown has
+// begun.  Liveness is left to hc.
+func (m *Manager) HealthChecker(hc models.HealthChecker) models.HealthChecker {
+	return checker{HealthChecker: hc, m: m}
+}
+
+type checker struct {
+	models.HealthChecker
+	m *Manager
+}
+
+func (c checker) Ready() (map[string]string, error) {
+	if c.m.Stopping() {
+		return map[string]string{
+			"health":  "alive",
+			"ready":   "false",
+			"message": "shutting down",
+		}, fmt.Errorf("%w: %s", models.ReadyError, ErrShuttingDown)
+	}
+	return c.HealthChecker.Ready()
+}
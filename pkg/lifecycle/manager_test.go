@@ -0,0 +1,112 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+type readyChecker struct{}
+
+func (readyChecker) Alive() (map[string]string, error) {
+	return map[string]string{"health": "alive"}, nil
+}
+
+func (readyChecker) Ready() (map[string]string, error) {
+	return map[string]string{"ready": "true"}, nil
+}
+
+func TestShutdownOrder(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	m := New(&logger, 10*time.Millisecond)
+	health := m.HealthChecker(readyChecker{})
+
+	var mu sync.Mutex
+	var steps []string
+	record := func(step string) {
+		mu.Lock()
+		defer mu.Unlock()
+		steps = append(steps, step)
+	}
+
+	m.Go("worker", func(ctx context.Context) {
+		<-ctx.Done()
+		record("worker")
+	})
+	m.OnDrain("http", func(ctx context.Context) error {
+		if _, err := health.Ready(); !errors.Is(err, models.ReadyError) {
+			t.Errorf("expected not ready while draining; got %v", err)
+		}
+		record("drain")
+		return nil
+	})
+	m.OnClose("datastore", func(ctx context.Context) error {
+		record("close")
+		return nil
+	})
+
+	if _, err := health.Ready(); err != nil {
+		t.Fatalf("expected ready before shutdown; got %s", err.Error())
+	}
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	want := []string{"drain", "worker", "close"}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("got shutdown steps %v; want %v", steps, want)
+	}
+	if _, err := health.Alive(); err != nil {
+		t.Errorf("expected to stay alive while shutting down; got %s", err.Error())
+	}
+
+	// a second shutdown does nothing
+	if err := m.Shutdown(context.Background()); err != nil || len(steps) != 3 {
+		t.Errorf("expected a second shutdown to be ignored; got %v, %v", err, steps)
+	}
+}
+
+func TestShutdownErrors(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	m := New(&logger, 0)
+
+	closed := false
+	m.OnDrain("http", func(ctx context.Context) error {
+		return errors.New("drain failed")
+	})
+	m.OnClose("datastore", func(ctx context.Context) error {
+		closed = true
+		return nil
+	})
+
+	if err := m.Shutdown(context.Background()); err == nil {
+		t.Errorf("expected the drain error to be returned")
+	}
+	if !closed {
+		t.Errorf("expected close hooks to run after a failed drain")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	m := New(&logger, 0)
+
+	block := make(chan struct{})
+	defer close(block)
+	m.Go("stuck", func(ctx context.Context) {
+		<-block
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := m.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline error; got %v", err)
+	}
+}
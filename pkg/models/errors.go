@@ -7,3 +7,5 @@ var DecodeError = errors.New("models: unable to decode payload")
 var ValidationError = errors.New("models: validation error")
 var ReadyError = errors.New("datastore is not ready")
 var AliveError = errors.New("datastoer is not alive")
+var ErrInvalidCursor = errors.New("models: invalid cursor")
+var ErrStaleUpdate = errors.New("models: update was recorded before the stored position")
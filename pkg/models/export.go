@@ -0,0 +1,109 @@
+package models
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+const (
+	// GPXContentType is the media type of GPX documents
+	GPXContentType = "application/gpx+xml"
+
+	// KMLContentType is the media type of KML documents
+	KMLContentType = "application/vnd.google-earth.kml+xml"
+
+	// exportCreator names the service in exported documents
+	exportCreator = "gps-tracking-service"
+)
+
+// GPX is a GPX 1.1 document holding the track of an object
+type GPX struct {
+	XMLName xml.Name `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Track   GPXTrack `xml:"trk"`
+}
+
+type GPXTrack struct {
+	Name    string     `xml:"name"`
+	Segment GPXSegment `xml:"trkseg"`
+}
+
+type GPXSegment struct {
+	Points []GPXPoint `xml:"trkpt"`
+}
+
+// GPXPoint is a fix of a GPX track.  Its elements follow the order of the
+// GPX schema.
+type GPXPoint struct {
+	Latitude   float64   `xml:"lat,attr"`
+	Longitude  float64   `xml:"lon,attr"`
+	Elevation  int64     `xml:"ele,omitempty"`
+	Time       time.Time `xml:"time"`
+	Satellites *int      `xml:"sat,omitempty"`
+	HDOP       *float64  `xml:"hdop,omitempty"`
+}
+
+// NewGPX returns the track, ordered oldest to newest, as a GPX document
+// with a single track named name
+func NewGPX(name string, track []Telemetry) GPX {
+	points := make([]GPXPoint, 0, len(track))
+	for _, t := range track {
+		points = append(points, GPXPoint{
+			Latitude:   t.Position.Latitude,
+			Longitude:  t.Position.Longitude,
+			Elevation:  t.Position.Elevation,
+			Time:       t.RecordedAt.UTC(),
+			Satellites: t.Satellites,
+			HDOP:       t.HDOP,
+		})
+	}
+	return GPX{
+		Version: "1.1",
+		Creator: exportCreator,
+		Track:   GPXTrack{Name: name, Segment: GPXSegment{Points: points}},
+	}
+}
+
+// KML is a KML 2.2 document holding the track of an object as a gx:Track,
+// which keeps the time of every fix
+type KML struct {
+	XMLName  xml.Name    `xml:"http://www.opengis.net/kml/2.2 kml"`
+	GX       string      `xml:"xmlns:gx,attr"`
+	Document KMLDocument `xml:"Document"`
+}
+
+type KMLDocument struct {
+	Name      string       `xml:"name"`
+	Placemark KMLPlacemark `xml:"Placemark"`
+}
+
+type KMLPlacemark struct {
+	Name  string   `xml:"name"`
+	Track KMLTrack `xml:"gx:Track"`
+}
+
+// KMLTrack pairs each time in When with the coordinates at the same index
+// in Coords, given as "longitude latitude elevation"
+type KMLTrack struct {
+	When   []time.Time `xml:"when"`
+	Coords []string    `xml:"gx:coord"`
+}
+
+// NewKML returns the track, ordered oldest to newest, as a KML document
+// with a single placemark named name
+func NewKML(name string, track []Telemetry) KML {
+	var kt KMLTrack
+	for _, t := range track {
+		kt.When = append(kt.When, t.RecordedAt.UTC())
+		kt.Coords = append(kt.Coords, fmt.Sprintf("%g %g %d", t.Position.Longitude, t.Position.Latitude, t.Position.Elevation))
+	}
+	return KML{
+		GX: "http://www.google.com/kml/ext/2.2",
+		Document: KMLDocument{
+			Name:      name,
+			Placemark: KMLPlacemark{Name: name, Track: kt},
+		},
+	}
+}
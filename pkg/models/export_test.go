@@ -0,0 +1,69 @@
+package models
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+var exportTrack = []Telemetry{
+	{Id: "truck", RecordedAt: time.Date(2020, 12, 15, 10, 0, 0, 0, time.UTC), Position: Position{Latitude: 45.5, Longitude: -122.6, Elevation: 12}},
+	{Id: "truck", RecordedAt: time.Date(2020, 12, 15, 10, 1, 0, 0, time.UTC), Position: Position{Latitude: 45.6, Longitude: -122.7}},
+}
+
+func TestNewGPX(t *testing.T) {
+	data, err := xml.Marshal(NewGPX("truck", exportTrack))
+	if err != nil {
+		t.Fatalf("could not marshal gpx: %s", err.Error())
+	}
+
+	var doc GPX
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("could not unmarshal gpx: %s", err.Error())
+	}
+	points := doc.Track.Segment.Points
+	if doc.Version != "1.1" || doc.Track.Name != "truck" || len(points) != 2 {
+		t.Fatalf("unexpected document: %s", data)
+	}
+	if points[0].Latitude != 45.5 || points[0].Elevation != 12 || !points[1].Time.Equal(exportTrack[1].RecordedAt) {
+		t.Errorf("unexpected points: %+v", points)
+	}
+	if strings.Contains(string(data), "<sat>") {
+		t.Errorf("expected readings left out of the fixes to be left out; got %s", data)
+	}
+}
+
+func TestNewKML(t *testing.T) {
+	data, err := xml.Marshal(NewKML("truck", exportTrack))
+	if err != nil {
+		t.Fatalf("could not marshal kml: %s", err.Error())
+	}
+
+	var doc struct {
+		Track struct {
+			When   []string `xml:"when"`
+			Coords []string `xml:"http://www.google.com/kml/ext/2.2 coord"`
+		} `xml:"Document>Placemark>Track"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("could not unmarshal kml: %s", err.Error())
+	}
+	if len(doc.Track.When) != 2 || doc.Track.When[0] != "2020-12-15T10:00:00Z" {
+		t.Errorf("unexpected times: %s", data)
+	}
+	if len(doc.Track.Coords) != 2 || doc.Track.Coords[0] != "-122.6 45.5 12" {
+		t.Errorf("unexpected coordinates: %s", data)
+	}
+}
+
+func TestNewLineString(t *testing.T) {
+	f := NewLineString("truck", exportTrack)
+	coordinates, ok := f.Geometry.Coordinates.([][]float64)
+	if f.Geometry.Type != "LineString" || !ok || len(coordinates) != 2 || coordinates[1][0] != -122.7 {
+		t.Errorf("unexpected geometry: %+v", f.Geometry)
+	}
+	if times, ok := f.Properties["coordTimes"].([]time.Time); !ok || len(times) != 2 {
+		t.Errorf("expected a time for every position; got %+v", f.Properties)
+	}
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+)
+
+const (
+	CircleGeofence  = "circle"
+	PolygonGeofence = "polygon"
+
+	GeofenceEnter = "enter"
+	GeofenceExit  = "exit"
+)
+
+type GeofenceReader interface {
+	GetGeofence(id string) (*Geofence, error)
+	GetAllGeofences() []Geofence
+	GeofenceEvents(id string, q HistoryQuery) ([]GeofenceEvent, error)
+}
+
+type GeofenceWriter interface {
+	AddGeofence(f Geofence) (string, error)
+	DeleteGeofence(id string) error
+	AddGeofenceEvent(e GeofenceEvent) error
+}
+
+type GeofenceReaderWriter interface {
+	GeofenceReader
+	GeofenceWriter
+}
+
+// TelemetryObserver is notified each time a telemetry update has been
+// accepted by the datastore.  Previous is nil when the object is new.
+type TelemetryObserver interface {
+	Observe(previous *Telemetry, current Telemetry)
+}
+
+type Geofence struct {
+	// Id is the unique id of the geofence
+	Id string `json:"id"`
+
+	// Name is a human friendly name of the fenced area such as a depot
+	Name string `json:"name" validate:"required"`
+
+	// Type is the shape of the fence; either circle or polygon
+	Type string `json:"type" validate:"required,oneof=circle polygon"`
+
+	// Center is the center of a circle fence
+	Center *geo.Point `json:"center,omitempty"`
+
+	// Radius is the radius, in meters, of a circle fence
+	Radius float64 `json:"radius,omitempty" validate:"gte=0"`
+
+	// Polygon is the GeoJSON polygon geometry of a polygon fence
+	Polygon geo.Polygon `json:"polygon,omitempty"`
+
+	// Tenant is the tenant the fence belongs to.  A fence without a tenant
+	// is shared by every tenant.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+type GeofenceEvent struct {
+	// GeofenceId is the id of the fence that was entered or exited
+	GeofenceId string `json:"geofenceId"`
+
+	// ObjectId is the id of the object that entered or exited the fence
+	ObjectId string `json:"objectId"`
+
+	// Type is either enter or exit
+	Type string `json:"type"`
+
+	// Position is where the object was when the event was detected
+	Position Position `json:"position"`
+
+	// Time is when the update that triggered the event was received
+	Time time.Time `json:"time"`
+
+	// Tenant is the tenant of the object
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Applies reports whether the fence watches objects of tenant; a shared
+// fence watches every object
+func (f *Geofence) Applies(tenant string) bool {
+	return f.Tenant == "" || f.Tenant == tenant
+}
+
+// Shape returns the area enclosed by the fence
+func (f *Geofence) Shape() geo.Shape {
+	if f.Type == CircleGeofence && f.Center != nil {
+		return geo.Circle{Center: *f.Center, Radius: f.Radius}
+	}
+	return f.Polygon
+}
+
+func (f *Geofence) FromJSON(r *http.Request) error {
+	if err := json.NewDecoder(r.Body).Decode(f); err != nil {
+		return fmt.Errorf("%w: %s", DecodeError, err)
+	}
+
+	if err := f.Validate(); err != nil {
+		return fmt.Errorf("%w: %s", ValidationError, err)
+	}
+
+	return nil
+}
+
+func (f *Geofence) Validate() error {
+	validate := validator.New()
+	if err := validate.Struct(f); err != nil {
+		return err
+	}
+
+	switch f.Type {
+	case CircleGeofence:
+		if f.Center == nil || f.Radius <= 0 {
+			return errors.New("circle geofences require a center and a positive radius")
+		}
+		if f.Center.Lat < -90 || f.Center.Lat > 90 || f.Center.Lon < -180 || f.Center.Lon > 180 {
+			return errors.New("circle geofence center is out of range")
+		}
+	case PolygonGeofence:
+		if len(f.Polygon) == 0 {
+			return errors.New("polygon geofences require a polygon")
+		}
+	}
+	return nil
+}
+
+// ApplyEvents filters events, ordered oldest to newest, down to the events
+// selected by the query.  The result keeps the oldest to newest ordering.
+func (q HistoryQuery) ApplyEvents(events []GeofenceEvent) []GeofenceEvent {
+	results := make([]GeofenceEvent, 0, len(events))
+	for _, e := range events {
+		if q.Includes(e.Time) {
+			results = append(results, e)
+		}
+	}
+
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[len(results)-q.Limit:]
+	}
+	return results
+}
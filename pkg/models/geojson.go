@@ -0,0 +1,99 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// GeoJSONContentType is the media type of GeoJSON documents (RFC 7946)
+	GeoJSONContentType = "application/geo+json"
+)
+
+// Geometry is a GeoJSON geometry.  Coordinates hold a single position for a
+// Point and a list of positions for a LineString.
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type Feature struct {
+	Type       string                 `json:"type"`
+	Id         string                 `json:"id,omitempty"`
+	Geometry   Geometry               `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Coordinates returns the GeoJSON position of p; longitude first, then
+// latitude, and the elevation in meters when one was reported.
+func (p Position) Coordinates() []float64 {
+	if p.Elevation != 0 {
+		return []float64{p.Longitude, p.Latitude, float64(p.Elevation)}
+	}
+	return []float64{p.Longitude, p.Latitude}
+}
+
+// Feature returns the telemetry as a GeoJSON Point feature.  Every field
+// other than the position is carried in the feature properties.
+func (t Telemetry) Feature() Feature {
+	properties := make(map[string]interface{})
+	if data, err := json.Marshal(t); err == nil {
+		json.Unmarshal(data, &properties)
+	}
+	delete(properties, "position")
+
+	return Feature{
+		Type: "Feature",
+		Id:   t.Id,
+		Geometry: Geometry{
+			Type:        "Point",
+			Coordinates: t.Position.Coordinates(),
+		},
+		Properties: properties,
+	}
+}
+
+// Feature returns the telemetry as a GeoJSON Point feature with the
+// distance from the query point as an additional property.
+func (p Proximity) Feature() Feature {
+	f := p.Telemetry.Feature()
+	f.Properties["distance"] = p.Distance
+	return f
+}
+
+// NewFeatureCollection returns the telemetry as a collection of Point
+// features in the order given
+func NewFeatureCollection(ts []Telemetry) FeatureCollection {
+	features := make([]Feature, 0, len(ts))
+	for _, t := range ts {
+		features = append(features, t.Feature())
+	}
+	return FeatureCollection{Type: "FeatureCollection", Features: features}
+}
+
+// NewLineString returns the track, ordered oldest to newest, as a single
+// LineString feature, the shape map renderers such as Mapbox GL draw and
+// tile most cheaply.  The recorded time of each position is carried in the
+// coordTimes property at the same index.
+func NewLineString(id string, track []Telemetry) Feature {
+	coordinates := make([][]float64, 0, len(track))
+	times := make([]time.Time, 0, len(track))
+	for _, t := range track {
+		coordinates = append(coordinates, t.Position.Coordinates())
+		times = append(times, t.RecordedAt)
+	}
+	return Feature{
+		Type: "Feature",
+		Id:   id,
+		Geometry: Geometry{
+			Type:        "LineString",
+			Coordinates: coordinates,
+		},
+		Properties: map[string]interface{}{"coordTimes": times},
+	}
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTelemetryFeature(t *testing.T) {
+	tm := Telemetry{
+		Id:       "gateway-1-0001",
+		Source:   "gateway-1",
+		ObjectID: "0001",
+		Status:   "moving",
+		Updated:  time.Date(2020, 12, 1, 10, 0, 0, 0, time.UTC),
+		Position: Position{Latitude: 45.5, Longitude: -122.6, Elevation: 30},
+	}
+
+	f := tm.Feature()
+	if f.Type != "Feature" || f.Id != tm.Id || f.Geometry.Type != "Point" {
+		t.Errorf("unexpected feature: %+v", f)
+	}
+
+	coords := f.Geometry.Coordinates.([]float64)
+	if len(coords) != 3 || coords[0] != -122.6 || coords[1] != 45.5 || coords[2] != 30 {
+		t.Errorf("expected [longitude, latitude, elevation]; got %v", coords)
+	}
+	if _, ok := f.Properties["position"]; ok {
+		t.Errorf("position should not be repeated in the properties")
+	}
+	if f.Properties["source"] != "gateway-1" || f.Properties["objectId"] != "0001" || f.Properties["status"] != "moving" {
+		t.Errorf("telemetry fields missing from properties: %v", f.Properties)
+	}
+
+	tm.Position.Elevation = 0
+	if coords := tm.Feature().Geometry.Coordinates.([]float64); len(coords) != 2 {
+		t.Errorf("expected no elevation when none was reported; got %v", coords)
+	}
+}
+
+func TestTelemetryFeatureReadings(t *testing.T) {
+	speed, ignition := 0.0, false
+	tm := Telemetry{Speed: &speed, Ignition: &ignition, Attributes: map[string]string{"fuel": "42"}, OdometerAnchor: &Position{}, PositionVariance: &speed}
+
+	f := tm.Feature()
+	if f.Properties["speed"] != 0.0 || f.Properties["ignition"] != false {
+		t.Errorf("expected zero readings in the properties; got %v", f.Properties)
+	}
+	if attributes, ok := f.Properties["attributes"].(map[string]interface{}); !ok || attributes["fuel"] != "42" {
+		t.Errorf("expected attributes in the properties; got %v", f.Properties)
+	}
+	for _, name := range []string{"heading", "accuracy", "hdop", "satellites", "battery", "odometerAnchor", "positionVariance"} {
+		if _, ok := f.Properties[name]; ok {
+			t.Errorf("expected unreported %s to be left out; got %v", name, f.Properties)
+		}
+	}
+}
+
+func TestFeatureCollection(t *testing.T) {
+	fc := NewFeatureCollection([]Telemetry{{Id: "a"}, {Id: "b"}})
+	data, err := json.Marshal(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Type string `json:"type"`
+			Id   string `json:"id"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Type != "FeatureCollection" || len(decoded.Features) != 2 || decoded.Features[1].Id != "b" {
+		t.Errorf("unexpected feature collection: %s", data)
+	}
+
+	empty, _ := json.Marshal(NewFeatureCollection(nil))
+	if string(empty) != `{"type":"FeatureCollection","features":[]}` {
+		t.Errorf("expected an empty feature list; got %s", empty)
+	}
+}
+
+func TestProximityFeature(t *testing.T) {
+	f := Proximity{Telemetry: Telemetry{Id: "a"}, Distance: 12.5}.Feature()
+	if f.Properties["distance"] != 12.5 {
+		t.Errorf("expected the distance property; got %v", f.Properties)
+	}
+}
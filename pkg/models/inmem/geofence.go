@@ -0,0 +1,131 @@
+package inmem
+
+import (
+	"sort"
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// DefaultGeofenceEvents is the number of events kept per geofence
+const DefaultGeofenceEvents = 1000
+
+// AddGeofence stores the geofence, replacing any geofence with the same id,
+// and returns its id.
+func (mem *InMemoryDB) AddGeofence(f models.Geofence) (string, error) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "AddGeofence").Observe(duration.Seconds())
+	}()
+
+	mem.fenceMu.Lock()
+	defer mem.fenceMu.Unlock()
+	mem.fences[f.Id] = f
+	return f.Id, nil
+}
+
+// GetGeofence returns the geofence with the passed id.
+// If the geofence is not found then a NotFound error is returned.
+func (mem *InMemoryDB) GetGeofence(id string) (*models.Geofence, error) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "GetGeofence").Observe(duration.Seconds())
+	}()
+
+	mem.fenceMu.RLock()
+	defer mem.fenceMu.RUnlock()
+	f, ok := mem.fences[id]
+	if !ok {
+		models.TransactionErrors.WithLabelValues("inmemdb", "GetGeofence").Inc()
+		return nil, models.ErrNoRecord
+	}
+	return &f, nil
+}
+
+// GetAllGeofences returns every known geofence ordered by id
+func (mem *InMemoryDB) GetAllGeofences() []models.Geofence {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "GetAllGeofences").Observe(duration.Seconds())
+	}()
+
+	mem.fenceMu.RLock()
+	results := make([]models.Geofence, 0, len(mem.fences))
+	for _, f := range mem.fences {
+		results = append(results, f)
+	}
+	mem.fenceMu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Id < results[j].Id
+	})
+	return results
+}
+
+// DeleteGeofence removes the geofence and its events.
+// If the geofence is not found then a NotFound error is returned.
+func (mem *InMemoryDB) DeleteGeofence(id string) error {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "DeleteGeofence").Observe(duration.Seconds())
+	}()
+
+	mem.fenceMu.Lock()
+	defer mem.fenceMu.Unlock()
+	if _, ok := mem.fences[id]; !ok {
+		models.TransactionErrors.WithLabelValues("inmemdb", "DeleteGeofence").Inc()
+		return models.ErrNoRecord
+	}
+	delete(mem.fences, id)
+	delete(mem.fenceEvents, id)
+	return nil
+}
+
+// AddGeofenceEvent appends an event to the feed of its geofence, dropping the
+// oldest events once the feed is full.
+func (mem *InMemoryDB) AddGeofenceEvent(e models.GeofenceEvent) error {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "AddGeofenceEvent").Observe(duration.Seconds())
+	}()
+
+	mem.fenceMu.Lock()
+	defer mem.fenceMu.Unlock()
+	if _, ok := mem.fences[e.GeofenceId]; !ok {
+		models.TransactionErrors.WithLabelValues("inmemdb", "AddGeofenceEvent").Inc()
+		return models.ErrNoRecord
+	}
+
+	events := mem.fenceEvents[e.GeofenceId]
+	if len(events) >= DefaultGeofenceEvents {
+		events = events[len(events)-DefaultGeofenceEvents+1:]
+	}
+	next := make([]models.GeofenceEvent, 0, len(events)+1)
+	mem.fenceEvents[e.GeofenceId] = append(append(next, events...), e)
+	return nil
+}
+
+// GeofenceEvents returns the event feed of the geofence, oldest event first.
+// If the geofence is not found then a NotFound error is returned.
+func (mem *InMemoryDB) GeofenceEvents(id string, q models.HistoryQuery) ([]models.GeofenceEvent, error) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "GeofenceEvents").Observe(duration.Seconds())
+	}()
+
+	mem.fenceMu.RLock()
+	_, ok := mem.fences[id]
+	events := mem.fenceEvents[id]
+	mem.fenceMu.RUnlock()
+	if !ok {
+		models.TransactionErrors.WithLabelValues("inmemdb", "GeofenceEvents").Inc()
+		return nil, models.ErrNoRecord
+	}
+	return q.ApplyEvents(events), nil
+}
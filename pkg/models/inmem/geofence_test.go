@@ -0,0 +1,92 @@
+package inmem
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+func TestGeofenceCRUD(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger)
+
+	fence := models.Geofence{
+		Id:     "depot",
+		Name:   "Depot",
+		Type:   models.CircleGeofence,
+		Center: &geo.Point{Lat: 45.5, Lon: -122.6},
+		Radius: 500,
+	}
+	if _, err := db.AddGeofence(fence); err != nil {
+		t.Fatalf("could not add geofence: %s", err.Error())
+	}
+
+	found, err := db.GetGeofence("depot")
+	if err != nil {
+		t.Fatalf("could not get geofence: %s", err.Error())
+	}
+	if found.Name != "Depot" {
+		t.Errorf("got %+v; expected %+v", found, fence)
+	}
+
+	fence.Name = "Main Depot"
+	db.AddGeofence(fence)
+	if all := db.GetAllGeofences(); len(all) != 1 || all[0].Name != "Main Depot" {
+		t.Errorf("expected the geofence to be replaced; got %v", all)
+	}
+
+	if err := db.DeleteGeofence("depot"); err != nil {
+		t.Errorf("could not delete geofence: %s", err.Error())
+	}
+	if _, err := db.GetGeofence("depot"); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected deleted geofence to be gone; got %v", err)
+	}
+	if err := db.DeleteGeofence("depot"); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected deleting a missing geofence to fail; got %v", err)
+	}
+}
+
+func TestGeofenceEvents(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger)
+
+	event := models.GeofenceEvent{GeofenceId: "depot", ObjectId: "vehicle", Type: models.GeofenceEnter}
+	if err := db.AddGeofenceEvent(event); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected events for unknown geofences to be rejected; got %v", err)
+	}
+
+	db.AddGeofence(models.Geofence{Id: "depot", Name: "Depot"})
+	start := time.Now()
+	for i := 0; i < DefaultGeofenceEvents+10; i++ {
+		event.Time = start.Add(time.Duration(i) * time.Second)
+		if err := db.AddGeofenceEvent(event); err != nil {
+			t.Fatalf("could not add geofence event: %s", err.Error())
+		}
+	}
+
+	events, err := db.GeofenceEvents("depot", models.HistoryQuery{})
+	if err != nil {
+		t.Fatalf("could not get geofence events: %s", err.Error())
+	}
+	if len(events) != DefaultGeofenceEvents {
+		t.Errorf("expected the feed to be capped at %d; got %d", DefaultGeofenceEvents, len(events))
+	}
+	if !events[0].Time.Equal(start.Add(10 * time.Second)) {
+		t.Errorf("expected the oldest events to be dropped; first event at %s", events[0].Time)
+	}
+
+	events, _ = db.GeofenceEvents("depot", models.HistoryQuery{Limit: 5})
+	if len(events) != 5 {
+		t.Errorf("expected 5 events; got %d", len(events))
+	}
+
+	db.DeleteGeofence("depot")
+	if _, err := db.GeofenceEvents("depot", models.HistoryQuery{}); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected events of a deleted geofence to be gone; got %v", err)
+	}
+}
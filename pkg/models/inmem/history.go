@@ -0,0 +1,78 @@
+package inmem
+
+import (
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// History returns the stored track of the object with the passed id, oldest
+// position first.  If the object has no stored track a NotFound error is
+// returned.
+func (mem *InMemoryDB) History(id string, q models.HistoryQuery) ([]models.Telemetry, error) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "History").Observe(duration.Seconds())
+	}()
+
+	mem.historyMu.RLock()
+	track, ok := mem.history[id]
+	mem.historyMu.RUnlock()
+	if !ok {
+		models.TransactionErrors.WithLabelValues("inmemdb", "History").Inc()
+		return nil, models.ErrNoRecord
+	}
+
+	return q.Apply(trim(track, 0, time.Now().Add(-mem.historyAge))), nil
+}
+
+// appendHistory records t as the newest position of its object, dropping
+// positions that no longer fit the configured history bounds.
+func (mem *InMemoryDB) appendHistory(t models.Telemetry) {
+	if mem.historySize <= 0 {
+		return
+	}
+
+	mem.historyMu.Lock()
+	defer mem.historyMu.Unlock()
+
+	// copy on write so readers holding the previous slice are never mutated
+	track := trim(mem.history[t.Id], 0, time.Now().Add(-mem.historyAge))
+	next := make([]models.Telemetry, 0, len(track)+1)
+	next = append(append(next, track...), t)
+	mem.history[t.Id] = trim(next, mem.historySize, time.Time{})
+}
+
+// pruneHistory drops positions older than the history age and forgets
+// objects whose whole track has aged out.
+func (mem *InMemoryDB) pruneHistory(now time.Time) {
+	cutoff := now.Add(-mem.historyAge)
+
+	mem.historyMu.Lock()
+	defer mem.historyMu.Unlock()
+
+	for id, track := range mem.history {
+		kept := trim(track, 0, cutoff)
+		if len(kept) == 0 {
+			delete(mem.history, id)
+			continue
+		}
+		mem.history[id] = kept
+	}
+}
+
+// trim returns the tail of track holding positions updated after cutoff,
+// capped to at most size positions when size is positive.
+func trim(track []models.Telemetry, size int, cutoff time.Time) []models.Telemetry {
+	i := 0
+	for i < len(track) && track[i].Updated.Before(cutoff) {
+		i++
+	}
+	track = track[i:]
+
+	if size > 0 && len(track) > size {
+		track = track[len(track)-size:]
+	}
+	return track
+}
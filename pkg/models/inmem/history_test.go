@@ -0,0 +1,130 @@
+package inmem
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+func TestHistoryOrdered(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger)
+
+	start := time.Now().Add(-time.Minute)
+	for i := 0; i < 10; i++ {
+		_, err := db.Add(models.Telemetry{
+			Id:       "vehicle",
+			Updated:  start.Add(time.Duration(i) * time.Second),
+			Position: models.Position{Latitude: float64(i), Longitude: float64(i)},
+		})
+		if err != nil {
+			t.Fatalf("error adding record to db: %s", err.Error())
+		}
+	}
+
+	track, err := db.History("vehicle", models.HistoryQuery{})
+	if err != nil {
+		t.Fatalf("error getting history: %s", err.Error())
+	}
+	if len(track) != 10 {
+		t.Fatalf("expected 10 positions; got %d", len(track))
+	}
+	for i, pos := range track {
+		if pos.Position.Latitude != float64(i) {
+			t.Errorf("position %d out of order: %v", i, pos.Position)
+		}
+	}
+
+	current, err := db.Get("vehicle")
+	if err != nil {
+		t.Fatalf("error getting current position: %s", err.Error())
+	}
+	if current.Position.Latitude != 9 {
+		t.Errorf("current position is not the latest: %v", current.Position)
+	}
+}
+
+func TestHistoryBounds(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger, WithHistory(5, time.Minute))
+
+	now := time.Now()
+	// the first positions are older than the history age
+	for i := 0; i < 10; i++ {
+		db.Add(models.Telemetry{
+			Id:       "vehicle",
+			Updated:  now.Add(-2 * time.Minute).Add(time.Duration(i) * time.Second),
+			Position: models.Position{Latitude: float64(i)},
+		})
+	}
+	for i := 0; i < 3; i++ {
+		db.Add(models.Telemetry{
+			Id:       "vehicle",
+			Updated:  now.Add(time.Duration(i) * time.Second),
+			Position: models.Position{Latitude: float64(100 + i)},
+		})
+	}
+
+	track, err := db.History("vehicle", models.HistoryQuery{})
+	if err != nil {
+		t.Fatalf("error getting history: %s", err.Error())
+	}
+	if len(track) != 3 {
+		t.Fatalf("expected aged positions to be dropped; got %d positions", len(track))
+	}
+
+	for i := 0; i < 10; i++ {
+		db.Add(models.Telemetry{
+			Id:       "vehicle",
+			Updated:  now.Add(time.Duration(10+i) * time.Second),
+			Position: models.Position{Latitude: float64(200 + i)},
+		})
+	}
+	track, _ = db.History("vehicle", models.HistoryQuery{})
+	if len(track) != 5 {
+		t.Fatalf("expected history capped at 5 positions; got %d", len(track))
+	}
+	if track[4].Position.Latitude != 209 {
+		t.Errorf("expected the newest position last; got %v", track[4].Position)
+	}
+}
+
+func TestHistoryQuery(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger)
+
+	start := time.Now().Add(-time.Minute)
+	for i := 0; i < 10; i++ {
+		db.Add(models.Telemetry{
+			Id:       "vehicle",
+			Updated:  start.Add(time.Duration(i) * time.Second),
+			Position: models.Position{Latitude: float64(i)},
+		})
+	}
+
+	track, _ := db.History("vehicle", models.HistoryQuery{
+		From: start.Add(2 * time.Second),
+		To:   start.Add(7 * time.Second),
+	})
+	if len(track) != 6 {
+		t.Errorf("expected 6 positions in window; got %d", len(track))
+	}
+
+	track, _ = db.History("vehicle", models.HistoryQuery{From: start.Add(2 * time.Second), Limit: 2})
+	if len(track) != 2 || track[1].Position.Latitude != 9 {
+		t.Errorf("expected the 2 most recent positions; got %v", track)
+	}
+}
+
+func TestHistoryNotFound(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger)
+
+	if _, err := db.History("foobar", models.HistoryQuery{}); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("History returned an unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package inmem
+
+import (
+	"math"
+	"sync"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+)
+
+// DefaultCellSize is the size, in degrees, of a spatial index cell.  A tenth
+// of a degree is roughly 11km of latitude.
+const DefaultCellSize = 0.1
+
+type cell struct {
+	lat, lon int
+}
+
+// grid is a fixed size latitude/longitude grid that buckets object ids by
+// their last known position so spatial queries only visit nearby cells.
+type grid struct {
+	size float64
+
+	mu      sync.RWMutex
+	cells   map[cell]map[string]geo.Point
+	objects map[string]cell
+}
+
+func newGrid(size float64) *grid {
+	return &grid{
+		size:    size,
+		cells:   make(map[cell]map[string]geo.Point),
+		objects: make(map[string]cell),
+	}
+}
+
+func (g *grid) cellOf(p geo.Point) cell {
+	return cell{
+		lat: int(math.Floor(p.Lat / g.size)),
+		lon: int(math.Floor(p.Lon / g.size)),
+	}
+}
+
+// insert indexes id at p, moving it out of its previous cell if needed
+func (g *grid) insert(id string, p geo.Point) {
+	c := g.cellOf(p)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if old, ok := g.objects[id]; ok && old != c {
+		g.removeFromCell(id, old)
+	}
+	members, ok := g.cells[c]
+	if !ok {
+		members = make(map[string]geo.Point)
+		g.cells[c] = members
+	}
+	members[id] = p
+	g.objects[id] = c
+}
+
+// remove drops id from the index
+func (g *grid) remove(id string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if c, ok := g.objects[id]; ok {
+		g.removeFromCell(id, c)
+		delete(g.objects, id)
+	}
+}
+
+func (g *grid) removeFromCell(id string, c cell) {
+	members := g.cells[c]
+	delete(members, id)
+	if len(members) == 0 {
+		delete(g.cells, c)
+	}
+}
+
+// search calls fn for every indexed object inside box
+func (g *grid) search(box geo.BBox, fn func(id string, p geo.Point)) {
+	lo := g.cellOf(geo.Point{Lat: box.MinLat, Lon: box.MinLon})
+	hi := g.cellOf(geo.Point{Lat: box.MaxLat, Lon: box.MaxLon})
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	visit := func(members map[string]geo.Point) {
+		for id, p := range members {
+			if box.Contains(p) {
+				fn(id, p)
+			}
+		}
+	}
+
+	// large boxes over a sparse grid are cheaper to answer by walking the
+	// occupied cells than by enumerating every cell the box covers
+	span := float64(hi.lat-lo.lat+1) * float64(hi.lon-lo.lon+1)
+	if span > float64(len(g.cells)) {
+		for c, members := range g.cells {
+			if c.lat >= lo.lat && c.lat <= hi.lat && c.lon >= lo.lon && c.lon <= hi.lon {
+				visit(members)
+			}
+		}
+		return
+	}
+
+	for lat := lo.lat; lat <= hi.lat; lat++ {
+		for lon := lo.lon; lon <= hi.lon; lon++ {
+			if members, ok := g.cells[cell{lat: lat, lon: lon}]; ok {
+				visit(members)
+			}
+		}
+	}
+}
@@ -1,43 +1,185 @@
 package inmem
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/nedscode/memdb"
 	"github.com/rs/zerolog"
 	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+	"scbunn.org/tmp/gps-tracking-service/pkg/wal"
+)
+
+const (
+	// DefaultHistorySize is the number of positions kept per object
+	DefaultHistorySize = 100
+
+	// DefaultHistoryAge is how long a position is kept in an object's history
+	DefaultHistoryAge = time.Hour
+
+	// DefaultTripAge is how long a trip is kept after it ended
+	DefaultTripAge = 7 * 24 * time.Hour
+
+	// DefaultStopAge is how long a stop is kept after the object departed
+	DefaultStopAge = 7 * 24 * time.Hour
 )
 
 type InMemoryDB struct {
-	db  *memdb.Store
-	log *zerolog.Logger
+	db    *memdb.Store
+	log   *zerolog.Logger
+	index *grid
+	ttl   models.TTL
+
+	snapshotPath string
+
+	// walMu is held for reading while a write is logged and applied, and
+	// for writing while the log is rotated for a snapshot
+	walMu sync.RWMutex
+	wal   *wal.Log
+
+	// putMu orders writes so an update is compared with the latest stored
+	// position of its object
+	putMu sync.Mutex
+
+	historySize int
+	historyAge  time.Duration
+	historyMu   sync.RWMutex
+	history     map[string][]models.Telemetry
+
+	fenceMu     sync.RWMutex
+	fences      map[string]models.Geofence
+	fenceEvents map[string][]models.GeofenceEvent
+
+	statusMu     sync.RWMutex
+	statusEvents map[string][]models.StatusEvent
+
+	tripAge time.Duration
+	tripMu  sync.RWMutex
+	trips   map[string][]models.Trip
+
+	stopAge time.Duration
+	stopMu  sync.RWMutex
+	stops   map[string][]models.Stop
+}
+
+// Option configures optional behaviour of the in memory database
+type Option func(*InMemoryDB)
+
+// WithHistory bounds the track kept for each object to at most size
+// positions no older than age.
+func WithHistory(size int, age time.Duration) Option {
+	return func(mem *InMemoryDB) {
+		mem.historySize = size
+		mem.historyAge = age
+	}
+}
+
+// WithTTL sets how long an object is kept after its last update
+func WithTTL(ttl time.Duration) Option {
+	return func(mem *InMemoryDB) {
+		mem.ttl.Default = ttl
+	}
+}
+
+// WithTripAge drops trips once they ended longer than age ago
+func WithTripAge(age time.Duration) Option {
+	return func(mem *InMemoryDB) {
+		mem.tripAge = age
+	}
 }
 
-func New(logger *zerolog.Logger) *InMemoryDB {
-	mdb := memdb.NewStore().PrimaryKey("id").Unique()
-	return &InMemoryDB{
-		db:  mdb,
-		log: logger,
+// WithStopAge drops stops once the object departed longer than age ago
+func WithStopAge(age time.Duration) Option {
+	return func(mem *InMemoryDB) {
+		mem.stopAge = age
 	}
 }
 
+// WithSourceTTL overrides the TTL of objects reported by the given sources
+func WithSourceTTL(sources map[string]time.Duration) Option {
+	return func(mem *InMemoryDB) {
+		mem.ttl.Sources = sources
+	}
+}
+
+func New(logger *zerolog.Logger, opts ...Option) *InMemoryDB {
+	// the status index answers status filters without walking the store
+	mdb := memdb.NewStore().PrimaryKey("id").Unique().CreateIndex("status")
+	mem := &InMemoryDB{
+		db:           mdb,
+		log:          logger,
+		index:        newGrid(DefaultCellSize),
+		ttl:          models.TTL{Default: models.DefaultTTL},
+		historySize:  DefaultHistorySize,
+		historyAge:   DefaultHistoryAge,
+		history:      make(map[string][]models.Telemetry),
+		fences:       make(map[string]models.Geofence),
+		fenceEvents:  make(map[string][]models.GeofenceEvent),
+		statusEvents: make(map[string][]models.StatusEvent),
+		tripAge:      DefaultTripAge,
+		trips:        make(map[string][]models.Trip),
+		stopAge:      DefaultStopAge,
+		stops:        make(map[string][]models.Stop),
+	}
+	for _, opt := range opts {
+		opt(mem)
+	}
+	return mem
+}
+
 // Expire will expire all objects that have exceeded their TTL
 // If successful, Expire will return the number of objects expired.
 func (mem *InMemoryDB) Expire() int {
 	var count int
-	objects := mem.GetAll()
-	for _, obj := range objects {
-		if obj.IsExpired() {
-			mem.log.Debug().Str("obj", obj.Id).Msg("object telemetry is stale")
-			mem.db.Delete(obj)
+	now := time.Now()
+
+	var expired []models.Telemetry
+	mem.db.Ascend(func(indexer interface{}) bool {
+		if t := indexer.(*models.Telemetry); mem.ttl.Expired(*t, now) {
+			expired = append(expired, *t)
+		}
+		return true
+	})
+
+	for _, obj := range expired {
+		if mem.expire(obj.Id, now) {
 			count++
 		}
 	}
+	mem.pruneHistory(now)
+	mem.pruneStatusEvents(now)
+	mem.pruneTrips(now)
+	mem.pruneStops(now)
 	mem.log.Info().Int("objects", count).Msg("stale objects expired")
 	return count
 }
 
+// expire removes the object with the passed id if it is still expired at
+// now, and reports whether it was removed.  putMu is held so an update
+// landing meanwhile is never removed with it.
+func (mem *InMemoryDB) expire(id string, now time.Time) bool {
+	mem.putMu.Lock()
+	defer mem.putMu.Unlock()
+
+	// the object may have been refreshed since the store was walked
+	current, ok := mem.db.InPrimaryKey().One(id).(*models.Telemetry)
+	if !ok || !mem.ttl.Expired(*current, now) {
+		return false
+	}
+
+	mem.log.Debug().Str("obj", id).Msg("object telemetry is stale")
+	if old, err := mem.db.Delete(current); err != nil || old == nil {
+		return false
+	}
+	mem.index.remove(id)
+	models.RecordCount.WithLabelValues("inmemdb").Dec()
+	models.ExpiredCount.WithLabelValues("inmemdb").Inc()
+	return true
+}
+
 // Add a new telemetry struct to the in memory database and return its id
 // as a string.  If the object can't be added, return an error.
 func (mem *InMemoryDB) Add(t models.Telemetry) (string, error) {
@@ -47,18 +189,81 @@ func (mem *InMemoryDB) Add(t models.Telemetry) (string, error) {
 		models.TransactionDuration.WithLabelValues("inmemdb", "Add").Observe(duration.Seconds())
 	}()
 
-	old, err := mem.db.Put(&t)
-	if err != nil {
+	mem.walMu.RLock()
+	defer mem.walMu.RUnlock()
+
+	if err := mem.logWrites(t); err != nil {
 		models.TransactionErrors.WithLabelValues("inmemdb", "Add").Inc()
 		return "", err
 	}
+	if err := mem.put(t); err != nil {
+		countError("Add", err)
+		return "", err
+	}
+	return t.Id, nil
+}
+
+// AddBatch adds every telemetry struct of the batch to the in memory
+// database, returning the outcome of each in the order given.
+func (mem *InMemoryDB) AddBatch(ts []models.Telemetry) []models.WriteResult {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "AddBatch").Observe(duration.Seconds())
+	}()
+
+	mem.walMu.RLock()
+	defer mem.walMu.RUnlock()
+
+	results := make([]models.WriteResult, len(ts))
+	if err := mem.logWrites(ts...); err != nil {
+		for i := range results {
+			results[i].Err = err
+			models.TransactionErrors.WithLabelValues("inmemdb", "AddBatch").Inc()
+		}
+		return results
+	}
+	for i, t := range ts {
+		if results[i].Err = mem.put(t); results[i].Err != nil {
+			countError("AddBatch", results[i].Err)
+			continue
+		}
+		results[i].Id = t.Id
+	}
+	return results
+}
+
+// countError counts a failed write of op, telling refused stale updates
+// apart from errors
+func countError(op string, err error) {
+	if errors.Is(err, models.ErrStaleUpdate) {
+		models.StaleCount.WithLabelValues("inmemdb").Inc()
+		return
+	}
+	models.TransactionErrors.WithLabelValues("inmemdb", op).Inc()
+}
+
+// put stores t as the latest telemetry of its object, unless the stored
+// telemetry was recorded after it
+func (mem *InMemoryDB) put(t models.Telemetry) error {
+	mem.putMu.Lock()
+	defer mem.putMu.Unlock()
+
+	if current, ok := mem.db.InPrimaryKey().One(t.Id).(*models.Telemetry); ok && t.OlderThan(*current) {
+		return models.ErrStaleUpdate
+	}
+	old, err := mem.db.Put(&t)
+	if err != nil {
+		return err
+	}
 
 	if old == nil {
 		// must be a new record
 		models.RecordCount.WithLabelValues("inmemdb").Inc()
 	}
-
-	return t.Id, nil
+	mem.index.insert(t.Id, t.Position.Point())
+	mem.appendHistory(t)
+	return nil
 }
 
 // Get will return the telemetry of the object with the passed id.
@@ -95,6 +300,48 @@ func (mem *InMemoryDB) GetAll() []models.Telemetry {
 	return results
 }
 
+// Find returns the page of telemetry objects selected by the query.  Area
+// queries are answered from the spatial index and status queries from the
+// status index; every other filter is applied while walking the store.
+func (mem *InMemoryDB) Find(q models.Query) (models.Page, error) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "Find").Observe(duration.Seconds())
+	}()
+
+	var candidates []models.Telemetry
+	switch {
+	case q.Area != nil:
+		for id := range mem.search(q.Area) {
+			found := mem.db.InPrimaryKey().One(id)
+			if t, ok := found.(*models.Telemetry); ok && q.Match(*t) {
+				candidates = append(candidates, *t)
+			}
+		}
+	case q.Status != "":
+		for _, found := range mem.db.In("status").Lookup(string(q.Status)) {
+			if t, ok := found.(*models.Telemetry); ok && q.Match(*t) {
+				candidates = append(candidates, *t)
+			}
+		}
+	default:
+		mem.db.Ascend(func(indexer interface{}) bool {
+			t := indexer.(*models.Telemetry)
+			if q.Match(*t) {
+				candidates = append(candidates, *t)
+			}
+			return true
+		})
+	}
+
+	page, err := q.Apply(candidates)
+	if err != nil {
+		models.TransactionErrors.WithLabelValues("inmemdb", "Find").Inc()
+	}
+	return page, err
+}
+
 // Alive returns the health status of the database
 // If the database is in a state the is nonrecoverable it will
 // return an error
@@ -116,3 +363,16 @@ func (mem *InMemoryDB) Ready() (map[string]string, error) {
 		"message": fmt.Sprintf("up; %d active objects", mem.db.Len()),
 	}, nil
 }
+
+// Close releases the database, first saving a snapshot when a snapshot file
+// is configured, and closes its write-ahead log.
+func (mem *InMemoryDB) Close(ctx context.Context) error {
+	mem.log.Info().Int("objects", mem.db.Len()).Msg("closing in memory database")
+	err := mem.SaveSnapshot()
+	if mem.wal != nil {
+		if werr := mem.wal.Close(); err == nil {
+			err = werr
+		}
+	}
+	return err
+}
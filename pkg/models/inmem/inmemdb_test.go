@@ -4,10 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
 	"scbunn.org/tmp/gps-tracking-service/pkg/models"
 )
 
@@ -129,3 +133,154 @@ func TestReadyCheck(t *testing.T) {
 		t.Errorf("ready health check missing ready key")
 	}
 }
+
+func TestAddBatch(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger)
+
+	var batch []models.Telemetry
+	for i := 0; i < 10; i++ {
+		batch = append(batch, models.Telemetry{Id: uuid.New().String()})
+	}
+
+	results := db.AddBatch(batch)
+	if len(results) != len(batch) {
+		t.Fatalf("expected %d results; got %d", len(batch), len(results))
+	}
+	for i, r := range results {
+		if r.Err != nil || r.Id != batch[i].Id {
+			t.Errorf("unexpected result %d: %+v", i, r)
+		}
+	}
+	if len(db.GetAll()) != 10 {
+		t.Errorf("expected 10 records; got %d", len(db.GetAll()))
+	}
+}
+
+func TestFind(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger)
+
+	for i := 0; i < 10; i++ {
+		db.Add(models.Telemetry{
+			Id:       fmt.Sprintf("obj-%d", i),
+			Source:   fmt.Sprintf("gateway-%d", i%2),
+			Position: models.Position{Latitude: float64(i), Longitude: float64(i)},
+		})
+	}
+
+	page, err := db.Find(models.Query{Source: "gateway-0", Limit: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(page.Items) != 3 || page.Items[0].Id != "obj-0" || page.Next == "" {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+	page, _ = db.Find(models.Query{Source: "gateway-0", Limit: 3, Cursor: page.Next})
+	if len(page.Items) != 2 || page.Items[0].Id != "obj-6" || page.Next != "" {
+		t.Errorf("unexpected last page: %+v", page)
+	}
+
+	area := geo.BBox{MinLat: -0.5, MinLon: -0.5, MaxLat: 4.5, MaxLon: 4.5}
+	page, _ = db.Find(models.Query{Area: area, Source: "gateway-1"})
+	if len(page.Items) != 2 || page.Items[0].Id != "obj-1" || page.Items[1].Id != "obj-3" {
+		t.Errorf("unexpected objects in area: %+v", page.Items)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger, WithTTL(30*time.Second), WithSourceTTL(map[string]time.Duration{"slow": time.Hour}))
+
+	now := time.Now()
+	db.Add(models.Telemetry{Id: "fresh", Updated: now})
+	db.Add(models.Telemetry{Id: "stale", Updated: now.Add(-time.Minute)})
+	db.Add(models.Telemetry{Id: "slow", Source: "slow", Updated: now.Add(-time.Minute)})
+
+	records := testutil.ToFloat64(models.RecordCount.WithLabelValues("inmemdb"))
+	expired, _ := models.GetCounterValue(models.ExpiredCount, "inmemdb")
+
+	if count := db.Expire(); count != 1 {
+		t.Errorf("expected 1 object to expire; got %d", count)
+	}
+	if _, err := db.Get("stale"); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected stale object to be removed; got %v", err)
+	}
+	if results, _ := db.Nearby(geo.Point{}, 1); len(results) != 2 {
+		t.Errorf("expected stale object to leave the spatial index; got %v", results)
+	}
+
+	if got := testutil.ToFloat64(models.RecordCount.WithLabelValues("inmemdb")); got != records-1 {
+		t.Errorf("expected record count to drop to %f; got %f", records-1, got)
+	}
+	if got, _ := models.GetCounterValue(models.ExpiredCount, "inmemdb"); got != expired+1 {
+		t.Errorf("expected expired count of %f; got %f", expired+1, got)
+	}
+}
+
+func TestExpireDuringPut(t *testing.T) {
+	logger := zerolog.Nop()
+	db := New(&logger, WithTTL(30*time.Second))
+
+	const objects = 50
+	now := time.Now()
+	for i := 0; i < objects; i++ {
+		db.Add(models.Telemetry{Id: fmt.Sprintf("obj-%d", i), Updated: now.Add(-time.Minute)})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < objects; i++ {
+			db.Add(models.Telemetry{Id: fmt.Sprintf("obj-%d", i), Updated: time.Now()})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < objects; i++ {
+			db.Expire()
+		}
+	}()
+	wg.Wait()
+
+	for i := 0; i < objects; i++ {
+		if _, err := db.Get(fmt.Sprintf("obj-%d", i)); err != nil {
+			t.Errorf("expected refreshed object obj-%d to survive expiry; got %v", i, err)
+		}
+	}
+	if results, _ := db.Nearby(geo.Point{}, 1); len(results) != objects {
+		t.Errorf("expected %d objects in the spatial index; got %d", objects, len(results))
+	}
+}
+
+func TestStaleUpdate(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger)
+	now := time.Now()
+	stale := models.StaleCount.WithLabelValues("inmemdb")
+	before := testutil.ToFloat64(stale)
+
+	if _, err := db.Add(models.Telemetry{Id: "a", RecordedAt: now, Position: models.Position{Latitude: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Add(models.Telemetry{Id: "a", RecordedAt: now.Add(-time.Minute), Position: models.Position{Latitude: 2}}); !errors.Is(err, models.ErrStaleUpdate) {
+		t.Errorf("expected an older fix to be refused; got %v", err)
+	}
+
+	results := db.AddBatch([]models.Telemetry{
+		{Id: "a", RecordedAt: now, Position: models.Position{Latitude: 3}},
+		{Id: "a", RecordedAt: now.Add(-time.Second), Position: models.Position{Latitude: 4}},
+	})
+	if results[0].Err != nil || !errors.Is(results[1].Err, models.ErrStaleUpdate) {
+		t.Errorf("expected the same recorded time to win and an older one to be refused; got %+v", results)
+	}
+
+	stored, _ := db.Get("a")
+	if stored.Position.Latitude != 3 {
+		t.Errorf("expected the latest fix to be kept; got %+v", stored.Position)
+	}
+	if got := testutil.ToFloat64(stale) - before; got != 2 {
+		t.Errorf("expected 2 stale updates to be counted; got %f", got)
+	}
+}
@@ -0,0 +1,311 @@
+package inmem
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// SnapshotVersion is the version of the snapshot format written by Snapshot
+const SnapshotVersion uint32 = 1
+
+// snapshotMagic identifies a snapshot file
+var snapshotMagic = [8]byte{'G', 'P', 'S', 'S', 'N', 'A', 'P', 0}
+
+var (
+	// ErrSnapshotCorrupt is returned when a snapshot fails its checksum or
+	// can not be decoded
+	ErrSnapshotCorrupt = errors.New("inmem: snapshot is corrupt")
+
+	// ErrSnapshotVersion is returned when a snapshot was written in a format
+	// this version does not understand
+	ErrSnapshotVersion = errors.New("inmem: unsupported snapshot version")
+)
+
+// snapshotHeader precedes the encoded state in a snapshot.  Length and
+// Checksum (CRC-32C) cover the encoded state that follows the header.
+type snapshotHeader struct {
+	Magic    [8]byte
+	Version  uint32
+	Length   uint64
+	Checksum uint32
+}
+
+// snapshotState is the encoded state of the database
+type snapshotState struct {
+	Taken        time.Time
+	Telemetry    []models.Telemetry
+	History      map[string][]models.Telemetry
+	Fences       []models.Geofence
+	FenceEvents  map[string][]models.GeofenceEvent
+	StatusEvents map[string][]models.StatusEvent
+	Trips        map[string][]models.Trip
+	Stops        map[string][]models.Stop
+}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// WithSnapshot sets the file the database is snapshotted to by SaveSnapshot
+// and Close, and restored from by RestoreSnapshot.
+func WithSnapshot(path string) Option {
+	return func(mem *InMemoryDB) {
+		mem.snapshotPath = path
+	}
+}
+
+// Snapshot writes the current objects, histories, geofences, status events,
+// trips, and stops to w
+func (mem *InMemoryDB) Snapshot(w io.Writer) error {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "Snapshot").Observe(duration.Seconds())
+	}()
+
+	state := snapshotState{
+		Taken:        start,
+		Telemetry:    mem.GetAll(),
+		History:      make(map[string][]models.Telemetry),
+		Fences:       mem.GetAllGeofences(),
+		FenceEvents:  make(map[string][]models.GeofenceEvent),
+		StatusEvents: make(map[string][]models.StatusEvent),
+		Trips:        make(map[string][]models.Trip),
+		Stops:        make(map[string][]models.Stop),
+	}
+	mem.historyMu.RLock()
+	for id, track := range mem.history {
+		state.History[id] = track
+	}
+	mem.historyMu.RUnlock()
+	mem.fenceMu.RLock()
+	for id, events := range mem.fenceEvents {
+		state.FenceEvents[id] = events
+	}
+	mem.fenceMu.RUnlock()
+	mem.statusMu.RLock()
+	for id, events := range mem.statusEvents {
+		state.StatusEvents[id] = events
+	}
+	mem.statusMu.RUnlock()
+	mem.tripMu.RLock()
+	for id, trips := range mem.trips {
+		state.Trips[id] = trips
+	}
+	mem.tripMu.RUnlock()
+	mem.stopMu.RLock()
+	for id, stops := range mem.stops {
+		state.Stops[id] = stops
+	}
+	mem.stopMu.RUnlock()
+
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(state); err != nil {
+		models.TransactionErrors.WithLabelValues("inmemdb", "Snapshot").Inc()
+		return err
+	}
+
+	header := snapshotHeader{
+		Magic:    snapshotMagic,
+		Version:  SnapshotVersion,
+		Length:   uint64(payload.Len()),
+		Checksum: crc32.Checksum(payload.Bytes(), crcTable),
+	}
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		models.TransactionErrors.WithLabelValues("inmemdb", "Snapshot").Inc()
+		return err
+	}
+	if _, err := payload.WriteTo(w); err != nil {
+		models.TransactionErrors.WithLabelValues("inmemdb", "Snapshot").Inc()
+		return err
+	}
+	return nil
+}
+
+// Restore loads the state written by Snapshot from r, replacing objects and
+// geofences with the same id.  Objects that outlived their TTL and positions
+// older than the history age are dropped.  Restore returns the number of
+// objects restored.
+func (mem *InMemoryDB) Restore(r io.Reader) (int, error) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "Restore").Observe(duration.Seconds())
+	}()
+
+	state, err := readSnapshot(r)
+	if err != nil {
+		models.TransactionErrors.WithLabelValues("inmemdb", "Restore").Inc()
+		return 0, err
+	}
+
+	var count int
+	now := time.Now()
+	for _, t := range state.Telemetry {
+		if mem.ttl.Expired(t, now) {
+			continue
+		}
+		t := t
+		old, err := mem.db.Put(&t)
+		if err != nil {
+			models.TransactionErrors.WithLabelValues("inmemdb", "Restore").Inc()
+			return count, err
+		}
+		if old == nil {
+			models.RecordCount.WithLabelValues("inmemdb").Inc()
+		}
+		mem.index.insert(t.Id, t.Position.Point())
+		count++
+	}
+
+	cutoff := now.Add(-mem.historyAge)
+	mem.historyMu.Lock()
+	for id, track := range state.History {
+		if kept := trim(track, mem.historySize, cutoff); len(kept) > 0 && mem.historySize > 0 {
+			mem.history[id] = kept
+		}
+	}
+	mem.historyMu.Unlock()
+
+	mem.fenceMu.Lock()
+	for _, f := range state.Fences {
+		mem.fences[f.Id] = f
+	}
+	for id, events := range state.FenceEvents {
+		mem.fenceEvents[id] = events
+	}
+	mem.fenceMu.Unlock()
+
+	mem.statusMu.Lock()
+	for id, events := range state.StatusEvents {
+		mem.statusEvents[id] = events
+	}
+	mem.statusMu.Unlock()
+	mem.pruneStatusEvents(now)
+
+	mem.tripMu.Lock()
+	for id, trips := range state.Trips {
+		mem.trips[id] = trips
+	}
+	mem.tripMu.Unlock()
+
+	mem.stopMu.Lock()
+	for id, stops := range state.Stops {
+		mem.stops[id] = stops
+	}
+	mem.stopMu.Unlock()
+
+	mem.log.Info().
+		Int("objects", count).
+		Int("expired", len(state.Telemetry)-count).
+		Time("taken", state.Taken).
+		Msg("restored snapshot")
+	return count, nil
+}
+
+func readSnapshot(r io.Reader) (snapshotState, error) {
+	var state snapshotState
+	var header snapshotHeader
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return state, fmt.Errorf("%w: %s", ErrSnapshotCorrupt, err)
+	}
+	if header.Magic != snapshotMagic {
+		return state, fmt.Errorf("%w: not a snapshot", ErrSnapshotCorrupt)
+	}
+	if header.Version != SnapshotVersion {
+		return state, fmt.Errorf("%w: %d", ErrSnapshotVersion, header.Version)
+	}
+
+	payload, err := ioutil.ReadAll(io.LimitReader(r, int64(header.Length)))
+	if err != nil {
+		return state, err
+	}
+	if uint64(len(payload)) != header.Length {
+		return state, fmt.Errorf("%w: truncated", ErrSnapshotCorrupt)
+	}
+	if crc32.Checksum(payload, crcTable) != header.Checksum {
+		return state, fmt.Errorf("%w: checksum mismatch", ErrSnapshotCorrupt)
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&state); err != nil {
+		return state, fmt.Errorf("%w: %s", ErrSnapshotCorrupt, err)
+	}
+	return state, nil
+}
+
+// SaveSnapshot snapshots the database to the configured snapshot file.  The
+// snapshot is written to a temporary file that replaces the previous
+// snapshot only once it is complete, so a crash never leaves a partial
+// snapshot behind.  The write-ahead log, if any, is truncated to the writes
+// the snapshot may not hold.
+func (mem *InMemoryDB) SaveSnapshot() error {
+	if mem.snapshotPath == "" {
+		return nil
+	}
+
+	seq, err := mem.rotateWAL()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(mem.snapshotPath), filepath.Base(mem.snapshotPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := mem.Snapshot(w); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), mem.snapshotPath); err != nil {
+		return err
+	}
+	mem.truncateWAL(seq)
+
+	mem.log.Info().Str("path", mem.snapshotPath).Int("objects", mem.db.Len()).Msg("saved snapshot")
+	return nil
+}
+
+// RestoreSnapshot restores the database from the configured snapshot file.
+// A missing snapshot file is not an error; there is simply nothing to
+// restore.
+func (mem *InMemoryDB) RestoreSnapshot() (int, error) {
+	if mem.snapshotPath == "" {
+		return 0, nil
+	}
+
+	f, err := os.Open(mem.snapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		mem.log.Info().Str("path", mem.snapshotPath).Msg("no snapshot to restore")
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return mem.Restore(bufio.NewReader(f))
+}
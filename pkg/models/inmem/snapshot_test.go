@@ -0,0 +1,153 @@
+package inmem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger, WithTTL(time.Minute))
+
+	now := time.Now()
+	speed := 12.5
+	db.Add(models.Telemetry{Id: "fresh", Source: "gateway", Updated: now.Add(-2 * time.Second), Position: models.Position{Latitude: 45.5, Longitude: -122.6}})
+	db.Add(models.Telemetry{Id: "fresh", Source: "gateway", Updated: now, Status: models.StatusMoving, Position: models.Position{Latitude: 45.6, Longitude: -122.6}, Speed: &speed, Attributes: map[string]string{"fuel": "42"}})
+	db.Add(models.Telemetry{Id: "stale", Source: "gateway", Updated: now.Add(-time.Hour + time.Minute), Position: models.Position{Latitude: 1, Longitude: 1}})
+	db.AddGeofence(models.Geofence{Id: "depot", Name: "Depot", Type: models.CircleGeofence, Center: &geo.Point{Lat: 45.5, Lon: -122.6}, Radius: 100})
+	db.AddGeofenceEvent(models.GeofenceEvent{GeofenceId: "depot", ObjectId: "fresh", Type: models.GeofenceExit, Time: now})
+	db.AddStatusEvent(models.StatusEvent{ObjectId: "fresh", To: models.StatusMoving, Time: now})
+	db.SaveTrip(models.Trip{Id: "1", ObjectId: "fresh", Start: models.TripPoint{Time: now}, End: models.TripPoint{Time: now}})
+	db.SaveStop(models.Stop{Id: "1", ObjectId: "fresh", Arrived: now, Departed: now})
+
+	var buf bytes.Buffer
+	if err := db.Snapshot(&buf); err != nil {
+		t.Fatalf("could not snapshot: %s", err.Error())
+	}
+
+	restored := New(&logger, WithTTL(time.Minute))
+	count, err := restored.Restore(&buf)
+	if err != nil {
+		t.Fatalf("could not restore: %s", err.Error())
+	}
+	if count != 1 {
+		t.Errorf("expected 1 object restored; got %d", count)
+	}
+
+	found, err := restored.Get("fresh")
+	if err != nil || found.Position.Latitude != 45.6 || !found.Updated.Equal(now) {
+		t.Errorf("object did not survive a round trip: %+v, %v", found, err)
+	}
+	if found.Speed == nil || *found.Speed != speed || found.Attributes["fuel"] != "42" {
+		t.Errorf("readings did not survive a round trip: %+v", found)
+	}
+	if _, err := restored.Get("stale"); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected expired object to be dropped; got %v", err)
+	}
+	if results, _ := restored.Nearby(geo.Point{Lat: 45.6, Lon: -122.6}, 10); len(results) != 1 {
+		t.Errorf("expected restored object to be indexed; got %v", results)
+	}
+	if track, _ := restored.History("fresh", models.HistoryQuery{}); len(track) != 2 {
+		t.Errorf("expected history to be restored; got %v", track)
+	}
+	if _, err := restored.GetGeofence("depot"); err != nil {
+		t.Errorf("expected geofence to be restored; got %v", err)
+	}
+	if events, _ := restored.GeofenceEvents("depot", models.HistoryQuery{}); len(events) != 1 {
+		t.Errorf("expected geofence events to be restored; got %v", events)
+	}
+	if events, _ := restored.StatusEvents("fresh", models.HistoryQuery{}); len(events) != 1 {
+		t.Errorf("expected status events to be restored; got %v", events)
+	}
+	if trips, _ := restored.Trips("fresh", models.HistoryQuery{}); len(trips) != 1 {
+		t.Errorf("expected trips to be restored; got %v", trips)
+	}
+	if stops, _ := restored.Stops("fresh", models.HistoryQuery{}); len(stops) != 1 {
+		t.Errorf("expected stops to be restored; got %v", stops)
+	}
+	if page, _ := restored.Find(models.Query{Status: models.StatusMoving}); len(page.Items) != 1 {
+		t.Errorf("expected restored object to be indexed by status; got %v", page.Items)
+	}
+}
+
+func TestRestoreCorrupt(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger)
+	db.Add(models.Telemetry{Id: "obj", Updated: time.Now()})
+
+	var buf bytes.Buffer
+	if err := db.Snapshot(&buf); err != nil {
+		t.Fatalf("could not snapshot: %s", err.Error())
+	}
+	good := buf.Bytes()
+
+	flipped := append([]byte{}, good...)
+	flipped[len(flipped)-1] ^= 0xff
+	version := append([]byte{}, good...)
+	version[11] = 99
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{name: "Empty", data: nil, want: ErrSnapshotCorrupt},
+		{name: "NotASnapshot", data: []byte("definitely not a snapshot file"), want: ErrSnapshotCorrupt},
+		{name: "Truncated", data: good[:len(good)-4], want: ErrSnapshotCorrupt},
+		{name: "Checksum", data: flipped, want: ErrSnapshotCorrupt},
+		{name: "Version", data: version, want: ErrSnapshotVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restored := New(&logger)
+			if _, err := restored.Restore(bytes.NewReader(tt.data)); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v; got %v", tt.want, err)
+			}
+			if len(restored.GetAll()) != 0 {
+				t.Errorf("expected nothing to be restored")
+			}
+		})
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "inmem.snap")
+
+	// a missing snapshot is not an error
+	db := New(&logger, WithSnapshot(path))
+	if count, err := db.RestoreSnapshot(); count != 0 || err != nil {
+		t.Fatalf("expected nothing to restore; got %d, %v", count, err)
+	}
+
+	db.Add(models.Telemetry{Id: "obj", Updated: time.Now()})
+	if err := db.Close(context.Background()); err != nil {
+		t.Fatalf("could not close: %s", err.Error())
+	}
+
+	restored := New(&logger, WithSnapshot(path))
+	if count, err := restored.RestoreSnapshot(); count != 1 || err != nil {
+		t.Errorf("expected 1 object restored on startup; got %d, %v", count, err)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("expected only the snapshot file to remain; got %d files", len(files))
+	}
+}
@@ -0,0 +1,66 @@
+package inmem
+
+import (
+	"sort"
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// Nearby returns every object within radius meters of center, closest first.
+func (mem *InMemoryDB) Nearby(center geo.Point, radius float64) ([]models.Proximity, error) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "Nearby").Observe(duration.Seconds())
+	}()
+
+	results := []models.Proximity{}
+	for id, p := range mem.search(geo.Circle{Center: center, Radius: radius}) {
+		found := mem.db.InPrimaryKey().One(id)
+		if t, ok := found.(*models.Telemetry); ok {
+			results = append(results, models.Proximity{Telemetry: *t, Distance: geo.Distance(center, p)})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Distance < results[j].Distance
+	})
+	return results, nil
+}
+
+// Within returns every object whose last known position is inside area
+func (mem *InMemoryDB) Within(area geo.Shape) ([]models.Telemetry, error) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "Within").Observe(duration.Seconds())
+	}()
+
+	results := []models.Telemetry{}
+	for id := range mem.search(area) {
+		found := mem.db.InPrimaryKey().One(id)
+		if t, ok := found.(*models.Telemetry); ok {
+			results = append(results, *t)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Id < results[j].Id
+	})
+	return results, nil
+}
+
+// search returns the indexed position of every object inside area
+func (mem *InMemoryDB) search(area geo.Shape) map[string]geo.Point {
+	found := make(map[string]geo.Point)
+	for _, box := range area.Bounds() {
+		mem.index.search(box, func(id string, p geo.Point) {
+			if area.Contains(p) {
+				found[id] = p
+			}
+		})
+	}
+	return found
+}
@@ -0,0 +1,143 @@
+package inmem
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+func TestNearby(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger)
+
+	positions := map[string]models.Position{
+		"downtown":  {Latitude: 45.5152, Longitude: -122.6784},
+		"pearl":     {Latitude: 45.5290, Longitude: -122.6850},
+		"beaverton": {Latitude: 45.4871, Longitude: -122.8037},
+		"seattle":   {Latitude: 47.6062, Longitude: -122.3321},
+	}
+	for id, p := range positions {
+		if _, err := db.Add(models.Telemetry{Id: id, Position: p}); err != nil {
+			t.Fatalf("error adding record to db: %s", err.Error())
+		}
+	}
+
+	results, err := db.Nearby(geo.Point{Lat: 45.5152, Lon: -122.6784}, 5000)
+	if err != nil {
+		t.Fatalf("error querying nearby objects: %s", err.Error())
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 objects within 5km; got %d: %v", len(results), results)
+	}
+	if results[0].Id != "downtown" || results[1].Id != "pearl" {
+		t.Errorf("results not sorted by distance: %v", results)
+	}
+	if results[0].Distance != 0 || results[1].Distance < 1000 || results[1].Distance > 2000 {
+		t.Errorf("unexpected distances: %f, %f", results[0].Distance, results[1].Distance)
+	}
+
+	// moving an object moves it in the index
+	db.Add(models.Telemetry{Id: "pearl", Position: positions["seattle"]})
+	results, _ = db.Nearby(geo.Point{Lat: 45.5152, Lon: -122.6784}, 5000)
+	if len(results) != 1 {
+		t.Errorf("expected moved object to leave the area; got %v", results)
+	}
+
+	results, _ = db.Nearby(geo.Point{Lat: 45.5152, Lon: -122.6784}, 500000)
+	if len(results) != 4 {
+		t.Errorf("expected every object within 500km; got %d", len(results))
+	}
+}
+
+func TestNearbyAntimeridian(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger)
+
+	db.Add(models.Telemetry{Id: "east", Position: models.Position{Latitude: 0, Longitude: 179.99}})
+	db.Add(models.Telemetry{Id: "west", Position: models.Position{Latitude: 0, Longitude: -179.99}})
+
+	results, _ := db.Nearby(geo.Point{Lat: 0, Lon: 180}, 5000)
+	if len(results) != 2 {
+		t.Errorf("expected both sides of the antimeridian; got %v", results)
+	}
+}
+
+func TestGridSearch(t *testing.T) {
+	g := newGrid(DefaultCellSize)
+	for i := 0; i < 100; i++ {
+		g.insert(fmt.Sprintf("%d", i), geo.Point{Lat: float64(i) / 10, Lon: float64(i) / 10})
+	}
+
+	tests := []struct {
+		name string
+		box  geo.BBox
+		want int
+	}{
+		{name: "SmallBox", box: geo.BBox{MinLat: 0, MinLon: 0, MaxLat: 0.45, MaxLon: 0.45}, want: 5},
+		{name: "WholeWorld", box: geo.BBox{MinLat: -90, MinLon: -180, MaxLat: 90, MaxLon: 180}, want: 100},
+		{name: "Empty", box: geo.BBox{MinLat: -10, MinLon: -10, MaxLat: -5, MaxLon: -5}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found := 0
+			g.search(tt.box, func(id string, p geo.Point) { found++ })
+			if found != tt.want {
+				t.Errorf("got %d objects; want %d", found, tt.want)
+			}
+		})
+	}
+
+	g.remove("0")
+	found := 0
+	g.search(geo.BBox{MinLat: -1, MinLon: -1, MaxLat: 0.05, MaxLon: 0.05}, func(id string, p geo.Point) { found++ })
+	if found != 0 {
+		t.Errorf("removed object is still indexed")
+	}
+}
+
+func TestWithin(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger)
+
+	db.Add(models.Telemetry{Id: "inside", Position: models.Position{Latitude: 45.52, Longitude: -122.68}})
+	db.Add(models.Telemetry{Id: "hole", Position: models.Position{Latitude: 45.55, Longitude: -122.65}})
+	db.Add(models.Telemetry{Id: "outside", Position: models.Position{Latitude: 45.40, Longitude: -122.68}})
+	db.Add(models.Telemetry{Id: "dateline", Position: models.Position{Latitude: 0, Longitude: -179.5}})
+
+	poly := geo.Polygon{
+		{{Lat: 45.50, Lon: -122.70}, {Lat: 45.50, Lon: -122.60}, {Lat: 45.60, Lon: -122.60}, {Lat: 45.60, Lon: -122.70}, {Lat: 45.50, Lon: -122.70}},
+		{{Lat: 45.54, Lon: -122.66}, {Lat: 45.54, Lon: -122.64}, {Lat: 45.56, Lon: -122.64}, {Lat: 45.56, Lon: -122.66}, {Lat: 45.54, Lon: -122.66}},
+	}
+
+	tests := []struct {
+		name string
+		area geo.Shape
+		want []string
+	}{
+		{name: "BBox", area: geo.BBox{MinLat: 45.5, MinLon: -122.7, MaxLat: 45.6, MaxLon: -122.6}, want: []string{"hole", "inside"}},
+		{name: "Polygon", area: poly, want: []string{"inside"}},
+		{name: "Antimeridian", area: geo.BBox{MinLat: -1, MinLon: 179, MaxLat: 1, MaxLon: -179}, want: []string{"dateline"}},
+		{name: "Empty", area: geo.BBox{MinLat: 0, MinLon: 0, MaxLat: 1, MaxLon: 1}, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := db.Within(tt.area)
+			if err != nil {
+				t.Fatalf("error querying area: %s", err.Error())
+			}
+			if len(results) != len(tt.want) {
+				t.Fatalf("got %d objects; want %v", len(results), tt.want)
+			}
+			for i, id := range tt.want {
+				if results[i].Id != id {
+					t.Errorf("got %s; want %s", results[i].Id, id)
+				}
+			}
+		})
+	}
+}
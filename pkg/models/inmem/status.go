@@ -0,0 +1,84 @@
+package inmem
+
+import (
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// DefaultStatusEvents is the number of status events kept per object
+const DefaultStatusEvents = 100
+
+// AddStatusEvent appends an event to the status feed of its object, dropping
+// the oldest events once the feed is full.
+func (mem *InMemoryDB) AddStatusEvent(e models.StatusEvent) error {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "AddStatusEvent").Observe(duration.Seconds())
+	}()
+
+	mem.statusMu.Lock()
+	defer mem.statusMu.Unlock()
+
+	// copy on write so readers holding the previous slice are never mutated
+	events := mem.statusEvents[e.ObjectId]
+	if len(events) >= DefaultStatusEvents {
+		events = events[len(events)-DefaultStatusEvents+1:]
+	}
+	next := make([]models.StatusEvent, 0, len(events)+1)
+	mem.statusEvents[e.ObjectId] = append(append(next, events...), e)
+	return nil
+}
+
+// StatusEvents returns the status feed of the object with the passed id,
+// oldest event first.  Events are kept as long as the positions of the
+// object's history.  If the object has no status events a NotFound error is
+// returned.
+func (mem *InMemoryDB) StatusEvents(id string, q models.HistoryQuery) ([]models.StatusEvent, error) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "StatusEvents").Observe(duration.Seconds())
+	}()
+
+	mem.statusMu.RLock()
+	events, ok := mem.statusEvents[id]
+	mem.statusMu.RUnlock()
+	if !ok {
+		models.TransactionErrors.WithLabelValues("inmemdb", "StatusEvents").Inc()
+		return nil, models.ErrNoRecord
+	}
+
+	q.From = latest(q.From, time.Now().Add(-mem.historyAge))
+	return q.ApplyStatusEvents(events), nil
+}
+
+// pruneStatusEvents drops status events older than the history age and
+// forgets objects whose whole feed has aged out.
+func (mem *InMemoryDB) pruneStatusEvents(now time.Time) {
+	cutoff := now.Add(-mem.historyAge)
+
+	mem.statusMu.Lock()
+	defer mem.statusMu.Unlock()
+
+	for id, events := range mem.statusEvents {
+		i := 0
+		for i < len(events) && events[i].Time.Before(cutoff) {
+			i++
+		}
+		if i == len(events) {
+			delete(mem.statusEvents, id)
+			continue
+		}
+		mem.statusEvents[id] = events[i:]
+	}
+}
+
+// latest returns the later of a and b
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
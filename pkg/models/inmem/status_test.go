@@ -0,0 +1,81 @@
+package inmem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+func TestFindByStatus(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger)
+
+	for i := 0; i < 9; i++ {
+		db.Add(models.Telemetry{
+			Id:     fmt.Sprintf("obj-%d", i),
+			Source: fmt.Sprintf("gateway-%d", i%2),
+			Status: []models.Status{models.StatusMoving, models.StatusIdle, models.StatusStopped}[i%3],
+		})
+	}
+
+	page, err := db.Find(models.Query{Status: models.StatusMoving})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(page.Items) != 3 || page.Items[0].Id != "obj-0" || page.Items[2].Id != "obj-6" {
+		t.Errorf("unexpected moving objects: %+v", page.Items)
+	}
+
+	// a changed status moves the object between index entries
+	db.Add(models.Telemetry{Id: "obj-0", Source: "gateway-0", Status: models.StatusIdle})
+	page, _ = db.Find(models.Query{Status: models.StatusMoving})
+	if len(page.Items) != 2 {
+		t.Errorf("expected 2 moving objects; got %+v", page.Items)
+	}
+	page, _ = db.Find(models.Query{Status: models.StatusIdle, Source: "gateway-0"})
+	if len(page.Items) != 2 || page.Items[0].Id != "obj-0" || page.Items[1].Id != "obj-4" {
+		t.Errorf("unexpected idle objects of gateway-0: %+v", page.Items)
+	}
+	page, _ = db.Find(models.Query{Status: models.StatusMaintenance})
+	if len(page.Items) != 0 {
+		t.Errorf("expected no objects in maintenance; got %+v", page.Items)
+	}
+}
+
+func TestStatusEvents(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger, WithHistory(DefaultHistorySize, time.Hour))
+
+	if _, err := db.StatusEvents("vehicle", models.HistoryQuery{}); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected an object without events to be not found; got %v", err)
+	}
+
+	now := time.Now()
+	db.AddStatusEvent(models.StatusEvent{ObjectId: "vehicle", To: models.StatusIdle, Time: now.Add(-2 * time.Hour)})
+	for i := 0; i < DefaultStatusEvents+5; i++ {
+		db.AddStatusEvent(models.StatusEvent{ObjectId: "vehicle", From: models.StatusIdle, To: models.StatusMoving, Time: now})
+	}
+
+	events, err := db.StatusEvents("vehicle", models.HistoryQuery{})
+	if err != nil || len(events) != DefaultStatusEvents {
+		t.Errorf("expected %d events; got %d (%v)", DefaultStatusEvents, len(events), err)
+	}
+	if events, _ := db.StatusEvents("vehicle", models.HistoryQuery{Limit: 2}); len(events) != 2 {
+		t.Errorf("expected the limit to apply; got %d events", len(events))
+	}
+
+	// events age out with the history of the object
+	db.AddStatusEvent(models.StatusEvent{ObjectId: "parked", To: models.StatusStopped, Time: now.Add(-2 * time.Hour)})
+	if events, err := db.StatusEvents("parked", models.HistoryQuery{}); err != nil || len(events) != 0 {
+		t.Errorf("expected aged out events to be hidden; got %v (%v)", events, err)
+	}
+	db.Expire()
+	if _, err := db.StatusEvents("parked", models.HistoryQuery{}); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected aged out events to be pruned; got %v", err)
+	}
+}
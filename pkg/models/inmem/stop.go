@@ -0,0 +1,104 @@
+package inmem
+
+import (
+	"sort"
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// DefaultStops is the number of stops kept per object
+const DefaultStops = 100
+
+// SaveStop stores the stop, replacing the stop of its object with the same
+// id, and drops the oldest stops of the object once it has too many.
+func (mem *InMemoryDB) SaveStop(s models.Stop) error {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "SaveStop").Observe(duration.Seconds())
+	}()
+
+	mem.stopMu.Lock()
+	defer mem.stopMu.Unlock()
+
+	// copy on write so readers holding the previous slice are never mutated
+	stops := mem.stops[s.ObjectId]
+	if n := len(stops); n > 0 && stops[n-1].Id == s.Id {
+		stops = stops[:n-1]
+	}
+	if len(stops) >= DefaultStops {
+		stops = stops[len(stops)-DefaultStops+1:]
+	}
+	next := make([]models.Stop, 0, len(stops)+1)
+	mem.stops[s.ObjectId] = append(append(next, stops...), s)
+	return nil
+}
+
+// Stops returns the stops of the object with the passed id, oldest stop
+// first.  If the object has no stops a NotFound error is returned.
+func (mem *InMemoryDB) Stops(id string, q models.HistoryQuery) ([]models.Stop, error) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "Stops").Observe(duration.Seconds())
+	}()
+
+	mem.stopMu.RLock()
+	stops, ok := mem.stops[id]
+	mem.stopMu.RUnlock()
+	if !ok {
+		models.TransactionErrors.WithLabelValues("inmemdb", "Stops").Inc()
+		return nil, models.ErrNoRecord
+	}
+	return q.ApplyStops(stops), nil
+}
+
+// FindStops returns the stops inside area, or anywhere when area is nil, of
+// the objects of tenants, or of every tenant when tenants is nil, ordered by
+// arrival.
+func (mem *InMemoryDB) FindStops(area geo.Shape, tenants []string, q models.HistoryQuery) ([]models.Stop, error) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "FindStops").Observe(duration.Seconds())
+	}()
+
+	var results []models.Stop
+	mem.stopMu.RLock()
+	for _, stops := range mem.stops {
+		for _, s := range stops {
+			if s.InTenant(tenants) && (area == nil || area.Contains(s.Position.Point())) {
+				results = append(results, s)
+			}
+		}
+	}
+	mem.stopMu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Arrived.Before(results[j].Arrived)
+	})
+	return q.ApplyStops(results), nil
+}
+
+// pruneStops drops stops the object departed longer than the stop age ago
+// and forgets objects whose stops have all aged out.
+func (mem *InMemoryDB) pruneStops(now time.Time) {
+	cutoff := now.Add(-mem.stopAge)
+
+	mem.stopMu.Lock()
+	defer mem.stopMu.Unlock()
+
+	for id, stops := range mem.stops {
+		i := 0
+		for i < len(stops) && stops[i].Departed.Before(cutoff) {
+			i++
+		}
+		if i == len(stops) {
+			delete(mem.stops, id)
+			continue
+		}
+		mem.stops[id] = stops[i:]
+	}
+}
@@ -0,0 +1,105 @@
+package inmem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+func TestStops(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger)
+
+	if _, err := db.Stops("vehicle", models.HistoryQuery{}); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected an object without stops to be not found; got %v", err)
+	}
+
+	start := time.Date(2020, 12, 15, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < DefaultStops+5; i++ {
+		at := start.Add(time.Duration(i) * time.Hour)
+		stop := models.Stop{
+			Id:         fmt.Sprintf("%d", i),
+			ObjectId:   "vehicle",
+			Position:   models.Position{Latitude: 45, Longitude: -122},
+			Arrived:    at,
+			Departed:   at.Add(10 * time.Minute),
+			InProgress: true,
+		}
+		db.SaveStop(stop)
+		stop.Departed = at.Add(30 * time.Minute)
+		stop.InProgress = false
+		db.SaveStop(stop)
+	}
+
+	stops, err := db.Stops("vehicle", models.HistoryQuery{})
+	if err != nil || len(stops) != DefaultStops {
+		t.Fatalf("expected %d stops; got %d (%v)", DefaultStops, len(stops), err)
+	}
+	if stops[0].Id != "5" || stops[0].InProgress {
+		t.Errorf("expected the oldest stops to be dropped and saved stops replaced; got %+v", stops[0])
+	}
+
+	// stops overlapping the window are selected
+	from := start.Add(10*time.Hour + 20*time.Minute)
+	stops, _ = db.Stops("vehicle", models.HistoryQuery{From: from, To: from.Add(time.Hour)})
+	if len(stops) != 2 || stops[0].Id != "10" || stops[1].Id != "11" {
+		t.Errorf("expected stops 10 and 11; got %+v", stops)
+	}
+}
+
+func TestStopAge(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger, WithStopAge(time.Hour))
+
+	now := time.Now()
+	for i, departed := range []time.Time{now.Add(-2 * time.Hour), now.Add(-time.Minute)} {
+		db.SaveStop(models.Stop{Id: fmt.Sprintf("%d", i), ObjectId: "vehicle", Departed: departed})
+	}
+	db.SaveStop(models.Stop{Id: "0", ObjectId: "parked", Departed: now.Add(-2 * time.Hour)})
+	db.Expire()
+
+	if stops, _ := db.Stops("vehicle", models.HistoryQuery{}); len(stops) != 1 || stops[0].Id != "1" {
+		t.Errorf("expected only the recent stop to be kept; got %+v", stops)
+	}
+	if _, err := db.Stops("parked", models.HistoryQuery{}); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected an object whose stops aged out to be forgotten; got %v", err)
+	}
+	if stops, _ := db.FindStops(nil, nil, models.HistoryQuery{}); len(stops) != 1 {
+		t.Errorf("expected aged out stops to leave the search; got %+v", stops)
+	}
+}
+
+func TestFindStops(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger)
+
+	start := time.Date(2020, 12, 15, 10, 0, 0, 0, time.UTC)
+	db.SaveStop(models.Stop{Id: "1", ObjectId: "truck", Position: models.Position{Latitude: 45, Longitude: -122}, Arrived: start.Add(time.Hour)})
+	db.SaveStop(models.Stop{Id: "1", ObjectId: "van", Position: models.Position{Latitude: 45.001, Longitude: -122}, Arrived: start})
+	db.SaveStop(models.Stop{Id: "1", ObjectId: "car", Position: models.Position{Latitude: 10, Longitude: 10}, Arrived: start})
+
+	stops, err := db.FindStops(nil, nil, models.HistoryQuery{})
+	if err != nil || len(stops) != 3 {
+		t.Fatalf("expected the stops of the fleet; got %v (%v)", stops, err)
+	}
+
+	box := geo.BBox{MinLat: 44, MinLon: -123, MaxLat: 46, MaxLon: -121}
+	stops, _ = db.FindStops(box, nil, models.HistoryQuery{})
+	if len(stops) != 2 || stops[0].ObjectId != "van" || stops[1].ObjectId != "truck" {
+		t.Errorf("expected the stops in the box ordered by arrival; got %+v", stops)
+	}
+	// tenants are filtered before the limit
+	db.SaveStop(models.Stop{Id: "1", ObjectId: "acme:bus", Tenant: "acme", Arrived: start})
+	db.SaveStop(models.Stop{Id: "2", ObjectId: "acme:bus", Tenant: "acme", Arrived: start.Add(2 * time.Hour)})
+	db.SaveStop(models.Stop{Id: "1", ObjectId: "globex:bus", Tenant: "globex", Arrived: start.Add(3 * time.Hour)})
+	stops, _ = db.FindStops(nil, []string{"acme"}, models.HistoryQuery{Limit: 2})
+	if len(stops) != 2 || stops[0].Id != "1" || stops[1].Id != "2" || stops[1].Tenant != "acme" {
+		t.Errorf("expected the 2 most recent stops of the tenant; got %+v", stops)
+	}
+}
@@ -0,0 +1,75 @@
+package inmem
+
+import (
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// DefaultTrips is the number of trips kept per object
+const DefaultTrips = 100
+
+// SaveTrip stores the trip, replacing the trip of its object with the same
+// id, and drops the oldest trips of the object once it has too many.
+func (mem *InMemoryDB) SaveTrip(t models.Trip) error {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "SaveTrip").Observe(duration.Seconds())
+	}()
+
+	mem.tripMu.Lock()
+	defer mem.tripMu.Unlock()
+
+	// copy on write so readers holding the previous slice are never mutated
+	trips := mem.trips[t.ObjectId]
+	if n := len(trips); n > 0 && trips[n-1].Id == t.Id {
+		trips = trips[:n-1]
+	}
+	if len(trips) >= DefaultTrips {
+		trips = trips[len(trips)-DefaultTrips+1:]
+	}
+	next := make([]models.Trip, 0, len(trips)+1)
+	mem.trips[t.ObjectId] = append(append(next, trips...), t)
+	return nil
+}
+
+// Trips returns the trips of the object with the passed id, oldest trip
+// first.  If the object has no trips a NotFound error is returned.
+func (mem *InMemoryDB) Trips(id string, q models.HistoryQuery) ([]models.Trip, error) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		models.TransactionDuration.WithLabelValues("inmemdb", "Trips").Observe(duration.Seconds())
+	}()
+
+	mem.tripMu.RLock()
+	trips, ok := mem.trips[id]
+	mem.tripMu.RUnlock()
+	if !ok {
+		models.TransactionErrors.WithLabelValues("inmemdb", "Trips").Inc()
+		return nil, models.ErrNoRecord
+	}
+	return q.ApplyTrips(trips), nil
+}
+
+// pruneTrips drops trips that ended longer than the trip age ago and
+// forgets objects whose trips have all aged out.
+func (mem *InMemoryDB) pruneTrips(now time.Time) {
+	cutoff := now.Add(-mem.tripAge)
+
+	mem.tripMu.Lock()
+	defer mem.tripMu.Unlock()
+
+	for id, trips := range mem.trips {
+		i := 0
+		for i < len(trips) && trips[i].End.Time.Before(cutoff) {
+			i++
+		}
+		if i == len(trips) {
+			delete(mem.trips, id)
+			continue
+		}
+		mem.trips[id] = trips[i:]
+	}
+}
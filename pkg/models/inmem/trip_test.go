@@ -0,0 +1,71 @@
+package inmem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+func TestTrips(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger)
+
+	if _, err := db.Trips("vehicle", models.HistoryQuery{}); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected an object without trips to be not found; got %v", err)
+	}
+
+	start := time.Date(2020, 12, 15, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < DefaultTrips+5; i++ {
+		at := start.Add(time.Duration(i) * time.Hour)
+		trip := models.Trip{
+			Id:         fmt.Sprintf("%d", i),
+			ObjectId:   "vehicle",
+			Start:      models.TripPoint{Time: at},
+			End:        models.TripPoint{Time: at.Add(10 * time.Minute)},
+			InProgress: true,
+		}
+		db.SaveTrip(trip)
+		trip.End.Time = at.Add(30 * time.Minute)
+		trip.InProgress = false
+		db.SaveTrip(trip)
+	}
+
+	trips, err := db.Trips("vehicle", models.HistoryQuery{})
+	if err != nil || len(trips) != DefaultTrips {
+		t.Fatalf("expected %d trips; got %d (%v)", DefaultTrips, len(trips), err)
+	}
+	if trips[0].Id != "5" || trips[0].InProgress {
+		t.Errorf("expected the oldest trips to be dropped and saved trips replaced; got %+v", trips[0])
+	}
+
+	// trips overlapping the window are selected
+	from := start.Add(10*time.Hour + 20*time.Minute)
+	trips, _ = db.Trips("vehicle", models.HistoryQuery{From: from, To: from.Add(time.Hour)})
+	if len(trips) != 2 || trips[0].Id != "10" || trips[1].Id != "11" {
+		t.Errorf("expected trips 10 and 11; got %+v", trips)
+	}
+}
+
+func TestTripAge(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := New(&logger, WithTripAge(time.Hour))
+
+	now := time.Now()
+	for i, ended := range []time.Time{now.Add(-2 * time.Hour), now.Add(-time.Minute)} {
+		db.SaveTrip(models.Trip{Id: fmt.Sprintf("%d", i), ObjectId: "vehicle", End: models.TripPoint{Time: ended}})
+	}
+	db.SaveTrip(models.Trip{Id: "0", ObjectId: "parked", End: models.TripPoint{Time: now.Add(-2 * time.Hour)}})
+	db.Expire()
+
+	if trips, _ := db.Trips("vehicle", models.HistoryQuery{}); len(trips) != 1 || trips[0].Id != "1" {
+		t.Errorf("expected only the recent trip to be kept; got %+v", trips)
+	}
+	if _, err := db.Trips("parked", models.HistoryQuery{}); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected an object whose trips aged out to be forgotten; got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package inmem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+	"scbunn.org/tmp/gps-tracking-service/pkg/wal"
+)
+
+// walEntry is a telemetry write recorded in the write-ahead log.  The id,
+// odometer anchor, and position variance are stored alongside the telemetry
+// since they are not part of its JSON.
+type walEntry struct {
+	Id               string           `json:"id"`
+	Telemetry        models.Telemetry `json:"telemetry"`
+	OdometerAnchor   *models.Position `json:"odometerAnchor,omitempty"`
+	PositionVariance *float64         `json:"positionVariance,omitempty"`
+}
+
+// WithWAL records every write in l before it is applied and acknowledged.
+// The log is truncated after each snapshot and closed by Close.
+func WithWAL(l *wal.Log) Option {
+	return func(mem *InMemoryDB) {
+		mem.wal = l
+	}
+}
+
+// logWrites appends ts to the write-ahead log, if there is one.  mem.walMu
+// must be held for reading by the caller.
+func (mem *InMemoryDB) logWrites(ts ...models.Telemetry) error {
+	if mem.wal == nil {
+		return nil
+	}
+
+	records := make([][]byte, len(ts))
+	for i, t := range ts {
+		data, err := json.Marshal(walEntry{Id: t.Id, Telemetry: t, OdometerAnchor: t.OdometerAnchor, PositionVariance: t.PositionVariance})
+		if err != nil {
+			return err
+		}
+		records[i] = data
+	}
+	return mem.wal.Append(records...)
+}
+
+// ReplayWAL applies the writes recorded in the write-ahead log that are
+// newer than the restored state, returning the number applied.  It is meant
+// to be called on startup, after RestoreSnapshot.
+func (mem *InMemoryDB) ReplayWAL() (int, error) {
+	if mem.wal == nil {
+		return 0, nil
+	}
+
+	var count, skipped int
+	now := time.Now()
+	err := mem.wal.Replay(func(data []byte) error {
+		var e walEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return fmt.Errorf("%w: %s", models.DecodeError, err)
+		}
+		t := e.Telemetry
+		t.Id = e.Id
+		t.OdometerAnchor = e.OdometerAnchor
+		t.PositionVariance = e.PositionVariance
+
+		// the snapshot may already hold this write, or the object may have
+		// expired while the service was down
+		current, ok := mem.db.InPrimaryKey().One(t.Id).(*models.Telemetry)
+		if (ok && !t.Updated.After(current.Updated)) || mem.ttl.Expired(t, now) {
+			skipped++
+			return nil
+		}
+		err := mem.put(t)
+		if errors.Is(err, models.ErrStaleUpdate) {
+			skipped++
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+
+	mem.log.Info().Int("applied", count).Int("skipped", skipped).Msg("replayed write-ahead log")
+	return count, err
+}
+
+// rotateWAL starts a new write-ahead log segment for a snapshot, returning
+// the segment that the log can be truncated before once the snapshot is
+// saved.  Writes are held while rotating so that every write in an earlier
+// segment has been applied by the time the snapshot is taken.
+func (mem *InMemoryDB) rotateWAL() (uint64, error) {
+	if mem.wal == nil {
+		return 0, nil
+	}
+
+	mem.walMu.Lock()
+	defer mem.walMu.Unlock()
+	return mem.wal.Rotate()
+}
+
+// truncateWAL removes the write-ahead log segments covered by a snapshot
+func (mem *InMemoryDB) truncateWAL(seq uint64) {
+	if mem.wal == nil {
+		return
+	}
+	if err := mem.wal.TruncateBefore(seq); err != nil {
+		mem.log.Error().Err(err).Msg("unable to truncate write-ahead log")
+	}
+}
@@ -0,0 +1,86 @@
+package inmem
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+	"scbunn.org/tmp/gps-tracking-service/pkg/wal"
+)
+
+func TestWALRecovery(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	dir, err := ioutil.TempDir("", "inmem-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snapshot := filepath.Join(dir, "inmem.snap")
+	logDir := filepath.Join(dir, "wal")
+
+	open := func() *InMemoryDB {
+		l, err := wal.Open(logDir, wal.Options{Sync: wal.SyncAlways}, &logger)
+		if err != nil {
+			t.Fatalf("could not open wal: %s", err.Error())
+		}
+		return New(&logger, WithSnapshot(snapshot), WithWAL(l))
+	}
+
+	now := time.Now()
+	db := open()
+	db.Add(models.Telemetry{Id: "snapshotted", Updated: now})
+	if err := db.SaveSnapshot(); err != nil {
+		t.Fatalf("could not snapshot: %s", err.Error())
+	}
+	anchor, variance := models.Position{Latitude: 2}, 25.0
+	db.Add(models.Telemetry{Id: "logged", Updated: now, OdometerAnchor: &anchor, PositionVariance: &variance})
+	db.AddBatch([]models.Telemetry{
+		{Id: "batched", Updated: now},
+		{Id: "snapshotted", Updated: now.Add(time.Second), Position: models.Position{Latitude: 1}},
+	})
+	// crash without closing
+
+	recovered := open()
+	defer recovered.Close(context.Background())
+	if _, err := recovered.RestoreSnapshot(); err != nil {
+		t.Fatalf("could not restore snapshot: %s", err.Error())
+	}
+	count, err := recovered.ReplayWAL()
+	if err != nil {
+		t.Fatalf("could not replay wal: %s", err.Error())
+	}
+	if count != 3 {
+		t.Errorf("expected 3 writes replayed; got %d", count)
+	}
+
+	for _, id := range []string{"snapshotted", "logged", "batched"} {
+		if _, err := recovered.Get(id); err != nil {
+			t.Errorf("expected %s to be recovered; got %v", id, err)
+		}
+	}
+	if found, _ := recovered.Get("logged"); found.OdometerAnchor == nil || *found.OdometerAnchor != anchor {
+		t.Errorf("expected the odometer anchor to be recovered; got %+v", found)
+	}
+	if found, _ := recovered.Get("logged"); found.PositionVariance == nil || *found.PositionVariance != variance {
+		t.Errorf("expected the position variance to be recovered; got %+v", found)
+	}
+	if found, _ := recovered.Get("snapshotted"); found.Position.Latitude != 1 {
+		t.Errorf("expected the logged write to replace the snapshot; got %+v", found)
+	}
+	if track, _ := recovered.History("snapshotted", models.HistoryQuery{}); len(track) != 2 {
+		t.Errorf("expected history without duplicates; got %d positions", len(track))
+	}
+
+	// a snapshot truncates the log to the writes it may not hold
+	if err := recovered.SaveSnapshot(); err != nil {
+		t.Fatalf("could not snapshot: %s", err.Error())
+	}
+	if count, _ := recovered.ReplayWAL(); count != 0 {
+		t.Errorf("expected the log to be truncated; replayed %d writes", count)
+	}
+}
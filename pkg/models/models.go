@@ -1,21 +1,38 @@
 package models
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"time"
 
-	"github.com/go-playground/validator"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
 )
 
 type TelemetryWriter interface {
 	Add(t Telemetry) (string, error)
+	AddBatch(ts []Telemetry) []WriteResult
+}
+
+// WriteResult is the outcome of writing one telemetry update of a batch.
+// Err is nil when the update was stored under Id.
+type WriteResult struct {
+	Id  string
+	Err error
 }
 
 type TelemetryReader interface {
 	Get(id string) (*Telemetry, error)
 	GetAll() []Telemetry
+	Find(q Query) (Page, error)
+	History(id string, q HistoryQuery) ([]Telemetry, error)
+	SpatialReader
+}
+
+type SpatialReader interface {
+	Nearby(center geo.Point, radius float64) ([]Proximity, error)
+	Within(area geo.Shape) ([]Telemetry, error)
 }
 
 type HealthChecker interface {
@@ -28,25 +45,39 @@ type TelemetryReaderWriter interface {
 	TelemetryWriter
 }
 
+// Closer is implemented by datastores that hold resources or buffered
+// writes.  Close flushes what it can before ctx is done and releases them.
+type Closer interface {
+	Close(ctx context.Context) error
+}
+
 type TelemetryReaderWriterChecker interface {
 	TelemetryReaderWriter
 	HealthChecker
+	Closer
 }
 
 type Position struct {
 	// Latitude specifies the north-south position of a location on the globe as degrees
-	// and is represented using decimal degrees (DD)
-	Latitude float64 `json:"latitude" validate:"required"`
+	// and is represented using decimal degrees (DD).  Zero, the equator, is a valid
+	// latitude.
+	Latitude float64 `json:"latitude" validate:"min=-90,max=90"`
 
 	// Longitude specifies the east-west position of a location on the globe as degrees
-	// represented as decimal degrees (DD)
-	Longitude float64 `json:"longitude" validate:"required"`
+	// represented as decimal degrees (DD).  Zero, the prime meridian, is a valid
+	// longitude.
+	Longitude float64 `json:"longitude" validate:"min=-180,max=180"`
 
 	// Elevation represents the meters above sea level of a object or location in the
 	// world.
 	Elevation int64 `json:"elevation,omitempty"`
 }
 
+// Point returns the latitude and longitude of the position
+func (p Position) Point() geo.Point {
+	return geo.Point{Lat: p.Latitude, Lon: p.Longitude}
+}
+
 type Telemetry struct {
 	// Id is the unique object id of the service.  This Id usually represents a physical
 	// object in the world such as a vehicle or plane.  This Id should be unique.
@@ -57,9 +88,15 @@ type Telemetry struct {
 	Position Position `json:"position" validate:"required"`
 
 	// Updated stores the last time this object was updated.  This can be useful if you
-	// need to expire stale telemetry objects.
+	// need to expire stale telemetry objects.  The service stamps it with the time an
+	// update is received.
 	Updated time.Time `json:"updated"`
 
+	// RecordedAt is the time the device took the fix, as reported by the source, or
+	// the time the update was received when the source does not report one.  It
+	// orders the updates of an object.
+	RecordedAt time.Time `json:"recordedAt"`
+
 	// Source is the source of the telemetry point; Reporting sources are required to
 	// send their details.
 	Source string `json:"source" validate:"required"`
@@ -67,27 +104,174 @@ type Telemetry struct {
 	// ObjectID is a unique id of the telemetry object as it relates to the source.
 	ObjectID string `json:"objectId" validate:"required"`
 
-	// Status represents the current status of the object at the time of update
-	Status string `json:"status"`
+	// Status is the operating status of the object at the time of update; one of
+	// Statuses.  An update without a status keeps the status of the object.
+	Status Status `json:"status"`
+
+	// Speed is the ground speed of the object in meters per second
+	Speed *float64 `json:"speed,omitempty" validate:"omitempty,min=0"`
+
+	// Heading is the direction of travel in degrees clockwise from true north
+	Heading *float64 `json:"heading,omitempty" validate:"omitempty,min=0,lt=360"`
+
+	// Accuracy is the radius, in meters, the true position lies within
+	Accuracy *float64 `json:"accuracy,omitempty" validate:"omitempty,min=0"`
+
+	// HDOP is the horizontal dilution of precision of the fix
+	HDOP *float64 `json:"hdop,omitempty" validate:"omitempty,min=0"`
+
+	// Satellites is the number of satellites used for the fix
+	Satellites *int `json:"satellites,omitempty" validate:"omitempty,min=0"`
+
+	// Battery is the charge left in the tracker's battery as a percentage
+	Battery *float64 `json:"battery,omitempty" validate:"omitempty,min=0,max=100"`
+
+	// Ignition reports whether the vehicle's ignition is on
+	Ignition *bool `json:"ignition,omitempty"`
+
+	// Attributes are further readings of the tracker by name.  They are
+	// bounded by MaxAttributes, MaxAttributeKey, and MaxAttributeValue.
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	// Tenant is the customer fleet the object belongs to.  It is assigned by
+	// the service from the credentials of the reporting source.
+	Tenant string `json:"tenant,omitempty"`
+
+	// Odometer is the distance, in meters, the object has travelled since it
+	// was first seen.  It is kept by the service; a reported value is
+	// replaced.
+	Odometer float64 `json:"odometer"`
+
+	// OdometerAnchor is the position the odometer of the object last
+	// advanced at.  It is kept by the service alongside the odometer and
+	// is not part of the JSON of the telemetry, so it is neither shown nor
+	// taken from sources; datastores store it beside the telemetry.
+	OdometerAnchor *Position `json:"-"`
+
+	// PositionVariance is the uncertainty, in square meters, of a smoothed
+	// position.  It is kept by the service for the sources it smooths and
+	// is not part of the JSON of the telemetry; datastores store it beside
+	// the telemetry.
+	PositionVariance *float64 `json:"-"`
 }
 
-func (t *Telemetry) FromJSON(r *http.Request) error {
-	if err := json.NewDecoder(r.Body).Decode(t); err != nil {
-		return fmt.Errorf("%w: %s", DecodeError, err)
+// OlderThan reports whether t was recorded before stored, so must not
+// replace it.  Updates recorded at the same time are last writer wins, and an
+// update without a recorded time is never older.
+func (t Telemetry) OlderThan(stored Telemetry) bool {
+	return !t.RecordedAt.IsZero() && t.RecordedAt.Before(stored.RecordedAt)
+}
+
+// InTenant reports whether t belongs to one of tenants.  A nil list of
+// tenants matches every tenant.
+func (t Telemetry) InTenant(tenants []string) bool {
+	return inTenants(t.Tenant, tenants)
+}
+
+// inTenants reports whether tenant is one of tenants.  A nil list of tenants
+// matches every tenant.
+func inTenants(tenant string, tenants []string) bool {
+	if tenants == nil {
+		return true
+	}
+	for _, t := range tenants {
+		if t == tenant {
+			return true
+		}
 	}
+	return false
+}
+
+// Proximity is the telemetry of an object along with its distance, in meters,
+// from the point of a spatial query.
+type Proximity struct {
+	Telemetry
+	Distance float64 `json:"distance"`
+}
+
+// HistoryQuery selects a window of an object's stored track.  Zero values
+// leave that side of the window open.
+type HistoryQuery struct {
+	// From excludes positions updated before this time
+	From time.Time
+
+	// To excludes positions updated after this time
+	To time.Time
+
+	// Limit caps the track to the most recent positions in the window
+	Limit int
+}
+
+// Retention is how much of the track of each object a datastore keeps
+type Retention struct {
+	// Size is the most positions kept per object; no track is kept when 0
+	Size int
+
+	// Age is how long a position is kept
+	Age time.Duration
+}
+
+// Covers reports whether positions updated at ts are still kept at now
+func (r Retention) Covers(ts, now time.Time) bool {
+	return r.Size > 0 && !ts.Before(now.Add(-r.Age))
+}
+
+// Includes reports whether ts falls inside the window of the query
+func (q HistoryQuery) Includes(ts time.Time) bool {
+	if !q.From.IsZero() && ts.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && ts.After(q.To) {
+		return false
+	}
+	return true
+}
 
-	if err := t.Validate(); err != nil {
-		return fmt.Errorf("%w: %s", ValidationError, err)
+// Apply filters a track, ordered oldest to newest, down to the positions
+// selected by the query.  The result keeps the oldest to newest ordering.
+func (q HistoryQuery) Apply(track []Telemetry) []Telemetry {
+	results := make([]Telemetry, 0, len(track))
+	for _, t := range track {
+		if q.Includes(t.Updated) {
+			results = append(results, t)
+		}
 	}
 
-	return nil
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[len(results)-q.Limit:]
+	}
+	return results
 }
 
-func (t *Telemetry) Validate() error {
-	validate := validator.New()
-	return validate.Struct(t)
+// FromJSON decodes and validates the telemetry update in the body of r.
+// Validation failures are returned as FieldErrors.
+func (t *Telemetry) FromJSON(r *http.Request) error {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %s", DecodeError, err)
+	}
+
+	decoded, err := DecodeTelemetry(data)
+	*t = decoded
+	return err
 }
 
-func (t *Telemetry) IsExpired() bool {
-	return t.Updated.Before(time.Now().Add(-time.Second * 65))
+// Validate checks the fields of t, returning FieldErrors for those that are
+// invalid
+func (t *Telemetry) Validate() error {
+	var errs FieldErrors
+	if err := validate.Struct(t); err != nil {
+		invalid, ok := fieldErrors(err).(FieldErrors)
+		if !ok {
+			return err
+		}
+		errs = invalid
+	}
+	errs = append(errs, validateStatus(t.Status)...)
+	errs = append(errs, validateAttributes(t.Attributes)...)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+)
+
+const (
+	SortByID      = "id"
+	SortByUpdated = "updated"
+
+	// DefaultPageSize is the page size used when a query sets no limit
+	DefaultPageSize = 100
+
+	// MaxPageSize is the largest page a query may ask for
+	MaxPageSize = 1000
+)
+
+// Query selects, orders, and pages through the current telemetry of the
+// fleet.  Zero values leave that filter unset.
+type Query struct {
+	// Tenants limits results to objects of these tenants; nil matches every
+	// tenant
+	Tenants []string
+
+	// Area limits results to objects whose position is inside the shape
+	Area geo.Shape
+
+	// Source limits results to objects reported by this source
+	Source string
+
+	// Status limits results to objects with this status
+	Status Status
+
+	// UpdatedSince limits results to objects updated at or after this time
+	UpdatedSince time.Time
+
+	// Sort is the field results are ordered by; either id (default) or updated
+	Sort string
+
+	// Descending reverses the sort order
+	Descending bool
+
+	// Limit is the size of the page; DefaultPageSize when unset
+	Limit int
+
+	// Cursor continues a previous query from the end of its page
+	Cursor string
+}
+
+// Page is one page of query results along with the cursor of the next page
+type Page struct {
+	Items []Telemetry
+
+	// Next is the cursor of the next page; empty on the last page
+	Next string
+}
+
+type cursor struct {
+	Sort       string    `json:"s"`
+	Descending bool      `json:"d,omitempty"`
+	Updated    time.Time `json:"u,omitempty"`
+	Id         string    `json:"i"`
+}
+
+// Match reports whether t passes every filter of the query
+func (q Query) Match(t Telemetry) bool {
+	if !t.InTenant(q.Tenants) {
+		return false
+	}
+	if q.Area != nil && !q.Area.Contains(t.Position.Point()) {
+		return false
+	}
+	if q.Source != "" && q.Source != t.Source {
+		return false
+	}
+	if q.Status != "" && q.Status != t.Status {
+		return false
+	}
+	if !q.UpdatedSince.IsZero() && t.Updated.Before(q.UpdatedSince) {
+		return false
+	}
+	return true
+}
+
+// compare orders a and b by the sort field of the query, breaking ties by id
+func (q Query) compare(a, b Telemetry) int {
+	c := 0
+	if q.Sort == SortByUpdated {
+		switch {
+		case a.Updated.Before(b.Updated):
+			c = -1
+		case a.Updated.After(b.Updated):
+			c = 1
+		}
+	}
+	if c == 0 {
+		c = strings.Compare(a.Id, b.Id)
+	}
+	if q.Descending {
+		c = -c
+	}
+	return c
+}
+
+// Apply filters, sorts, and pages candidates.  Datastores narrow the
+// candidates with whatever indexes they have before applying the query.
+func (q Query) Apply(candidates []Telemetry) (Page, error) {
+	if q.Sort == "" {
+		q.Sort = SortByID
+	}
+	if q.Sort != SortByID && q.Sort != SortByUpdated {
+		return Page{}, fmt.Errorf("models: unknown sort field %q", q.Sort)
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	var after *Telemetry
+	if q.Cursor != "" {
+		c, err := q.decodeCursor()
+		if err != nil {
+			return Page{}, err
+		}
+		after = &Telemetry{Id: c.Id, Updated: c.Updated}
+	}
+
+	items := make([]Telemetry, 0, len(candidates))
+	for _, t := range candidates {
+		if !q.Match(t) {
+			continue
+		}
+		if after != nil && q.compare(*after, t) >= 0 {
+			continue
+		}
+		items = append(items, t)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return q.compare(items[i], items[j]) < 0
+	})
+
+	page := Page{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.Next = q.encodeCursor(page.Items[limit-1])
+	}
+	return page, nil
+}
+
+func (q Query) encodeCursor(last Telemetry) string {
+	c := cursor{Sort: q.Sort, Descending: q.Descending, Id: last.Id}
+	if q.Sort == SortByUpdated {
+		c.Updated = last.Updated
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor decodes the cursor of the query, rejecting cursors created
+// for a different sort order
+func (q Query) decodeCursor() (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	if c.Sort != q.Sort || c.Descending != q.Descending {
+		return c, fmt.Errorf("%w: cursor does not match the sort order", ErrInvalidCursor)
+	}
+	return c, nil
+}
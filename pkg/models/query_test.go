@@ -0,0 +1,127 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+)
+
+func fleet(size int) []Telemetry {
+	start := time.Date(2020, 12, 1, 10, 0, 0, 0, time.UTC)
+	var ts []Telemetry
+	for i := 0; i < size; i++ {
+		ts = append(ts, Telemetry{
+			Id:       fmt.Sprintf("obj-%03d", i),
+			Source:   fmt.Sprintf("gateway-%d", i%2),
+			Status:   []Status{StatusMoving, StatusIdle, StatusMoving}[i%3],
+			Tenant:   []string{"acme", "globex", "initech"}[i%3],
+			Updated:  start.Add(time.Duration(size-i) * time.Second),
+			Position: Position{Latitude: float64(i), Longitude: float64(i)},
+		})
+	}
+	return ts
+}
+
+func TestQueryFilters(t *testing.T) {
+	ts := fleet(30)
+
+	tests := []struct {
+		name string
+		q    Query
+		want int
+	}{
+		{name: "None", q: Query{}, want: 30},
+		{name: "Source", q: Query{Source: "gateway-1"}, want: 15},
+		{name: "Status", q: Query{Status: StatusMoving}, want: 20},
+		{name: "UpdatedSince", q: Query{UpdatedSince: ts[9].Updated}, want: 10},
+		{name: "Area", q: Query{Area: geo.BBox{MinLat: 0, MinLon: 0, MaxLat: 4.5, MaxLon: 4.5}}, want: 5},
+		{name: "Combined", q: Query{Source: "gateway-0", Status: StatusIdle}, want: 5},
+		{name: "Tenants", q: Query{Tenants: []string{"acme"}}, want: 10},
+		{name: "NoTenants", q: Query{Tenants: []string{}}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := tt.q.Apply(ts)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if len(page.Items) != tt.want {
+				t.Errorf("got %d items; want %d", len(page.Items), tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryPagination(t *testing.T) {
+	ts := fleet(25)
+
+	for _, q := range []Query{
+		{Limit: 10},
+		{Limit: 10, Descending: true},
+		{Limit: 10, Sort: SortByUpdated},
+		{Limit: 7, Sort: SortByUpdated, Descending: true},
+	} {
+		t.Run(fmt.Sprintf("%s-%t-%d", q.Sort, q.Descending, q.Limit), func(t *testing.T) {
+			var seen []Telemetry
+			pages := 0
+			for {
+				page, err := q.Apply(ts)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err.Error())
+				}
+				seen = append(seen, page.Items...)
+				pages++
+				if page.Next == "" {
+					break
+				}
+				q.Cursor = page.Next
+			}
+
+			if len(seen) != len(ts) {
+				t.Fatalf("expected every object once; got %d objects over %d pages", len(seen), pages)
+			}
+			for i := 1; i < len(seen); i++ {
+				if q.compare(seen[i-1], seen[i]) >= 0 {
+					t.Errorf("objects %d and %d are out of order", i-1, i)
+				}
+			}
+		})
+	}
+}
+
+func TestQuerySortByUpdated(t *testing.T) {
+	page, _ := Query{Sort: SortByUpdated, Descending: true, Limit: 1}.Apply(fleet(5))
+	if page.Items[0].Id != "obj-000" {
+		t.Errorf("expected the most recently updated object first; got %s", page.Items[0].Id)
+	}
+}
+
+func TestQueryInvalidCursor(t *testing.T) {
+	ts := fleet(5)
+
+	if _, err := (Query{Cursor: "not a cursor!"}).Apply(ts); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected an invalid cursor error; got %v", err)
+	}
+
+	page, _ := Query{Limit: 2}.Apply(ts)
+	if _, err := (Query{Sort: SortByUpdated, Cursor: page.Next}).Apply(ts); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected a cursor from another sort order to be rejected; got %v", err)
+	}
+}
+
+func TestQueryLimits(t *testing.T) {
+	ts := fleet(MaxPageSize + 10)
+
+	page, _ := Query{}.Apply(ts)
+	if len(page.Items) != DefaultPageSize {
+		t.Errorf("expected the default page size; got %d", len(page.Items))
+	}
+	page, _ = Query{Limit: MaxPageSize * 2}.Apply(ts)
+	if len(page.Items) != MaxPageSize {
+		t.Errorf("expected the page size to be capped; got %d", len(page.Items))
+	}
+}
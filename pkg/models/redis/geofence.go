@@ -0,0 +1,174 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+const (
+	// geofenceKey is the hash holding every geofence by id
+	geofenceKey = "geofences"
+
+	geofenceEventsPrefix = "geofence:events:"
+
+	// DefaultGeofenceEvents is the number of events kept per geofence
+	DefaultGeofenceEvents = 1000
+)
+
+func geofenceEventsKey(id string) string {
+	return geofenceEventsPrefix + id
+}
+
+// AddGeofence stores the geofence, replacing any geofence with the same id,
+// and returns its id.
+func (rdb *RedisDB) AddGeofence(f models.Geofence) (string, error) {
+	defer observe("AddGeofence", time.Now())
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "AddGeofence").Inc()
+		return "", err
+	}
+	if err := rdb.client.HSet(context.Background(), geofenceKey, f.Id, data).Err(); err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "AddGeofence").Inc()
+		return "", err
+	}
+	return f.Id, nil
+}
+
+// GetGeofence returns the geofence with the passed id.
+// If the geofence is not found then a NotFound error is returned.
+func (rdb *RedisDB) GetGeofence(id string) (*models.Geofence, error) {
+	defer observe("GetGeofence", time.Now())
+
+	data, err := rdb.client.HGet(context.Background(), geofenceKey, id).Bytes()
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "GetGeofence").Inc()
+		if errors.Is(err, goredis.Nil) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, err
+	}
+
+	var f models.Geofence
+	if err := json.Unmarshal(data, &f); err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "GetGeofence").Inc()
+		return nil, fmt.Errorf("%w: %s", models.DecodeError, err)
+	}
+	return &f, nil
+}
+
+// GetAllGeofences returns every known geofence ordered by id
+func (rdb *RedisDB) GetAllGeofences() []models.Geofence {
+	defer observe("GetAllGeofences", time.Now())
+
+	values, err := rdb.client.HGetAll(context.Background(), geofenceKey).Result()
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "GetAllGeofences").Inc()
+		rdb.log.Error().Err(err).Msg("unable to load geofences")
+		return nil
+	}
+
+	results := make([]models.Geofence, 0, len(values))
+	for id, v := range values {
+		var f models.Geofence
+		if err := json.Unmarshal([]byte(v), &f); err != nil {
+			rdb.log.Warn().Err(err).Str("geofence", id).Msg("unable to decode geofence")
+			continue
+		}
+		results = append(results, f)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Id < results[j].Id
+	})
+	return results
+}
+
+// DeleteGeofence removes the geofence and its events.
+// If the geofence is not found then a NotFound error is returned.
+func (rdb *RedisDB) DeleteGeofence(id string) error {
+	defer observe("DeleteGeofence", time.Now())
+
+	ctx := context.Background()
+	var deleted *goredis.IntCmd
+	_, err := rdb.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		deleted = pipe.HDel(ctx, geofenceKey, id)
+		pipe.Del(ctx, geofenceEventsKey(id))
+		return nil
+	})
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "DeleteGeofence").Inc()
+		return err
+	}
+	if deleted.Val() == 0 {
+		models.TransactionErrors.WithLabelValues(storeName, "DeleteGeofence").Inc()
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// AddGeofenceEvent appends an event to the feed of its geofence, dropping the
+// oldest events once the feed is full.
+func (rdb *RedisDB) AddGeofenceEvent(e models.GeofenceEvent) error {
+	defer observe("AddGeofenceEvent", time.Now())
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "AddGeofenceEvent").Inc()
+		return err
+	}
+
+	ctx := context.Background()
+	_, err = rdb.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.RPush(ctx, geofenceEventsKey(e.GeofenceId), data)
+		pipe.LTrim(ctx, geofenceEventsKey(e.GeofenceId), -DefaultGeofenceEvents, -1)
+		return nil
+	})
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "AddGeofenceEvent").Inc()
+		return err
+	}
+	return nil
+}
+
+// GeofenceEvents returns the event feed of the geofence, oldest event first.
+// If the geofence is not found then a NotFound error is returned.
+func (rdb *RedisDB) GeofenceEvents(id string, q models.HistoryQuery) ([]models.GeofenceEvent, error) {
+	defer observe("GeofenceEvents", time.Now())
+
+	ctx := context.Background()
+	exists, err := rdb.client.HExists(ctx, geofenceKey, id).Result()
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "GeofenceEvents").Inc()
+		return nil, err
+	}
+	if !exists {
+		models.TransactionErrors.WithLabelValues(storeName, "GeofenceEvents").Inc()
+		return nil, models.ErrNoRecord
+	}
+
+	values, err := rdb.client.LRange(ctx, geofenceEventsKey(id), 0, -1).Result()
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "GeofenceEvents").Inc()
+		return nil, err
+	}
+
+	events := make([]models.GeofenceEvent, 0, len(values))
+	for _, v := range values {
+		var e models.GeofenceEvent
+		if err := json.Unmarshal([]byte(v), &e); err != nil {
+			rdb.log.Warn().Err(err).Str("geofence", id).Msg("unable to decode geofence event")
+			continue
+		}
+		events = append(events, e)
+	}
+	return q.ApplyEvents(events), nil
+}
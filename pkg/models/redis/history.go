@@ -0,0 +1,44 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// History returns the stored track of the object with the passed id, oldest
+// position first.  If the object has no stored track a NotFound error is
+// returned.
+func (rdb *RedisDB) History(id string, q models.HistoryQuery) ([]models.Telemetry, error) {
+	defer observe("History", time.Now())
+
+	values, err := rdb.client.LRange(context.Background(), historyKey(id), 0, -1).Result()
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "History").Inc()
+		return nil, err
+	}
+	if len(values) == 0 {
+		models.TransactionErrors.WithLabelValues(storeName, "History").Inc()
+		return nil, models.ErrNoRecord
+	}
+
+	// the list key only expires once the newest position ages out, so older
+	// positions are filtered on read
+	cutoff := time.Now().Add(-rdb.historyAge)
+	track := make([]models.Telemetry, 0, len(values))
+	for _, v := range values {
+		t, err := decode([]byte(v))
+		if err != nil {
+			rdb.log.Warn().Err(err).Str("id", id).Msg("unable to decode history")
+			continue
+		}
+		if t.Updated.Before(cutoff) {
+			continue
+		}
+		t.Id = id
+		track = append(track, t)
+	}
+
+	return q.Apply(track), nil
+}
@@ -0,0 +1,431 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+const (
+	storeName     = "redis"
+	keyPrefix     = "telemetry:"
+	historyPrefix = "history:"
+
+	// DefaultHistorySize is the number of positions kept per object
+	DefaultHistorySize = 100
+
+	// DefaultHistoryAge is how long a position is kept in an object's history
+	DefaultHistoryAge = time.Hour
+
+	// DefaultStopAge is how long the stops of an object are kept after its
+	// last stop was saved
+	DefaultStopAge = 7 * 24 * time.Hour
+
+	// DefaultTripAge is how long the trips of an object are kept after its
+	// last trip was saved
+	DefaultTripAge = 7 * 24 * time.Hour
+
+	// scanCount is the hint passed to SCAN when walking the keyspace
+	scanCount = 500
+
+	// maxWatchRetries is how often a write is retried when an object it
+	// replaces changes before it commits
+	maxWatchRetries = 5
+)
+
+type RedisDB struct {
+	client goredis.UniversalClient
+	ttl    models.TTL
+	log    *zerolog.Logger
+
+	historySize int
+	historyAge  time.Duration
+	stopAge     time.Duration
+	tripAge     time.Duration
+}
+
+// Option configures optional behaviour of the redis datastore
+type Option func(*RedisDB)
+
+// WithHistory bounds the track kept for each object to at most size
+// positions no older than age.
+func WithHistory(size int, age time.Duration) Option {
+	return func(rdb *RedisDB) {
+		rdb.historySize = size
+		rdb.historyAge = age
+	}
+}
+
+// WithStopAge expires the stops of an object once no stop of it was saved
+// for age.
+func WithStopAge(age time.Duration) Option {
+	return func(rdb *RedisDB) {
+		rdb.stopAge = age
+	}
+}
+
+// WithTripAge expires the trips of an object once no trip of it was saved
+// for age.
+func WithTripAge(age time.Duration) Option {
+	return func(rdb *RedisDB) {
+		rdb.tripAge = age
+	}
+}
+
+// WithSourceTTL overrides the TTL of objects reported by the given sources
+func WithSourceTTL(sources map[string]time.Duration) Option {
+	return func(rdb *RedisDB) {
+		rdb.ttl.Sources = sources
+	}
+}
+
+// New returns a datastore backed by the redis server the client is connected to.
+// Telemetry keys are written with the passed ttl so redis expires stale objects
+// on its own.
+func New(client goredis.UniversalClient, ttl time.Duration, logger *zerolog.Logger, opts ...Option) *RedisDB {
+	rdb := &RedisDB{
+		client:      client,
+		ttl:         models.TTL{Default: ttl},
+		log:         logger,
+		historySize: DefaultHistorySize,
+		historyAge:  DefaultHistoryAge,
+		stopAge:     DefaultStopAge,
+		tripAge:     DefaultTripAge,
+	}
+	for _, opt := range opts {
+		opt(rdb)
+	}
+	return rdb
+}
+
+func key(id string) string {
+	return keyPrefix + id
+}
+
+func historyKey(id string) string {
+	return historyPrefix + id
+}
+
+func observe(op string, start time.Time) {
+	duration := time.Since(start)
+	models.TransactionDuration.WithLabelValues(storeName, op).Observe(duration.Seconds())
+}
+
+// record is the telemetry of an object as it is kept in redis.  State the
+// service keeps with the telemetry but leaves out of its JSON is stored
+// beside it.
+type record struct {
+	models.Telemetry
+	OdometerAnchor   *models.Position `json:"odometerAnchor,omitempty"`
+	PositionVariance *float64         `json:"positionVariance,omitempty"`
+}
+
+// encode returns the record of t
+func encode(t models.Telemetry) ([]byte, error) {
+	return json.Marshal(record{Telemetry: t, OdometerAnchor: t.OdometerAnchor, PositionVariance: t.PositionVariance})
+}
+
+// decode returns the telemetry held in a record
+func decode(data []byte) (models.Telemetry, error) {
+	var r record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return models.Telemetry{}, err
+	}
+	t := r.Telemetry
+	t.OdometerAnchor = r.OdometerAnchor
+	t.PositionVariance = r.PositionVariance
+	return t, nil
+}
+
+// Add a new telemetry struct to redis and return its id as a string.  Writing
+// an existing id replaces the stored telemetry and refreshes its TTL, unless
+// the stored telemetry was recorded after t.
+func (rdb *RedisDB) Add(t models.Telemetry) (string, error) {
+	defer observe("Add", time.Now())
+
+	result := rdb.store(context.Background(), "Add", []models.Telemetry{t})[0]
+	return result.Id, result.Err
+}
+
+// AddBatch writes every telemetry struct of the batch to redis in a single
+// transaction, returning the outcome of each in the order given.
+func (rdb *RedisDB) AddBatch(ts []models.Telemetry) []models.WriteResult {
+	defer observe("AddBatch", time.Now())
+
+	return rdb.store(context.Background(), "AddBatch", ts)
+}
+
+// store writes ts in a transaction that only commits if none of their
+// objects changed since their recorded times were compared, retrying when
+// they did.
+func (rdb *RedisDB) store(ctx context.Context, op string, ts []models.Telemetry) []models.WriteResult {
+	keys := make([]string, 0, len(ts))
+	seen := make(map[string]bool, len(ts))
+	for _, t := range ts {
+		if !seen[t.Id] {
+			seen[t.Id] = true
+			keys = append(keys, key(t.Id))
+		}
+	}
+
+	var results []models.WriteResult
+	var err error
+	for attempt := 0; attempt < maxWatchRetries; attempt++ {
+		err = rdb.client.Watch(ctx, func(tx *goredis.Tx) error {
+			var txErr error
+			results, txErr = rdb.storeTx(ctx, tx, keys, ts)
+			return txErr
+		}, keys...)
+		if !errors.Is(err, goredis.TxFailedErr) {
+			break
+		}
+	}
+
+	if results == nil {
+		results = make([]models.WriteResult, len(ts))
+	}
+	for i := range results {
+		if results[i].Err == nil && err != nil {
+			results[i] = models.WriteResult{Err: err}
+		}
+		switch {
+		case errors.Is(results[i].Err, models.ErrStaleUpdate):
+			models.StaleCount.WithLabelValues(storeName).Inc()
+		case results[i].Err != nil:
+			models.TransactionErrors.WithLabelValues(storeName, op).Inc()
+		}
+	}
+	return results
+}
+
+// storeTx compares ts with the recorded times stored under keys and queues
+// the writes of those that are not stale in a transaction on tx.
+func (rdb *RedisDB) storeTx(ctx context.Context, tx *goredis.Tx, keys []string, ts []models.Telemetry) ([]models.WriteResult, error) {
+	results := make([]models.WriteResult, len(ts))
+	values, err := tx.MGet(ctx, keys...).Result()
+	if err != nil {
+		return results, err
+	}
+
+	latest := make(map[string]models.Telemetry, len(keys))
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if stored, err := decode([]byte(s)); err == nil {
+			latest[strings.TrimPrefix(keys[i], keyPrefix)] = stored
+		}
+	}
+
+	cmds := make([][]goredis.Cmder, len(ts))
+	_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for i, t := range ts {
+			if stored, ok := latest[t.Id]; ok && t.OlderThan(stored) {
+				results[i].Err = models.ErrStaleUpdate
+				continue
+			}
+			data, err := encode(t)
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+			results[i].Id = t.Id
+			cmds[i] = rdb.write(ctx, pipe, t, data)
+			latest[t.Id] = t
+		}
+		return nil
+	})
+	if err != nil {
+		return results, err
+	}
+
+	for i := range results {
+		for _, cmd := range cmds[i] {
+			if cmd.Err() != nil {
+				results[i] = models.WriteResult{Err: cmd.Err()}
+				break
+			}
+		}
+	}
+	return results, nil
+}
+
+// write queues the commands storing t on pipe and returns them
+func (rdb *RedisDB) write(ctx context.Context, pipe goredis.Pipeliner, t models.Telemetry, data []byte) []goredis.Cmder {
+	cmds := []goredis.Cmder{pipe.Set(ctx, key(t.Id), data, rdb.ttl.For(t.Source))}
+	if p := t.Position.Point(); indexable(p) {
+		cmds = append(cmds, pipe.GeoAdd(ctx, geoKey, &goredis.GeoLocation{Name: t.Id, Longitude: p.Lon, Latitude: p.Lat}))
+	} else {
+		// positions redis cannot index would fail the whole transaction
+		cmds = append(cmds, pipe.ZRem(ctx, geoKey, t.Id))
+	}
+	if rdb.historySize > 0 {
+		cmds = append(cmds,
+			pipe.RPush(ctx, historyKey(t.Id), data),
+			pipe.LTrim(ctx, historyKey(t.Id), int64(-rdb.historySize), -1),
+			pipe.Expire(ctx, historyKey(t.Id), rdb.historyAge),
+		)
+	}
+	return cmds
+}
+
+// Get will return the telemetry of the object with the passed id.
+// If the object is not found then a NotFound error is returned.
+func (rdb *RedisDB) Get(id string) (*models.Telemetry, error) {
+	defer observe("Get", time.Now())
+
+	data, err := rdb.client.Get(context.Background(), key(id)).Bytes()
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "Get").Inc()
+		if errors.Is(err, goredis.Nil) {
+			return nil, models.ErrNoRecord
+		}
+		return nil, err
+	}
+
+	t, err := decode(data)
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "Get").Inc()
+		return nil, fmt.Errorf("%w: %s", models.DecodeError, err)
+	}
+	t.Id = id
+	return &t, nil
+}
+
+// GetAll will return all known telemtry objects
+func (rdb *RedisDB) GetAll() []models.Telemetry {
+	defer observe("GetAll", time.Now())
+
+	ctx := context.Background()
+	var results []models.Telemetry
+	var cursor uint64
+	for {
+		keys, next, err := rdb.client.Scan(ctx, cursor, keyPrefix+"*", scanCount).Result()
+		if err != nil {
+			models.TransactionErrors.WithLabelValues(storeName, "GetAll").Inc()
+			rdb.log.Error().Err(err).Msg("unable to scan telemetry keys")
+			return results
+		}
+
+		if len(keys) > 0 {
+			results = append(results, rdb.load(ctx, keys)...)
+		}
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	// redis owns the record lifecycle, so the gauge is refreshed on every full read
+	models.RecordCount.WithLabelValues(storeName).Set(float64(len(results)))
+	return results
+}
+
+// Find returns the page of telemetry objects selected by the query.  Area
+// queries are narrowed with the geo index before the remaining filters,
+// ordering, and paging are applied.
+func (rdb *RedisDB) Find(q models.Query) (models.Page, error) {
+	defer observe("Find", time.Now())
+
+	var candidates []models.Telemetry
+	if q.Area != nil {
+		var err error
+		if candidates, err = rdb.Within(q.Area); err != nil {
+			models.TransactionErrors.WithLabelValues(storeName, "Find").Inc()
+			return models.Page{}, err
+		}
+	} else {
+		candidates = rdb.GetAll()
+	}
+
+	page, err := q.Apply(candidates)
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "Find").Inc()
+	}
+	return page, err
+}
+
+// load fetches the telemetry stored under keys, skipping any that expired
+// or could not be decoded.
+func (rdb *RedisDB) load(ctx context.Context, keys []string) []models.Telemetry {
+	values, err := rdb.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "GetAll").Inc()
+		rdb.log.Error().Err(err).Msg("unable to load telemetry")
+		return nil
+	}
+
+	results := make([]models.Telemetry, 0, len(values))
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			// the key expired between SCAN and MGET
+			continue
+		}
+		t, err := decode([]byte(s))
+		if err != nil {
+			rdb.log.Warn().Err(err).Str("key", keys[i]).Msg("unable to decode telemetry")
+			continue
+		}
+		t.Id = strings.TrimPrefix(keys[i], keyPrefix)
+		results = append(results, t)
+	}
+	return results
+}
+
+// Alive returns the health status of the database
+// Redis is considered alive as long as it answers a PING.
+func (rdb *RedisDB) Alive() (map[string]string, error) {
+	if err := rdb.client.Ping(context.Background()).Err(); err != nil {
+		return map[string]string{
+			"health": "dead",
+		}, fmt.Errorf("%w: %s", models.AliveError, err)
+	}
+	return map[string]string{
+		"health": "alive",
+	}, nil
+}
+
+// Ready will return the health status of the database
+// An error will be returned if redis does not answer a PING
+func (rdb *RedisDB) Ready() (map[string]string, error) {
+	ctx := context.Background()
+	if err := rdb.client.Ping(ctx).Err(); err != nil {
+		return map[string]string{
+			"health":  "dead",
+			"ready":   "false",
+			"message": err.Error(),
+		}, fmt.Errorf("%w: %s", models.ReadyError, err)
+	}
+
+	size, err := rdb.client.DBSize(ctx).Result()
+	if err != nil {
+		return map[string]string{
+			"health":  "alive",
+			"ready":   "false",
+			"message": err.Error(),
+		}, fmt.Errorf("%w: %s", models.ReadyError, err)
+	}
+
+	return map[string]string{
+		"health":  "alive",
+		"ready":   "true",
+		"keys":    fmt.Sprintf("%d", size),
+		"message": fmt.Sprintf("up; %d keys", size),
+	}, nil
+}
+
+// Close closes the connection to redis.  Every write is acknowledged by redis
+// before it returns, so there is nothing to flush.
+func (rdb *RedisDB) Close(ctx context.Context) error {
+	return rdb.client.Close()
+}
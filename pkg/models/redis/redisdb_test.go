@@ -0,0 +1,622 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+func newTestDB(t *testing.T) (*RedisDB, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("could not start miniredis: %s", err.Error())
+	}
+	t.Cleanup(mr.Close)
+
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	client := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return New(client, time.Minute, &logger), mr
+}
+
+func TestAdd(t *testing.T) {
+	db, mr := newTestDB(t)
+	item := models.Telemetry{
+		Id: uuid.New().String(),
+		Position: models.Position{
+			Latitude:  0.00,
+			Longitude: 0.00,
+			Elevation: 0,
+		},
+	}
+	id, err := db.Add(item)
+	if err != nil {
+		t.Errorf("could not add data to db: %s", err.Error())
+	}
+	if id != item.Id {
+		t.Errorf("item id returned is not our id: %s / %s", id, item.Id)
+	}
+	if ttl := mr.TTL(key(id)); ttl != time.Minute {
+		t.Errorf("expected key ttl of %s; got %s", time.Minute, ttl)
+	}
+}
+
+func TestGet(t *testing.T) {
+	db, _ := newTestDB(t)
+	itemIn := models.Telemetry{
+		Id:       uuid.New().String(),
+		Source:   "testing",
+		ObjectID: "0001",
+		Position: models.Position{
+			Latitude:  45.5,
+			Longitude: -122.6,
+			Elevation: 10,
+		},
+	}
+	if _, err := db.Add(itemIn); err != nil {
+		t.Errorf("error adding to the database: %s", err.Error())
+	}
+
+	itemOut, err := db.Get(itemIn.Id)
+	if err != nil {
+		t.Fatalf("error getting item out of db: %s", err.Error())
+	}
+	if itemOut.Id != itemIn.Id || itemOut.Position != itemIn.Position || itemOut.Source != itemIn.Source {
+		t.Errorf("got %+v; expected %+v", itemOut, itemIn)
+	}
+}
+
+func TestServiceState(t *testing.T) {
+	db, _ := newTestDB(t)
+	db.historySize = 5
+
+	anchor, variance := models.Position{Latitude: 45, Longitude: -122}, 25.0
+	if _, err := db.Add(models.Telemetry{Id: "vehicle", Updated: time.Now(), OdometerAnchor: &anchor, PositionVariance: &variance}); err != nil {
+		t.Fatalf("error adding to the database: %s", err.Error())
+	}
+
+	if stored, _ := db.Get("vehicle"); stored == nil || stored.OdometerAnchor == nil || *stored.OdometerAnchor != anchor {
+		t.Errorf("expected the odometer anchor to be kept; got %+v", stored)
+	}
+	if stored, _ := db.Get("vehicle"); stored == nil || stored.PositionVariance == nil || *stored.PositionVariance != variance {
+		t.Errorf("expected the position variance to be kept; got %+v", stored)
+	}
+	if track, _ := db.History("vehicle", models.HistoryQuery{}); len(track) != 1 || track[0].OdometerAnchor == nil {
+		t.Errorf("expected the odometer anchor to be kept in the history; got %+v", track)
+	}
+}
+
+func TestGetNotFound(t *testing.T) {
+	db, _ := newTestDB(t)
+
+	result, err := db.Get("foobar")
+	if !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("Get returned an unexpected error: %v", err)
+	}
+	if result != nil {
+		t.Errorf("Get did not return a nil result: %v", result)
+	}
+}
+
+func TestGetExpired(t *testing.T) {
+	db, mr := newTestDB(t)
+	item := models.Telemetry{Id: uuid.New().String()}
+	if _, err := db.Add(item); err != nil {
+		t.Fatalf("error adding to the database: %s", err.Error())
+	}
+
+	mr.FastForward(2 * time.Minute)
+
+	if _, err := db.Get(item.Id); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected expired object to be gone; got %v", err)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	db, mr := newTestDB(t)
+	for i := 0; i < 3; i++ {
+		item := models.Telemetry{Id: uuid.New().String(), Position: models.Position{Latitude: 45, Longitude: -122}}
+		if _, err := db.Add(item); err != nil {
+			t.Fatalf("error adding to the database: %s", err.Error())
+		}
+	}
+	if count := db.Expire(); count != 0 {
+		t.Errorf("expected no live object to be expired; got %d", count)
+	}
+
+	mr.FastForward(2 * time.Minute)
+	db.Add(models.Telemetry{Id: "fresh", Position: models.Position{Latitude: 45, Longitude: -122}})
+	if count := db.Expire(); count != 3 {
+		t.Errorf("expected the 3 expired objects to be removed; got %d", count)
+	}
+	if members, _ := mr.ZMembers(geoKey); len(members) != 1 || members[0] != "fresh" {
+		t.Errorf("expected only the fresh object in the geo index; got %v", members)
+	}
+}
+
+func TestGetAllEmpty(t *testing.T) {
+	db, _ := newTestDB(t)
+
+	results := db.GetAll()
+	if len(results) != 0 {
+		t.Errorf("expected 0 records, got %d : %v", len(results), results)
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	db, mr := newTestDB(t)
+
+	for i := 0; i < 1000; i++ {
+		_, err := db.Add(models.Telemetry{Id: uuid.New().String()})
+		if err != nil {
+			t.Errorf("error adding record to db: %s", err.Error())
+		}
+	}
+	// keys that are not telemetry should be ignored
+	mr.Set("unrelated", "value")
+
+	results := db.GetAll()
+	if len(results) != 1000 {
+		t.Errorf("expected 1000 records, got %d", len(results))
+	}
+}
+
+func TestAliveCheck(t *testing.T) {
+	db, mr := newTestDB(t)
+
+	data, err := db.Alive()
+	if err != nil {
+		t.Errorf("alive health check returned an error: %s", err.Error())
+	}
+	if _, ok := data["health"]; !ok {
+		t.Errorf("alive health check missing health key")
+	}
+
+	mr.Close()
+	if _, err := db.Alive(); !errors.Is(err, models.AliveError) {
+		t.Errorf("expected an alive error when redis is down; got %v", err)
+	}
+}
+
+func TestReadyCheck(t *testing.T) {
+	db, mr := newTestDB(t)
+
+	data, err := db.Ready()
+	if err != nil {
+		t.Errorf("ready health check returned an error: %s", err.Error())
+	}
+	if data["ready"] != "true" {
+		t.Errorf("ready health check did not report ready: %v", data)
+	}
+
+	mr.Close()
+	data, err = db.Ready()
+	if !errors.Is(err, models.ReadyError) {
+		t.Errorf("expected a ready error when redis is down; got %v", err)
+	}
+	if data["ready"] != "false" {
+		t.Errorf("ready health check reported ready while redis is down: %v", data)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	db, mr := newTestDB(t)
+	db.historySize = 5
+
+	start := time.Now().Add(-time.Minute)
+	for i := 0; i < 10; i++ {
+		_, err := db.Add(models.Telemetry{
+			Id:       "vehicle",
+			Updated:  start.Add(time.Duration(i) * time.Second),
+			Position: models.Position{Latitude: float64(i)},
+		})
+		if err != nil {
+			t.Fatalf("error adding record to db: %s", err.Error())
+		}
+	}
+
+	track, err := db.History("vehicle", models.HistoryQuery{})
+	if err != nil {
+		t.Fatalf("error getting history: %s", err.Error())
+	}
+	if len(track) != 5 {
+		t.Fatalf("expected history capped at 5 positions; got %d", len(track))
+	}
+	for i, pos := range track {
+		if pos.Id != "vehicle" || pos.Position.Latitude != float64(5+i) {
+			t.Errorf("position %d out of order: %+v", i, pos)
+		}
+	}
+
+	track, _ = db.History("vehicle", models.HistoryQuery{Limit: 2})
+	if len(track) != 2 || track[1].Position.Latitude != 9 {
+		t.Errorf("expected the 2 most recent positions; got %v", track)
+	}
+
+	mr.FastForward(2 * time.Hour)
+	if _, err := db.History("vehicle", models.HistoryQuery{}); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected history to expire; got %v", err)
+	}
+}
+
+func TestNearby(t *testing.T) {
+	db, mr := newTestDB(t)
+
+	positions := map[string]models.Position{
+		"downtown":  {Latitude: 45.5152, Longitude: -122.6784},
+		"pearl":     {Latitude: 45.5290, Longitude: -122.6850},
+		"beaverton": {Latitude: 45.4871, Longitude: -122.8037},
+		"seattle":   {Latitude: 47.6062, Longitude: -122.3321},
+	}
+	for id, p := range positions {
+		if _, err := db.Add(models.Telemetry{Id: id, Position: p}); err != nil {
+			t.Fatalf("error adding record to db: %s", err.Error())
+		}
+	}
+	// positions beyond what redis can index are stored but not indexed
+	if _, err := db.Add(models.Telemetry{Id: "arctic", Position: models.Position{Latitude: 89}}); err != nil {
+		t.Fatalf("error adding polar record to db: %s", err.Error())
+	}
+
+	results, err := db.Nearby(geo.Point{Lat: 45.5152, Lon: -122.6784}, 5000)
+	if err != nil {
+		t.Fatalf("error querying nearby objects: %s", err.Error())
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 objects within 5km; got %d: %v", len(results), results)
+	}
+	if results[0].Id != "downtown" || results[1].Id != "pearl" {
+		t.Errorf("results not sorted by distance: %v", results)
+	}
+
+	mr.Del(key("pearl"))
+	results, _ = db.Nearby(geo.Point{Lat: 45.5152, Lon: -122.6784}, 5000)
+	if len(results) != 1 {
+		t.Errorf("expected expired object to be skipped; got %v", results)
+	}
+	if members, _ := mr.ZMembers(geoKey); len(members) != 3 {
+		t.Errorf("expected expired object to be pruned from the index; got %v", members)
+	}
+}
+
+func TestWithin(t *testing.T) {
+	db, _ := newTestDB(t)
+
+	db.Add(models.Telemetry{Id: "inside", Position: models.Position{Latitude: 45.52, Longitude: -122.68}})
+	db.Add(models.Telemetry{Id: "corner", Position: models.Position{Latitude: 45.599, Longitude: -122.601}})
+	db.Add(models.Telemetry{Id: "outside", Position: models.Position{Latitude: 45.40, Longitude: -122.68}})
+	db.Add(models.Telemetry{Id: "dateline", Position: models.Position{Latitude: 0, Longitude: -179.5}})
+
+	results, err := db.Within(geo.BBox{MinLat: 45.5, MinLon: -122.7, MaxLat: 45.6, MaxLon: -122.6})
+	if err != nil {
+		t.Fatalf("error querying area: %s", err.Error())
+	}
+	if len(results) != 2 || results[0].Id != "corner" || results[1].Id != "inside" {
+		t.Errorf("unexpected objects in bbox: %v", results)
+	}
+
+	results, _ = db.Within(geo.BBox{MinLat: -1, MinLon: 179, MaxLat: 1, MaxLon: -179})
+	if len(results) != 1 || results[0].Id != "dateline" {
+		t.Errorf("unexpected objects across the antimeridian: %v", results)
+	}
+
+	db.Add(models.Telemetry{Id: "east", Position: models.Position{Latitude: 0, Longitude: 179}})
+	db.Add(models.Telemetry{Id: "north", Position: models.Position{Latitude: 79.9, Longitude: 170}})
+	results, _ = db.Within(geo.BBox{MinLat: -80, MinLon: -180, MaxLat: 80, MaxLon: 180})
+	if len(results) != 6 {
+		t.Errorf("expected a box of the whole world to hold every object; got %v", results)
+	}
+	results, _ = db.Within(geo.BBox{MinLat: -80, MinLon: 100, MaxLat: 80, MaxLon: -130})
+	if len(results) != 3 || results[0].Id != "dateline" || results[1].Id != "east" || results[2].Id != "north" {
+		t.Errorf("unexpected objects in a wide box across the antimeridian: %v", results)
+	}
+}
+
+func TestGeofences(t *testing.T) {
+	db, _ := newTestDB(t)
+
+	poly := geo.Polygon{{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 1}, {Lat: 1, Lon: 1}, {Lat: 0, Lon: 0}}}
+	fence := models.Geofence{Id: "yard", Name: "Yard", Type: models.PolygonGeofence, Polygon: poly}
+	if _, err := db.AddGeofence(fence); err != nil {
+		t.Fatalf("could not add geofence: %s", err.Error())
+	}
+
+	found, err := db.GetGeofence("yard")
+	if err != nil {
+		t.Fatalf("could not get geofence: %s", err.Error())
+	}
+	if !found.Shape().Contains(geo.Point{Lat: 0.25, Lon: 0.5}) {
+		t.Errorf("polygon did not survive a round trip: %+v", found)
+	}
+	if all := db.GetAllGeofences(); len(all) != 1 {
+		t.Errorf("expected 1 geofence; got %v", all)
+	}
+
+	for i := 0; i < 3; i++ {
+		err := db.AddGeofenceEvent(models.GeofenceEvent{GeofenceId: "yard", ObjectId: "vehicle", Type: models.GeofenceEnter})
+		if err != nil {
+			t.Fatalf("could not add geofence event: %s", err.Error())
+		}
+	}
+	events, err := db.GeofenceEvents("yard", models.HistoryQuery{})
+	if err != nil || len(events) != 3 {
+		t.Errorf("expected 3 events; got %v (%v)", events, err)
+	}
+
+	if err := db.DeleteGeofence("yard"); err != nil {
+		t.Errorf("could not delete geofence: %s", err.Error())
+	}
+	if _, err := db.GeofenceEvents("yard", models.HistoryQuery{}); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected events of a deleted geofence to be gone; got %v", err)
+	}
+	if err := db.DeleteGeofence("yard"); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected deleting a missing geofence to fail; got %v", err)
+	}
+}
+
+func TestStatusEvents(t *testing.T) {
+	db, mr := newTestDB(t)
+
+	if _, err := db.StatusEvents("vehicle", models.HistoryQuery{}); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected an object without events to be not found; got %v", err)
+	}
+
+	now := time.Now()
+	db.AddStatusEvent(models.StatusEvent{ObjectId: "vehicle", To: models.StatusIdle, Time: now.Add(-2 * time.Hour)})
+	for i := 0; i < DefaultStatusEvents+5; i++ {
+		err := db.AddStatusEvent(models.StatusEvent{ObjectId: "vehicle", From: models.StatusIdle, To: models.StatusMoving, Time: now})
+		if err != nil {
+			t.Fatalf("could not add status event: %s", err.Error())
+		}
+	}
+
+	events, err := db.StatusEvents("vehicle", models.HistoryQuery{})
+	if err != nil || len(events) != DefaultStatusEvents {
+		t.Errorf("expected %d events; got %d (%v)", DefaultStatusEvents, len(events), err)
+	}
+	if events[0].To != models.StatusMoving {
+		t.Errorf("expected the oldest events to be dropped; got %+v", events[0])
+	}
+	if ttl := mr.TTL(statusEventsKey("vehicle")); ttl != DefaultHistoryAge {
+		t.Errorf("expected the feed to expire with the history; got %s", ttl)
+	}
+}
+
+func TestTrips(t *testing.T) {
+	db, mr := newTestDB(t)
+
+	if _, err := db.Trips("vehicle", models.HistoryQuery{}); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected an object without trips to be not found; got %v", err)
+	}
+
+	start := time.Date(2020, 12, 15, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < DefaultTrips+5; i++ {
+		at := start.Add(time.Duration(i) * time.Hour)
+		trip := models.Trip{Id: at.Format(time.RFC3339), ObjectId: "vehicle", Start: models.TripPoint{Time: at}, End: models.TripPoint{Time: at}, InProgress: true}
+		if err := db.SaveTrip(trip); err != nil {
+			t.Fatalf("could not save trip: %s", err.Error())
+		}
+		trip.End.Time = at.Add(30 * time.Minute)
+		trip.InProgress = false
+		db.SaveTrip(trip)
+	}
+
+	trips, err := db.Trips("vehicle", models.HistoryQuery{})
+	if err != nil || len(trips) != DefaultTrips {
+		t.Fatalf("expected %d trips; got %d (%v)", DefaultTrips, len(trips), err)
+	}
+	if !trips[0].Start.Time.Equal(start.Add(5*time.Hour)) || trips[0].InProgress {
+		t.Errorf("expected the oldest trips to be dropped and saved trips replaced; got %+v", trips[0])
+	}
+	if trips, _ := db.Trips("vehicle", models.HistoryQuery{Limit: 1}); len(trips) != 1 || !trips[0].Start.Time.Equal(start.Add(104*time.Hour)) {
+		t.Errorf("expected the latest trip; got %+v", trips)
+	}
+
+	mr.FastForward(DefaultTripAge + time.Minute)
+	if _, err := db.Trips("vehicle", models.HistoryQuery{}); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected trips to expire after the trip age; got %v", err)
+	}
+}
+
+func TestStops(t *testing.T) {
+	db, mr := newTestDB(t)
+
+	if _, err := db.Stops("vehicle", models.HistoryQuery{}); !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("expected an object without stops to be not found; got %v", err)
+	}
+
+	start := time.Date(2020, 12, 15, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < DefaultStops+5; i++ {
+		at := start.Add(time.Duration(i) * time.Hour)
+		stop := models.Stop{Id: at.Format(time.RFC3339), ObjectId: "vehicle", Position: models.Position{Latitude: 45, Longitude: -122}, Arrived: at, Departed: at, InProgress: true}
+		if err := db.SaveStop(stop); err != nil {
+			t.Fatalf("could not save stop: %s", err.Error())
+		}
+		stop.Departed = at.Add(30 * time.Minute)
+		stop.InProgress = false
+		db.SaveStop(stop)
+	}
+	db.SaveStop(models.Stop{Id: "1", ObjectId: "car", Position: models.Position{Latitude: 10, Longitude: 10}, Arrived: start})
+
+	stops, err := db.Stops("vehicle", models.HistoryQuery{})
+	if err != nil || len(stops) != DefaultStops {
+		t.Fatalf("expected %d stops; got %d (%v)", DefaultStops, len(stops), err)
+	}
+	if !stops[0].Arrived.Equal(start.Add(5*time.Hour)) || stops[0].InProgress {
+		t.Errorf("expected the oldest stops to be dropped and saved stops replaced; got %+v", stops[0])
+	}
+
+	if stops, _ := db.FindStops(nil, nil, models.HistoryQuery{}); len(stops) != DefaultStops+1 || stops[0].ObjectId != "car" {
+		t.Errorf("expected the stops of the fleet ordered by arrival; got %d", len(stops))
+	}
+	box := geo.BBox{MinLat: 0, MinLon: 0, MaxLat: 20, MaxLon: 20}
+	if stops, _ := db.FindStops(box, nil, models.HistoryQuery{}); len(stops) != 1 || stops[0].ObjectId != "car" {
+		t.Errorf("expected the stop in the box; got %+v", stops)
+	}
+	if members, _ := mr.ZMembers(stopsGeoKey); len(members) != DefaultStops+1 {
+		t.Errorf("expected the dropped stops to leave the geo index; got %d members", len(members))
+	}
+
+	mr.FastForward(DefaultStopAge)
+	if stops, _ := db.FindStops(nil, nil, models.HistoryQuery{}); len(stops) != 0 {
+		t.Errorf("expected the stops to expire; got %d", len(stops))
+	}
+	if stops, _ := db.FindStops(box, nil, models.HistoryQuery{}); len(stops) != 0 {
+		t.Errorf("expected the stops in the box to expire; got %d", len(stops))
+	}
+	if members, _ := mr.Members(stoppedKey); len(members) != 0 {
+		t.Errorf("expected the expired objects to leave the stopped set; got %v", members)
+	}
+	if members, _ := mr.ZMembers(stopsGeoKey); len(members) != DefaultStops {
+		t.Errorf("expected the expired stop in the box to leave the geo index; got %d members", len(members))
+	}
+}
+
+func TestAddBatch(t *testing.T) {
+	db, _ := newTestDB(t)
+
+	var batch []models.Telemetry
+	for i := 0; i < 10; i++ {
+		batch = append(batch, models.Telemetry{
+			Id:       uuid.New().String(),
+			Updated:  time.Now(),
+			Position: models.Position{Latitude: 45, Longitude: -122},
+		})
+	}
+
+	results := db.AddBatch(batch)
+	if len(results) != len(batch) {
+		t.Fatalf("expected %d results; got %d", len(batch), len(results))
+	}
+	for i, r := range results {
+		if r.Err != nil || r.Id != batch[i].Id {
+			t.Errorf("unexpected result %d: %+v", i, r)
+		}
+	}
+	if len(db.GetAll()) != 10 {
+		t.Errorf("expected 10 records; got %d", len(db.GetAll()))
+	}
+	if nearby, _ := db.Nearby(geo.Point{Lat: 45, Lon: -122}, 10); len(nearby) != 10 {
+		t.Errorf("expected batch to be indexed; got %d nearby", len(nearby))
+	}
+	if track, _ := db.History(batch[0].Id, models.HistoryQuery{}); len(track) != 1 {
+		t.Errorf("expected batch to be recorded in history; got %v", track)
+	}
+}
+
+func TestFind(t *testing.T) {
+	db, _ := newTestDB(t)
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		db.Add(models.Telemetry{
+			Id:       uuid.New().String(),
+			Source:   "gateway",
+			Updated:  start.Add(time.Duration(i) * time.Second),
+			Position: models.Position{Latitude: 45, Longitude: float64(-122 + i)},
+		})
+	}
+
+	var seen []models.Telemetry
+	q := models.Query{Sort: models.SortByUpdated, Descending: true, Limit: 4}
+	for {
+		page, err := db.Find(q)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		seen = append(seen, page.Items...)
+		if page.Next == "" {
+			break
+		}
+		q.Cursor = page.Next
+	}
+	if len(seen) != 10 {
+		t.Fatalf("expected 10 objects across all pages; got %d", len(seen))
+	}
+	for i := 1; i < len(seen); i++ {
+		if seen[i].Updated.After(seen[i-1].Updated) {
+			t.Errorf("objects %d and %d are out of order", i-1, i)
+		}
+	}
+
+	page, _ := db.Find(models.Query{Area: geo.BBox{MinLat: 44, MinLon: -122.5, MaxLat: 46, MaxLon: -119.5}})
+	if len(page.Items) != 3 {
+		t.Errorf("expected 3 objects in area; got %d", len(page.Items))
+	}
+}
+
+func TestSourceTTL(t *testing.T) {
+	db, mr := newTestDB(t)
+	WithSourceTTL(map[string]time.Duration{"slow": time.Hour})(db)
+
+	db.Add(models.Telemetry{Id: "fast", Source: "fast", Updated: time.Now()})
+	db.Add(models.Telemetry{Id: "slow", Source: "slow", Updated: time.Now()})
+
+	if ttl := mr.TTL(key("fast")); ttl != time.Minute {
+		t.Errorf("expected the default ttl of %s; got %s", time.Minute, ttl)
+	}
+	if ttl := mr.TTL(key("slow")); ttl != time.Hour {
+		t.Errorf("expected the source ttl of %s; got %s", time.Hour, ttl)
+	}
+
+	expired, _ := models.GetCounterValue(models.ExpiredCount, storeName)
+	mr.FastForward(2 * time.Minute)
+	if results, _ := db.Nearby(geo.Point{}, 1); len(results) != 1 || results[0].Id != "slow" {
+		t.Errorf("expected only the slow source to survive; got %v", results)
+	}
+	if got, _ := models.GetCounterValue(models.ExpiredCount, storeName); got != expired+1 {
+		t.Errorf("expected expired count of %f; got %f", expired+1, got)
+	}
+}
+
+func TestClose(t *testing.T) {
+	db, _ := newTestDB(t)
+
+	if err := db.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if _, err := db.Alive(); err == nil {
+		t.Errorf("expected a closed datastore to not be alive")
+	}
+}
+
+func TestStaleUpdate(t *testing.T) {
+	db, _ := newTestDB(t)
+	now := time.Now()
+	before, _ := models.GetCounterValue(models.StaleCount, storeName)
+
+	if _, err := db.Add(models.Telemetry{Id: "a", RecordedAt: now, Position: models.Position{Latitude: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Add(models.Telemetry{Id: "a", RecordedAt: now.Add(-time.Minute), Position: models.Position{Latitude: 2}}); !errors.Is(err, models.ErrStaleUpdate) {
+		t.Errorf("expected an older fix to be refused; got %v", err)
+	}
+
+	results := db.AddBatch([]models.Telemetry{
+		{Id: "a", RecordedAt: now.Add(time.Second), Position: models.Position{Latitude: 3}},
+		{Id: "b", Position: models.Position{Latitude: 5}},
+		{Id: "a", RecordedAt: now, Position: models.Position{Latitude: 4}},
+	})
+	if results[0].Err != nil || results[1].Err != nil || !errors.Is(results[2].Err, models.ErrStaleUpdate) {
+		t.Errorf("expected an update older than one earlier in the batch to be refused; got %+v", results)
+	}
+
+	stored, err := db.Get("a")
+	if err != nil || stored.Position.Latitude != 3 {
+		t.Errorf("expected the latest fix to be kept; got %+v (%v)", stored, err)
+	}
+	after, _ := models.GetCounterValue(models.StaleCount, storeName)
+	if after-before != 2 {
+		t.Errorf("expected 2 stale updates to be counted; got %f", after-before)
+	}
+}
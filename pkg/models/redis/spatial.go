@@ -0,0 +1,238 @@
+package redis
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// geoKey is the sorted set indexing the last known position of every object
+const geoKey = "geo:telemetry"
+
+// maxGeoLatitude is the highest latitude redis is able to index
+const maxGeoLatitude = 85.05112878
+
+func indexable(p geo.Point) bool {
+	return math.Abs(p.Lat) <= maxGeoLatitude && math.Abs(p.Lon) <= 180
+}
+
+// Nearby returns every object within radius meters of center, closest first.
+func (rdb *RedisDB) Nearby(center geo.Point, radius float64) ([]models.Proximity, error) {
+	defer observe("Nearby", time.Now())
+
+	found, err := rdb.radius(context.Background(), center, radius)
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "Nearby").Inc()
+		return nil, err
+	}
+
+	results := make([]models.Proximity, 0, len(found))
+	for _, t := range found {
+		if d := geo.Distance(center, t.Position.Point()); d <= radius {
+			results = append(results, models.Proximity{Telemetry: t, Distance: d})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Distance < results[j].Distance
+	})
+	return results, nil
+}
+
+// Within returns every object whose last known position is inside area.
+func (rdb *RedisDB) Within(area geo.Shape) ([]models.Telemetry, error) {
+	defer observe("Within", time.Now())
+
+	ctx := context.Background()
+	names, err := rdb.locate(ctx, geoKey, area)
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "Within").Inc()
+		return nil, err
+	}
+
+	results := []models.Telemetry{}
+	if len(names) == 0 {
+		return results, nil
+	}
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = key(name)
+	}
+	found := rdb.load(ctx, keys)
+	rdb.pruneIndex(ctx, names, found)
+	for _, t := range found {
+		if area.Contains(t.Position.Point()) {
+			results = append(results, t)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Id < results[j].Id
+	})
+	return results, nil
+}
+
+// locate returns the members of the geo index stored under index that may
+// be inside area.  Each bounding box of the area is split into tiles, and
+// each tile is searched with the smallest radius query that covers it, so
+// the members still have to be matched against the area itself.
+func (rdb *RedisDB) locate(ctx context.Context, index string, area geo.Shape) ([]string, error) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, box := range area.Bounds() {
+		for _, tile := range tiles(box) {
+			center, radius := coveringCircle(tile)
+			locations, err := rdb.client.GeoRadius(ctx, index, center.Lon, center.Lat, &goredis.GeoRadiusQuery{
+				Radius: radius,
+				Unit:   "m",
+			}).Result()
+			if err != nil {
+				return nil, err
+			}
+			for _, l := range locations {
+				if !seen[l.Name] {
+					seen[l.Name] = true
+					names = append(names, l.Name)
+				}
+			}
+		}
+	}
+	return names, nil
+}
+
+// maxTileDegrees is the widest and tallest tile a box is searched in.  The
+// farthest point of a tile from its center is one of its corners only while
+// the tile is less than 180 degrees wide.
+const maxTileDegrees = 90
+
+// tiles splits box, which must not cross the antimeridian, into tiles of at
+// most maxTileDegrees a side
+func tiles(box geo.BBox) []geo.BBox {
+	rows := int(math.Max(1, math.Ceil((box.MaxLat-box.MinLat)/maxTileDegrees)))
+	cols := int(math.Max(1, math.Ceil((box.MaxLon-box.MinLon)/maxTileDegrees)))
+	height := (box.MaxLat - box.MinLat) / float64(rows)
+	width := (box.MaxLon - box.MinLon) / float64(cols)
+
+	results := make([]geo.BBox, 0, rows*cols)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			results = append(results, geo.BBox{
+				MinLat: box.MinLat + float64(i)*height,
+				MinLon: box.MinLon + float64(j)*width,
+				MaxLat: box.MinLat + float64(i+1)*height,
+				MaxLon: box.MinLon + float64(j+1)*width,
+			})
+		}
+	}
+	return results
+}
+
+// coveringCircle returns the center of tile and the radius, in meters, of a
+// circle around it that covers the tile.  Redis measures distances on a
+// slightly larger sphere than geo.Distance, so the radius is padded.
+func coveringCircle(tile geo.BBox) (geo.Point, float64) {
+	center := geo.Point{Lat: (tile.MinLat + tile.MaxLat) / 2, Lon: (tile.MinLon + tile.MaxLon) / 2}
+	radius := 0.0
+	for _, corner := range []geo.Point{
+		{Lat: tile.MinLat, Lon: tile.MinLon}, {Lat: tile.MinLat, Lon: tile.MaxLon},
+		{Lat: tile.MaxLat, Lon: tile.MinLon}, {Lat: tile.MaxLat, Lon: tile.MaxLon},
+	} {
+		radius = math.Max(radius, geo.Distance(center, corner))
+	}
+	return center, radius*1.001 + 1
+}
+
+// radius loads the telemetry of every object indexed within radius meters of
+// center.  Members of the geo index whose telemetry has expired are removed
+// as they are found.
+func (rdb *RedisDB) radius(ctx context.Context, center geo.Point, radius float64) ([]models.Telemetry, error) {
+	locations, err := rdb.client.GeoRadius(ctx, geoKey, center.Lon, center.Lat, &goredis.GeoRadiusQuery{
+		Radius: radius,
+		Unit:   "m",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(locations) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, len(locations))
+	keys := make([]string, len(locations))
+	for i, l := range locations {
+		names[i] = l.Name
+		keys[i] = key(l.Name)
+	}
+	found := rdb.load(ctx, keys)
+	rdb.pruneIndex(ctx, names, found)
+	return found, nil
+}
+
+// Expire removes the objects whose telemetry redis has expired from the geo
+// index and returns how many were removed.  Spatial queries prune the index
+// as they go, but objects outside every queried area are only removed here.
+func (rdb *RedisDB) Expire() int {
+	defer observe("Expire", time.Now())
+
+	ctx := context.Background()
+	count := 0
+	var cursor uint64
+	for {
+		// members and their scores are interleaved
+		values, next, err := rdb.client.ZScan(ctx, geoKey, cursor, "", scanCount).Result()
+		if err != nil {
+			models.TransactionErrors.WithLabelValues(storeName, "Expire").Inc()
+			rdb.log.Error().Err(err).Msg("unable to scan geo index")
+			break
+		}
+
+		names := make([]string, 0, len(values)/2)
+		keys := make([]string, 0, len(values)/2)
+		for i := 0; i < len(values); i += 2 {
+			names = append(names, values[i])
+			keys = append(keys, key(values[i]))
+		}
+		if len(names) > 0 {
+			count += rdb.pruneIndex(ctx, names, rdb.load(ctx, keys))
+		}
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	rdb.log.Info().Int("objects", count).Msg("stale objects expired")
+	return count
+}
+
+// pruneIndex removes members of the geo index that no longer have telemetry
+// and returns how many were removed.  Redis expires telemetry keys on its
+// own, so this is where expirations are counted.
+func (rdb *RedisDB) pruneIndex(ctx context.Context, names []string, found []models.Telemetry) int {
+	if len(found) == len(names) {
+		return 0
+	}
+
+	alive := make(map[string]bool, len(found))
+	for _, t := range found {
+		alive[t.Id] = true
+	}
+	var stale []interface{}
+	for _, name := range names {
+		if !alive[name] {
+			stale = append(stale, name)
+		}
+	}
+	removed, err := rdb.client.ZRem(ctx, geoKey, stale...).Result()
+	if err != nil {
+		rdb.log.Warn().Err(err).Msg("unable to prune geo index")
+		return 0
+	}
+	models.ExpiredCount.WithLabelValues(storeName).Add(float64(removed))
+	return int(removed)
+}
@@ -0,0 +1,81 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+const (
+	statusEventsPrefix = "status:events:"
+
+	// DefaultStatusEvents is the number of status events kept per object
+	DefaultStatusEvents = 100
+)
+
+func statusEventsKey(id string) string {
+	return statusEventsPrefix + id
+}
+
+// AddStatusEvent appends an event to the status feed of its object, dropping
+// the oldest events once the feed is full.  The feed expires with the
+// history of the object.
+func (rdb *RedisDB) AddStatusEvent(e models.StatusEvent) error {
+	defer observe("AddStatusEvent", time.Now())
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "AddStatusEvent").Inc()
+		return err
+	}
+
+	ctx := context.Background()
+	_, err = rdb.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.RPush(ctx, statusEventsKey(e.ObjectId), data)
+		pipe.LTrim(ctx, statusEventsKey(e.ObjectId), -DefaultStatusEvents, -1)
+		pipe.Expire(ctx, statusEventsKey(e.ObjectId), rdb.historyAge)
+		return nil
+	})
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "AddStatusEvent").Inc()
+		return err
+	}
+	return nil
+}
+
+// StatusEvents returns the status feed of the object with the passed id,
+// oldest event first.  If the object has no status events a NotFound error
+// is returned.
+func (rdb *RedisDB) StatusEvents(id string, q models.HistoryQuery) ([]models.StatusEvent, error) {
+	defer observe("StatusEvents", time.Now())
+
+	values, err := rdb.client.LRange(context.Background(), statusEventsKey(id), 0, -1).Result()
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "StatusEvents").Inc()
+		return nil, err
+	}
+	if len(values) == 0 {
+		models.TransactionErrors.WithLabelValues(storeName, "StatusEvents").Inc()
+		return nil, models.ErrNoRecord
+	}
+
+	// the list key only expires once the newest event ages out, so older
+	// events are filtered on read
+	cutoff := time.Now().Add(-rdb.historyAge)
+	events := make([]models.StatusEvent, 0, len(values))
+	for _, v := range values {
+		var e models.StatusEvent
+		if err := json.Unmarshal([]byte(v), &e); err != nil {
+			rdb.log.Warn().Err(err).Str("id", id).Msg("unable to decode status event")
+			continue
+		}
+		if e.Time.Before(cutoff) {
+			continue
+		}
+		events = append(events, e)
+	}
+	return q.ApplyStatusEvents(events), nil
+}
@@ -0,0 +1,261 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+const (
+	stopsPrefix = "stops:"
+
+	// stoppedKey is the set of objects with stored stops
+	stoppedKey = "stops"
+
+	// stopsGeoKey is the sorted set indexing the position of every stored
+	// stop by its stopMember
+	stopsGeoKey = "geo:stops"
+
+	// DefaultStops is the number of stops kept per object
+	DefaultStops = 100
+)
+
+func stopsKey(id string) string {
+	return stopsPrefix + id
+}
+
+// stopMember names the stop with the passed id of an object in the geo index.
+// Stop ids never contain a slash, so the object id is everything before the
+// last one.
+func stopMember(objectId, id string) string {
+	return objectId + "/" + id
+}
+
+func splitStopMember(member string) (objectId, id string) {
+	i := strings.LastIndex(member, "/")
+	if i < 0 {
+		return "", member
+	}
+	return member[:i], member[i+1:]
+}
+
+// SaveStop stores the stop, replacing the stop of its object with the same
+// id, and drops the oldest stops of the object once it has too many.  The
+// stops of an object expire once none of them was saved for the stop age.
+func (rdb *RedisDB) SaveStop(s models.Stop) error {
+	defer observe("SaveStop", time.Now())
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "SaveStop").Inc()
+		return err
+	}
+
+	ctx := context.Background()
+	member := stopMember(s.ObjectId, s.Id)
+	pipe := rdb.client.TxPipeline()
+	pipe.HSet(ctx, stopsKey(s.ObjectId), s.Id, data)
+	pipe.Expire(ctx, stopsKey(s.ObjectId), rdb.stopAge)
+	pipe.SAdd(ctx, stoppedKey, s.ObjectId)
+	if p := s.Position.Point(); indexable(p) {
+		pipe.GeoAdd(ctx, stopsGeoKey, &goredis.GeoLocation{Name: member, Longitude: p.Lon, Latitude: p.Lat})
+	} else {
+		pipe.ZRem(ctx, stopsGeoKey, member)
+	}
+	count := pipe.HLen(ctx, stopsKey(s.ObjectId))
+	if _, err := pipe.Exec(ctx); err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "SaveStop").Inc()
+		return err
+	}
+
+	if count.Val() <= DefaultStops {
+		return nil
+	}
+	stops, err := rdb.stops(ctx, s.ObjectId)
+	if err != nil {
+		return nil
+	}
+	var drop []string
+	var members []interface{}
+	for _, old := range stops[:len(stops)-DefaultStops] {
+		drop = append(drop, old.Id)
+		members = append(members, stopMember(s.ObjectId, old.Id))
+	}
+	pipe = rdb.client.TxPipeline()
+	pipe.HDel(ctx, stopsKey(s.ObjectId), drop...)
+	pipe.ZRem(ctx, stopsGeoKey, members...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		rdb.log.Warn().Err(err).Str("id", s.ObjectId).Msg("unable to drop old stops")
+	}
+	return nil
+}
+
+// Stops returns the stops of the object with the passed id, oldest stop
+// first.  If the object has no stops a NotFound error is returned.
+func (rdb *RedisDB) Stops(id string, q models.HistoryQuery) ([]models.Stop, error) {
+	defer observe("Stops", time.Now())
+
+	stops, err := rdb.stops(context.Background(), id)
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "Stops").Inc()
+		return nil, err
+	}
+	if len(stops) == 0 {
+		models.TransactionErrors.WithLabelValues(storeName, "Stops").Inc()
+		return nil, models.ErrNoRecord
+	}
+	return q.ApplyStops(stops), nil
+}
+
+// FindStops returns the stops inside area, or anywhere when area is nil, of
+// the objects of tenants, or of every tenant when tenants is nil, ordered by
+// arrival.  Stops in an area are looked up in the geo index of stops, and
+// the stops of the whole fleet are read in a single pipeline.
+func (rdb *RedisDB) FindStops(area geo.Shape, tenants []string, q models.HistoryQuery) ([]models.Stop, error) {
+	defer observe("FindStops", time.Now())
+
+	ctx := context.Background()
+	var stops []models.Stop
+	var err error
+	if area == nil {
+		stops, err = rdb.allStops(ctx)
+	} else {
+		stops, err = rdb.stopsWithin(ctx, area)
+	}
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "FindStops").Inc()
+		return nil, err
+	}
+
+	results := make([]models.Stop, 0, len(stops))
+	for _, s := range stops {
+		if s.InTenant(tenants) && (area == nil || area.Contains(s.Position.Point())) {
+			results = append(results, s)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Arrived.Before(results[j].Arrived)
+	})
+	return q.ApplyStops(results), nil
+}
+
+// allStops returns every stored stop.  Objects whose stops expired are
+// removed from the set of stopped objects as they are found.
+func (rdb *RedisDB) allStops(ctx context.Context) ([]models.Stop, error) {
+	ids, err := rdb.client.SMembers(ctx, stoppedKey).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	cmds := make([]*goredis.StringSliceCmd, len(ids))
+	if _, err := rdb.client.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HVals(ctx, stopsKey(id))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	var results []models.Stop
+	var expired []interface{}
+	for i, cmd := range cmds {
+		if len(cmd.Val()) == 0 {
+			expired = append(expired, ids[i])
+			continue
+		}
+		results = append(results, rdb.decodeStops(ids[i], cmd.Val())...)
+	}
+	if len(expired) > 0 {
+		if err := rdb.client.SRem(ctx, stoppedKey, expired...).Err(); err != nil {
+			rdb.log.Warn().Err(err).Msg("unable to prune stopped objects")
+		}
+	}
+	return results, nil
+}
+
+// stopsWithin returns the stops indexed in the bounds of area.  Members of
+// the geo index whose stop was dropped or expired are removed as they are
+// found.
+func (rdb *RedisDB) stopsWithin(ctx context.Context, area geo.Shape) ([]models.Stop, error) {
+	members, err := rdb.locate(ctx, stopsGeoKey, area)
+	if err != nil || len(members) == 0 {
+		return nil, err
+	}
+
+	var objects []string
+	ids := make(map[string][]string)
+	for _, member := range members {
+		objectId, id := splitStopMember(member)
+		if _, ok := ids[objectId]; !ok {
+			objects = append(objects, objectId)
+		}
+		ids[objectId] = append(ids[objectId], id)
+	}
+
+	cmds := make([]*goredis.SliceCmd, len(objects))
+	if _, err := rdb.client.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for i, objectId := range objects {
+			cmds[i] = pipe.HMGet(ctx, stopsKey(objectId), ids[objectId]...)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	var results []models.Stop
+	var stale []interface{}
+	for i, cmd := range cmds {
+		var values []string
+		for j, v := range cmd.Val() {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			} else {
+				stale = append(stale, stopMember(objects[i], ids[objects[i]][j]))
+			}
+		}
+		results = append(results, rdb.decodeStops(objects[i], values)...)
+	}
+	if len(stale) > 0 {
+		if err := rdb.client.ZRem(ctx, stopsGeoKey, stale...).Err(); err != nil {
+			rdb.log.Warn().Err(err).Msg("unable to prune stop index")
+		}
+	}
+	return results, nil
+}
+
+// stops returns every stored stop of the object ordered by arrival
+func (rdb *RedisDB) stops(ctx context.Context, id string) ([]models.Stop, error) {
+	values, err := rdb.client.HVals(ctx, stopsKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	stops := rdb.decodeStops(id, values)
+	sort.Slice(stops, func(i, j int) bool {
+		return stops[i].Arrived.Before(stops[j].Arrived)
+	})
+	return stops, nil
+}
+
+// decodeStops decodes the stored stops of the object with the passed id,
+// skipping any that cannot be decoded
+func (rdb *RedisDB) decodeStops(id string, values []string) []models.Stop {
+	stops := make([]models.Stop, 0, len(values))
+	for _, v := range values {
+		var s models.Stop
+		if err := json.Unmarshal([]byte(v), &s); err != nil {
+			rdb.log.Warn().Err(err).Str("id", id).Msg("unable to decode stop")
+			continue
+		}
+		stops = append(stops, s)
+	}
+	return stops
+}
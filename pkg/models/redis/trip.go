@@ -0,0 +1,99 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+const (
+	tripsPrefix = "trips:"
+
+	// DefaultTrips is the number of trips kept per object
+	DefaultTrips = 100
+)
+
+func tripsKey(id string) string {
+	return tripsPrefix + id
+}
+
+// SaveTrip stores the trip, replacing the trip of its object with the same
+// id, and drops the oldest trips of the object once it has too many.  The
+// trips of an object expire once none of them was saved for the trip age.
+func (rdb *RedisDB) SaveTrip(t models.Trip) error {
+	defer observe("SaveTrip", time.Now())
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "SaveTrip").Inc()
+		return err
+	}
+
+	ctx := context.Background()
+	pipe := rdb.client.TxPipeline()
+	pipe.HSet(ctx, tripsKey(t.ObjectId), t.Id, data)
+	pipe.Expire(ctx, tripsKey(t.ObjectId), rdb.tripAge)
+	count := pipe.HLen(ctx, tripsKey(t.ObjectId))
+	if _, err := pipe.Exec(ctx); err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "SaveTrip").Inc()
+		return err
+	}
+
+	if count.Val() <= DefaultTrips {
+		return nil
+	}
+	trips, err := rdb.trips(ctx, t.ObjectId)
+	if err != nil {
+		return nil
+	}
+	var drop []string
+	for _, old := range trips[:len(trips)-DefaultTrips] {
+		drop = append(drop, old.Id)
+	}
+	if err := rdb.client.HDel(ctx, tripsKey(t.ObjectId), drop...).Err(); err != nil {
+		rdb.log.Warn().Err(err).Str("id", t.ObjectId).Msg("unable to drop old trips")
+	}
+	return nil
+}
+
+// Trips returns the trips of the object with the passed id, oldest trip
+// first.  If the object has no trips a NotFound error is returned.
+func (rdb *RedisDB) Trips(id string, q models.HistoryQuery) ([]models.Trip, error) {
+	defer observe("Trips", time.Now())
+
+	trips, err := rdb.trips(context.Background(), id)
+	if err != nil {
+		models.TransactionErrors.WithLabelValues(storeName, "Trips").Inc()
+		return nil, err
+	}
+	if len(trips) == 0 {
+		models.TransactionErrors.WithLabelValues(storeName, "Trips").Inc()
+		return nil, models.ErrNoRecord
+	}
+	return q.ApplyTrips(trips), nil
+}
+
+// trips returns every stored trip of the object ordered by start time
+func (rdb *RedisDB) trips(ctx context.Context, id string) ([]models.Trip, error) {
+	values, err := rdb.client.HVals(ctx, tripsKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	trips := make([]models.Trip, 0, len(values))
+	for _, v := range values {
+		var t models.Trip
+		if err := json.Unmarshal([]byte(v), &t); err != nil {
+			rdb.log.Warn().Err(err).Str("id", id).Msg("unable to decode trip")
+			continue
+		}
+		trips = append(trips, t)
+	}
+	sort.Slice(trips, func(i, j int) bool {
+		return trips[i].Start.Time.Before(trips[j].Start.Time)
+	})
+	return trips, nil
+}
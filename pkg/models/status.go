@@ -0,0 +1,208 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Status is the operating state of an object.  Statuses are lowercase; any
+// other casing is normalised when a status is decoded or parsed.
+type Status string
+
+const (
+	StatusIdle        Status = "idle"
+	StatusMoving      Status = "moving"
+	StatusStopped     Status = "stopped"
+	StatusOffline     Status = "offline"
+	StatusMaintenance Status = "maintenance"
+)
+
+// Statuses lists every known status
+var Statuses = []Status{StatusIdle, StatusMoving, StatusStopped, StatusOffline, StatusMaintenance}
+
+// ParseStatus returns the known status named by v, ignoring case and
+// surrounding space
+func ParseStatus(v string) (Status, error) {
+	s := Status(strings.ToLower(strings.TrimSpace(v)))
+	if !s.Valid() {
+		return "", fmt.Errorf("unknown status %q; must be one of %s", v, statusList())
+	}
+	return s, nil
+}
+
+// Valid reports whether s is a known status
+func (s Status) Valid() bool {
+	for _, known := range Statuses {
+		if s == known {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalJSON decodes a status, normalising its case.  Unknown statuses
+// are decoded as is and rejected by validation.
+func (s *Status) UnmarshalJSON(data []byte) error {
+	var v string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*s = Status(strings.ToLower(strings.TrimSpace(v)))
+	return nil
+}
+
+func statusList() string {
+	names := make([]string, len(Statuses))
+	for i, s := range Statuses {
+		names[i] = string(s)
+	}
+	return strings.Join(names, ", ")
+}
+
+// validateStatus returns the field errors of an unknown status.  An empty
+// status is allowed; the object keeps the status it had.
+func validateStatus(s Status) FieldErrors {
+	if s == "" || s.Valid() {
+		return nil
+	}
+	return FieldErrors{{Field: "status", Message: "must be one of " + statusList()}}
+}
+
+// Transitions lists the statuses an object may change to from each status.
+// A status without an entry may change to any status, and a nil Transitions
+// allows every change.  Keeping the same status is always allowed.
+type Transitions map[Status][]Status
+
+// DefaultTransitions returns the transitions allowed unless configured
+// otherwise.  Objects in maintenance must be released to idle, or go
+// offline, before they move again.
+func DefaultTransitions() Transitions {
+	return Transitions{
+		StatusIdle:        {StatusMoving, StatusStopped, StatusOffline, StatusMaintenance},
+		StatusMoving:      {StatusIdle, StatusStopped, StatusOffline},
+		StatusStopped:     {StatusIdle, StatusMoving, StatusOffline, StatusMaintenance},
+		StatusOffline:     {StatusIdle, StatusMoving, StatusStopped, StatusMaintenance},
+		StatusMaintenance: {StatusIdle, StatusOffline},
+	}
+}
+
+// Allows reports whether an object may change from one status to another.
+// An object without a status may take any status.
+func (tr Transitions) Allows(from, to Status) bool {
+	if from == "" || to == "" || from == to {
+		return true
+	}
+	allowed, ok := tr[from]
+	if !ok {
+		return true
+	}
+	for _, s := range allowed {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns the field errors of t when its status may not follow the
+// status of the previous telemetry of the object, or nil when it may
+func (tr Transitions) Check(previous *Telemetry, t Telemetry) error {
+	if previous == nil || tr.Allows(previous.Status, t.Status) {
+		return nil
+	}
+	ImplausibleCount.WithLabelValues("transition").Inc()
+	return FieldErrors{{
+		Field:   "status",
+		Message: fmt.Sprintf("can not change from %s to %s", previous.Status, t.Status),
+	}}
+}
+
+// ParseTransitions parses a comma separated list of status=status|status
+// pairs, such as "maintenance=idle|offline,moving=idle|stopped", into the
+// statuses each listed status may change to.  An empty list of statuses,
+// such as "offline=", only allows keeping the status.
+func ParseTransitions(v string) (Transitions, error) {
+	transitions := make(Transitions)
+	if strings.TrimSpace(v) == "" {
+		return transitions, nil
+	}
+
+	for _, pair := range strings.Split(v, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid status transition: %q", pair)
+		}
+		from, err := ParseStatus(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid status transition: %q: %w", pair, err)
+		}
+
+		allowed := []Status{}
+		for _, name := range strings.Split(parts[1], "|") {
+			if strings.TrimSpace(name) == "" {
+				continue
+			}
+			to, err := ParseStatus(name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid status transition: %q: %w", pair, err)
+			}
+			allowed = append(allowed, to)
+		}
+		transitions[from] = allowed
+	}
+	return transitions, nil
+}
+
+type StatusEventReader interface {
+	StatusEvents(id string, q HistoryQuery) ([]StatusEvent, error)
+}
+
+type StatusEventWriter interface {
+	AddStatusEvent(e StatusEvent) error
+}
+
+type StatusEventReaderWriter interface {
+	StatusEventReader
+	StatusEventWriter
+}
+
+// StatusEvent records an object changing status
+type StatusEvent struct {
+	// ObjectId is the id of the object that changed status
+	ObjectId string `json:"objectId"`
+
+	// From is the status the object had; empty for the first status of
+	// an object
+	From Status `json:"from"`
+
+	// To is the status the object changed to
+	To Status `json:"to"`
+
+	// Position is where the object was when it changed status
+	Position Position `json:"position"`
+
+	// Time is when the update that changed the status was recorded
+	Time time.Time `json:"time"`
+
+	// Tenant is the tenant of the object
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// ApplyStatusEvents filters events, ordered oldest to newest, down to the
+// events selected by the query.  The result keeps the oldest to newest
+// ordering.
+func (q HistoryQuery) ApplyStatusEvents(events []StatusEvent) []StatusEvent {
+	results := make([]StatusEvent, 0, len(events))
+	for _, e := range events {
+		if q.Includes(e.Time) {
+			results = append(results, e)
+		}
+	}
+
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[len(results)-q.Limit:]
+	}
+	return results
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestStatusDecoding(t *testing.T) {
+	var tm Telemetry
+	if err := json.Unmarshal([]byte(`{"status": "MOVING"}`), &tm); err != nil {
+		t.Fatalf("could not decode telemetry: %s", err.Error())
+	}
+	if tm.Status != StatusMoving {
+		t.Errorf("expected the status to be normalised; got %q", tm.Status)
+	}
+
+	if s, err := ParseStatus(" Idle "); err != nil || s != StatusIdle {
+		t.Errorf("expected idle; got %q (%v)", s, err)
+	}
+	if _, err := ParseStatus("parked"); err == nil {
+		t.Errorf("expected an unknown status to be rejected")
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	transitions := DefaultTransitions()
+
+	tests := []struct {
+		name     string
+		tr       Transitions
+		from, to Status
+		want     bool
+	}{
+		{name: "Allowed", tr: transitions, from: StatusIdle, to: StatusMoving, want: true},
+		{name: "NotAllowed", tr: transitions, from: StatusMaintenance, to: StatusMoving},
+		{name: "Same", tr: transitions, from: StatusMaintenance, to: StatusMaintenance, want: true},
+		{name: "FirstStatus", tr: transitions, to: StatusMaintenance, want: true},
+		{name: "Unlisted", tr: Transitions{StatusIdle: {StatusMoving}}, from: StatusMaintenance, to: StatusMoving, want: true},
+		{name: "Nil", from: StatusMaintenance, to: StatusMoving, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.tr.Allows(tt.from, tt.to); got != tt.want {
+				t.Errorf("expected %s to %s allowed to be %v; got %v", tt.from, tt.to, tt.want, got)
+			}
+		})
+	}
+
+	err := transitions.Check(&Telemetry{Status: StatusMaintenance}, Telemetry{Status: StatusMoving})
+	var fields FieldErrors
+	if !errors.As(err, &fields) || len(fields) != 1 || fields[0].Field != "status" {
+		t.Errorf("expected an error for status; got %v", err)
+	}
+}
+
+func TestParseTransitions(t *testing.T) {
+	tr, err := ParseTransitions("maintenance=idle|Offline, moving=")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if !tr.Allows(StatusMaintenance, StatusOffline) || tr.Allows(StatusMaintenance, StatusMoving) {
+		t.Errorf("expected maintenance to only change to idle or offline; got %v", tr)
+	}
+	if tr.Allows(StatusMoving, StatusIdle) {
+		t.Errorf("expected moving to keep its status; got %v", tr)
+	}
+
+	for _, v := range []string{"maintenance", "parked=idle", "idle=parked"} {
+		if _, err := ParseTransitions(v); err == nil {
+			t.Errorf("expected %q to be rejected", v)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+)
+
+type StopReader interface {
+	Stops(id string, q HistoryQuery) ([]Stop, error)
+
+	// FindStops returns the stops inside area, or anywhere when area is
+	// nil, of the objects of tenants, or of every tenant when tenants is
+	// nil.  The limit of the query applies to the stops found.
+	FindStops(area geo.Shape, tenants []string, q HistoryQuery) ([]Stop, error)
+}
+
+type StopWriter interface {
+	// SaveStop stores the stop, replacing the stop of its object with the
+	// same id
+	SaveStop(s Stop) error
+}
+
+type StopReaderWriter interface {
+	StopReader
+	StopWriter
+}
+
+// Stop is a time an object stayed put
+type Stop struct {
+	// Id identifies the stop among the stops of its object
+	Id string `json:"id"`
+
+	// ObjectId is the id of the object that stopped
+	ObjectId string `json:"objectId"`
+
+	// Tenant is the tenant of the object
+	Tenant string `json:"tenant,omitempty"`
+
+	// Position is the center of the fixes taken during the stop
+	Position Position `json:"position"`
+
+	// Arrived is when the object arrived at the stop
+	Arrived time.Time `json:"arrived"`
+
+	// Departed is the time of the last fix at the stop
+	Departed time.Time `json:"departed"`
+
+	// Duration is how long, in seconds, the object dwelled at the stop
+	Duration float64 `json:"duration"`
+
+	// InProgress reports whether the object is still at the stop
+	InProgress bool `json:"inProgress"`
+}
+
+// InTenant reports whether the object of s belongs to one of tenants.  A
+// nil list of tenants matches every tenant.
+func (s Stop) InTenant(tenants []string) bool {
+	return inTenants(s.Tenant, tenants)
+}
+
+// Hotspot is a cluster of stops close to each other
+type Hotspot struct {
+	// Position is the center of the stops of the hotspot
+	Position Position `json:"position"`
+
+	// Stops is the number of stops in the hotspot
+	Stops int `json:"stops"`
+
+	// Objects is the number of distinct objects that stopped in the hotspot
+	Objects int `json:"objects"`
+
+	// TotalDwell is the combined duration, in seconds, of the stops
+	TotalDwell float64 `json:"totalDwell"`
+
+	// AverageDwell is the mean duration, in seconds, of the stops
+	AverageDwell float64 `json:"averageDwell"`
+}
+
+// ApplyStops filters stops, ordered oldest to newest, down to the stops
+// overlapping the window of the query.  The result keeps the oldest to
+// newest ordering.
+func (q HistoryQuery) ApplyStops(stops []Stop) []Stop {
+	results := make([]Stop, 0, len(stops))
+	for _, s := range stops {
+		if !q.From.IsZero() && s.Departed.Before(q.From) {
+			continue
+		}
+		if !q.To.IsZero() && s.Arrived.After(q.To) {
+			continue
+		}
+		results = append(results, s)
+	}
+
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[len(results)-q.Limit:]
+	}
+	return results
+}
@@ -30,6 +30,30 @@ var (
 		},
 		[]string{"store"},
 	)
+
+	ExpiredCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "datastore_expired_total",
+			Help: "total number of records expired from the datastore",
+		},
+		[]string{"store"},
+	)
+
+	StaleCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "datastore_stale_updates_total",
+			Help: "total number of updates refused for being recorded before the stored position",
+		},
+		[]string{"store"},
+	)
+
+	ImplausibleCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ingest_implausible_total",
+			Help: "total number of updates rejected by a plausibility check",
+		},
+		[]string{"check"},
+	)
 )
 
 func GetCounterValue(metric *prometheus.CounterVec, labels ...string) (float64, error) {
@@ -44,4 +68,7 @@ func init() {
 	prometheus.MustRegister(TransactionDuration)
 	prometheus.MustRegister(TransactionErrors)
 	prometheus.MustRegister(RecordCount)
+	prometheus.MustRegister(ExpiredCount)
+	prometheus.MustRegister(StaleCount)
+	prometheus.MustRegister(ImplausibleCount)
 }
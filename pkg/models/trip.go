@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+type TripReader interface {
+	Trips(id string, q HistoryQuery) ([]Trip, error)
+}
+
+type TripWriter interface {
+	// SaveTrip stores the trip, replacing the trip of its object with the
+	// same id
+	SaveTrip(t Trip) error
+}
+
+type TripReaderWriter interface {
+	TripReader
+	TripWriter
+}
+
+// TripPoint is where an object was at a time
+type TripPoint struct {
+	Time     time.Time `json:"time"`
+	Position Position  `json:"position"`
+}
+
+// Trip summarises a journey of an object, from when it started moving until
+// it came to rest
+type Trip struct {
+	// Id identifies the trip among the trips of its object
+	Id string `json:"id"`
+
+	// ObjectId is the id of the object that made the trip
+	ObjectId string `json:"objectId"`
+
+	// Tenant is the tenant of the object
+	Tenant string `json:"tenant,omitempty"`
+
+	// Start is where and when the trip started
+	Start TripPoint `json:"start"`
+
+	// End is where and when the trip ended, or the latest fix of a trip
+	// that is in progress
+	End TripPoint `json:"end"`
+
+	// Distance is the length of the trip in meters
+	Distance float64 `json:"distance"`
+
+	// Duration is the length of the trip in seconds
+	Duration float64 `json:"duration"`
+
+	// MaxSpeed is the fastest the object moved during the trip in meters
+	// per second
+	MaxSpeed float64 `json:"maxSpeed"`
+
+	// AverageSpeed is the distance of the trip over its duration in meters
+	// per second
+	AverageSpeed float64 `json:"averageSpeed"`
+
+	// InProgress reports whether the object has not yet come to rest
+	InProgress bool `json:"inProgress"`
+}
+
+// ApplyTrips filters trips, ordered oldest to newest, down to the trips
+// overlapping the window of the query.  The result keeps the oldest to
+// newest ordering.
+func (q HistoryQuery) ApplyTrips(trips []Trip) []Trip {
+	results := make([]Trip, 0, len(trips))
+	for _, t := range trips {
+		if !q.From.IsZero() && t.End.Time.Before(q.From) {
+			continue
+		}
+		if !q.To.IsZero() && t.Start.Time.After(q.To) {
+			continue
+		}
+		results = append(results, t)
+	}
+
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[len(results)-q.Limit:]
+	}
+	return results
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultTTL is how long an object is tracked after its last update when no
+// TTL is configured.
+const DefaultTTL = 60 * time.Second
+
+// TTL decides how long the telemetry of an object is kept after its last
+// update.  Objects reported by a source listed in Sources use that source's
+// TTL instead of Default.
+type TTL struct {
+	Default time.Duration
+	Sources map[string]time.Duration
+}
+
+// For returns the TTL of telemetry reported by source
+func (ttl TTL) For(source string) time.Duration {
+	if d, ok := ttl.Sources[source]; ok {
+		return d
+	}
+	if ttl.Default <= 0 {
+		return DefaultTTL
+	}
+	return ttl.Default
+}
+
+// Expired reports whether t has outlived its TTL at now
+func (ttl TTL) Expired(t Telemetry, now time.Time) bool {
+	return t.Updated.Before(now.Add(-ttl.For(t.Source)))
+}
+
+// ParseSourceTTLs parses a comma separated list of source=duration pairs,
+// such as "gateway-1=30s,gateway-2=5m", into per-source TTL overrides.
+func ParseSourceTTLs(v string) (map[string]time.Duration, error) {
+	sources := make(map[string]time.Duration)
+	if strings.TrimSpace(v) == "" {
+		return sources, nil
+	}
+
+	for _, pair := range strings.Split(v, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid source ttl: %q", pair)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid source ttl: %q", pair)
+		}
+		sources[strings.TrimSpace(parts[0])] = d
+	}
+	return sources, nil
+}
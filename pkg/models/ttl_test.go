@@ -0,0 +1,63 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLFor(t *testing.T) {
+	ttl := TTL{Default: time.Minute, Sources: map[string]time.Duration{"slow": time.Hour}}
+
+	if d := ttl.For("slow"); d != time.Hour {
+		t.Errorf("expected the source override; got %s", d)
+	}
+	if d := ttl.For("other"); d != time.Minute {
+		t.Errorf("expected the default ttl; got %s", d)
+	}
+	if d := (TTL{}).For("other"); d != DefaultTTL {
+		t.Errorf("expected DefaultTTL when unset; got %s", d)
+	}
+}
+
+func TestTTLExpired(t *testing.T) {
+	now := time.Now()
+	ttl := TTL{Default: 30 * time.Second, Sources: map[string]time.Duration{"slow": time.Hour}}
+
+	tests := []struct {
+		name    string
+		t       Telemetry
+		expired bool
+	}{
+		{name: "Fresh", t: Telemetry{Updated: now.Add(-10 * time.Second)}, expired: false},
+		{name: "Stale", t: Telemetry{Updated: now.Add(-31 * time.Second)}, expired: true},
+		{name: "SourceOverride", t: Telemetry{Source: "slow", Updated: now.Add(-31 * time.Second)}, expired: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ttl.Expired(tt.t, now); got != tt.expired {
+				t.Errorf("expected expired to be %t; got %t", tt.expired, got)
+			}
+		})
+	}
+}
+
+func TestParseSourceTTLs(t *testing.T) {
+	sources, err := ParseSourceTTLs("gateway-1=30s, gateway-2=5m")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if sources["gateway-1"] != 30*time.Second || sources["gateway-2"] != 5*time.Minute {
+		t.Errorf("unexpected overrides: %v", sources)
+	}
+
+	if sources, err := ParseSourceTTLs(""); err != nil || len(sources) != 0 {
+		t.Errorf("expected no overrides; got %v, %v", sources, err)
+	}
+
+	for _, v := range []string{"gateway", "=30s", "gateway=soon", "gateway=-1s"} {
+		if _, err := ParseSourceTTLs(v); err == nil {
+			t.Errorf("expected %q to be rejected", v)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+)
+
+// FieldError describes why the value of one field of a payload is invalid.
+// Field is the dotted JSON path of the field, such as "position.latitude".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// FieldErrors is the error returned when a payload fails validation.  It
+// matches ValidationError with errors.Is.
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fe := range e {
+		messages[i] = fe.Field + " " + fe.Message
+	}
+	return fmt.Sprintf("%s: %s", ValidationError, strings.Join(messages, "; "))
+}
+
+func (e FieldErrors) Is(target error) bool {
+	return target == ValidationError
+}
+
+// validate checks the validate tags of models and names fields by their
+// JSON names
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
+// fieldErrors converts the errors of the validator into field errors
+func fieldErrors(err error) error {
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		return err
+	}
+
+	errs := make(FieldErrors, 0, len(invalid))
+	for _, fe := range invalid {
+		// the namespace starts with the name of the validated struct
+		field := fe.Namespace()
+		if i := strings.Index(field, "."); i >= 0 {
+			field = field[i+1:]
+		}
+		errs = append(errs, FieldError{Field: field, Message: fieldMessage(fe)})
+	}
+	return errs
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		return "must be at least " + fe.Param()
+	case "max":
+		return "must be at most " + fe.Param()
+	case "lt":
+		return "must be less than " + fe.Param()
+	}
+	return fmt.Sprintf("failed the %s check", fe.Tag())
+}
+
+const (
+	// MaxAttributes is the most attributes an update may carry
+	MaxAttributes = 32
+
+	// MaxAttributeKey is the longest name of an attribute in bytes
+	MaxAttributeKey = 64
+
+	// MaxAttributeValue is the longest value of an attribute in bytes
+	MaxAttributeValue = 256
+)
+
+// validateAttributes returns the field errors of attributes that exceed
+// their bounds
+func validateAttributes(attributes map[string]string) FieldErrors {
+	if len(attributes) > MaxAttributes {
+		return FieldErrors{{Field: "attributes", Message: fmt.Sprintf("must have at most %d entries", MaxAttributes)}}
+	}
+
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs FieldErrors
+	for _, k := range keys {
+		field := fmt.Sprintf("attributes[%s]", k)
+		switch {
+		case k == "":
+			errs = append(errs, FieldError{Field: "attributes", Message: "must not have an empty name"})
+		case len(k) > MaxAttributeKey:
+			errs = append(errs, FieldError{Field: "attributes", Message: fmt.Sprintf("must have names of at most %d bytes", MaxAttributeKey)})
+		case len(attributes[k]) > MaxAttributeValue:
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("must be at most %d bytes", MaxAttributeValue)})
+		}
+	}
+	return errs
+}
+
+// DecodeTelemetry decodes and validates a JSON telemetry update.  Unlike
+// decoding into Telemetry directly, a coordinate left out of the payload is
+// reported as missing rather than read as zero, which is a valid coordinate.
+func DecodeTelemetry(data []byte) (Telemetry, error) {
+	var t Telemetry
+	if err := json.Unmarshal(data, &t); err != nil {
+		return t, fmt.Errorf("%w: %s", DecodeError, err)
+	}
+
+	var present struct {
+		Position *struct {
+			Latitude  *float64 `json:"latitude"`
+			Longitude *float64 `json:"longitude"`
+		} `json:"position"`
+	}
+	if err := json.Unmarshal(data, &present); err != nil {
+		return t, fmt.Errorf("%w: %s", DecodeError, err)
+	}
+
+	var errs FieldErrors
+	switch {
+	case present.Position == nil:
+		errs = append(errs, FieldError{Field: "position", Message: "is required"})
+	default:
+		if present.Position.Latitude == nil {
+			errs = append(errs, FieldError{Field: "position.latitude", Message: "is required"})
+		}
+		if present.Position.Longitude == nil {
+			errs = append(errs, FieldError{Field: "position.longitude", Message: "is required"})
+		}
+	}
+
+	if err := t.Validate(); err != nil {
+		invalid, ok := err.(FieldErrors)
+		if !ok {
+			return t, err
+		}
+		errs = append(errs, invalid...)
+	}
+	if len(errs) > 0 {
+		return t, errs
+	}
+	return t, nil
+}
+
+// Plausibility rejects updates that are valid on their own but can not be
+// true, such as a vehicle crossing a continent between two fixes.  A zero
+// value disables its check.
+type Plausibility struct {
+	// MaxSpeed is the fastest, in meters a second, an object may have moved
+	// from its previous fix
+	MaxSpeed float64
+
+	// MaxFutureSkew is how far ahead of the server clock the recorded time
+	// of an update may be
+	MaxFutureSkew time.Duration
+}
+
+// minSpeedInterval is the shortest time between fixes speed is measured
+// over, so fixes arriving together are not read as moving infinitely fast
+const minSpeedInterval = time.Second
+
+// Check returns the field errors of t, compared with the previous telemetry
+// of the object, or nil when t is plausible.  Updates are received at now,
+// which stands in for the recorded time of updates that have none.
+func (p Plausibility) Check(previous *Telemetry, t Telemetry, now time.Time) error {
+	var errs FieldErrors
+
+	if p.MaxFutureSkew > 0 && t.RecordedAt.After(now.Add(p.MaxFutureSkew)) {
+		ImplausibleCount.WithLabelValues("future").Inc()
+		errs = append(errs, FieldError{
+			Field:   "recordedAt",
+			Message: fmt.Sprintf("is more than %s ahead of the server clock", p.MaxFutureSkew),
+		})
+	}
+
+	if p.MaxSpeed > 0 && previous != nil {
+		elapsed := recordedAt(t, now).Sub(recordedAt(*previous, previous.Updated))
+		if elapsed < 0 {
+			elapsed = -elapsed
+		}
+		if elapsed < minSpeedInterval {
+			elapsed = minSpeedInterval
+		}
+		distance := geo.Distance(previous.Position.Point(), t.Position.Point())
+		if speed := distance / elapsed.Seconds(); speed > p.MaxSpeed {
+			ImplausibleCount.WithLabelValues("speed").Inc()
+			errs = append(errs, FieldError{
+				Field:   "position",
+				Message: fmt.Sprintf("implies a speed of %.0f m/s from the previous fix; the limit is %.0f m/s", speed, p.MaxSpeed),
+			})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// recordedAt returns the recorded time of t, or fallback if it has none
+func recordedAt(t Telemetry, fallback time.Time) time.Time {
+	if t.RecordedAt.IsZero() {
+		return fallback
+	}
+	return t.RecordedAt
+}
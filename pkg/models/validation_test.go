@@ -0,0 +1,127 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeTelemetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		fields []string
+		decode bool
+	}{
+		{name: "Valid", data: `{"source": "gw", "objectId": "1", "position": {"latitude": 45.5, "longitude": -122.6}}`},
+		{name: "ZeroCoordinates", data: `{"source": "gw", "objectId": "1", "position": {"latitude": 0, "longitude": 0}}`},
+		{name: "Bounds", data: `{"source": "gw", "objectId": "1", "position": {"latitude": -90, "longitude": 180}}`},
+		{name: "OutOfRange", data: `{"source": "gw", "objectId": "1", "position": {"latitude": 95, "longitude": 500}}`, fields: []string{"position.latitude", "position.longitude"}},
+		{name: "MissingCoordinate", data: `{"source": "gw", "objectId": "1", "position": {"latitude": 0}}`, fields: []string{"position.longitude"}},
+		{name: "MissingPosition", data: `{"source": "gw"}`, fields: []string{"position", "objectId"}},
+		{name: "Readings", data: `{"source": "gw", "objectId": "1", "position": {"latitude": 1, "longitude": 1}, "speed": 0, "heading": 359.9, "accuracy": 4.5, "hdop": 0.9, "satellites": 9, "battery": 100, "ignition": false, "attributes": {"fuel": "42"}}`},
+		{name: "ReadingsOutOfRange", data: `{"source": "gw", "objectId": "1", "position": {"latitude": 1, "longitude": 1}, "speed": -1, "heading": 360, "accuracy": -1, "hdop": -1, "satellites": -1, "battery": 101}`, fields: []string{"speed", "heading", "accuracy", "hdop", "satellites", "battery"}},
+		{name: "AttributeTooLong", data: `{"source": "gw", "objectId": "1", "position": {"latitude": 1, "longitude": 1}, "attributes": {"note": "` + strings.Repeat("x", MaxAttributeValue+1) + `"}}`, fields: []string{"attributes[note]"}},
+		{name: "AttributeNameTooLong", data: `{"source": "gw", "objectId": "1", "position": {"latitude": 1, "longitude": 1}, "attributes": {"` + strings.Repeat("x", MaxAttributeKey+1) + `": "1"}}`, fields: []string{"attributes"}},
+		{name: "TooManyAttributes", data: `{"source": "gw", "objectId": "1", "position": {"latitude": 1, "longitude": 1}, "attributes": {` + manyAttributes(MaxAttributes+1) + `}}`, fields: []string{"attributes"}},
+		{name: "AttributeNotString", data: `{"source": "gw", "objectId": "1", "position": {"latitude": 1, "longitude": 1}, "attributes": {"fuel": 42}}`, decode: true},
+		{name: "Status", data: `{"source": "gw", "objectId": "1", "position": {"latitude": 1, "longitude": 1}, "status": " Moving"}`},
+		{name: "UnknownStatus", data: `{"source": "gw", "objectId": "1", "position": {"latitude": 1, "longitude": 1}, "status": "parked"}`, fields: []string{"status"}},
+		{name: "Malformed", data: `{"source": `, decode: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeTelemetry([]byte(tt.data))
+			if tt.decode {
+				if !errors.Is(err, DecodeError) {
+					t.Errorf("expected a decode error; got %v", err)
+				}
+				return
+			}
+			if len(tt.fields) == 0 {
+				if err != nil {
+					t.Errorf("expected no error; got %v", err)
+				}
+				return
+			}
+
+			var fields FieldErrors
+			if !errors.As(err, &fields) || !errors.Is(err, ValidationError) {
+				t.Fatalf("expected field errors; got %v", err)
+			}
+			if len(fields) != len(tt.fields) {
+				t.Fatalf("expected errors for %v; got %+v", tt.fields, fields)
+			}
+			for i, field := range tt.fields {
+				if fields[i].Field != field {
+					t.Errorf("expected an error for %s; got %+v", field, fields[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeTelemetryServiceState(t *testing.T) {
+	tm, err := DecodeTelemetry([]byte(`{"source": "gw", "objectId": "1", "position": {"latitude": 1, "longitude": 1}, "odometerAnchor": {"latitude": 2, "longitude": 2}, "positionVariance": 1}`))
+	if err != nil {
+		t.Fatalf("expected no error; got %v", err)
+	}
+	if tm.OdometerAnchor != nil {
+		t.Errorf("expected a reported odometer anchor to be ignored; got %+v", tm.OdometerAnchor)
+	}
+	if tm.PositionVariance != nil {
+		t.Errorf("expected a reported position variance to be ignored; got %f", *tm.PositionVariance)
+	}
+}
+
+// manyAttributes returns the JSON members of n attributes
+func manyAttributes(n int) string {
+	members := make([]string, n)
+	for i := range members {
+		members[i] = fmt.Sprintf(`"a%d": "1"`, i)
+	}
+	return strings.Join(members, ",")
+}
+
+func TestPlausibility(t *testing.T) {
+	now := time.Now()
+	previous := &Telemetry{Position: Position{Latitude: 45, Longitude: -122}, RecordedAt: now.Add(-time.Minute)}
+	rules := Plausibility{MaxSpeed: 100, MaxFutureSkew: time.Minute}
+
+	tests := []struct {
+		name     string
+		rules    Plausibility
+		previous *Telemetry
+		t        Telemetry
+		field    string
+	}{
+		// 0.01 degrees of latitude is roughly 1.1km, 18 m/s over a minute
+		{name: "Plausible", rules: rules, previous: previous, t: Telemetry{Position: Position{Latitude: 45.01, Longitude: -122}}},
+		{name: "FirstFix", rules: rules, t: Telemetry{Position: Position{Latitude: 10, Longitude: 10}}},
+		{name: "Teleport", rules: rules, previous: previous, t: Telemetry{Position: Position{Latitude: 46, Longitude: -122}}, field: "position"},
+		{name: "Buffered", rules: rules, previous: previous, t: Telemetry{Position: Position{Latitude: 45.01, Longitude: -122}, RecordedAt: now.Add(-2 * time.Minute)}},
+		{name: "Future", rules: rules, t: Telemetry{RecordedAt: now.Add(time.Hour)}, field: "recordedAt"},
+		{name: "SlightlyAhead", rules: rules, t: Telemetry{RecordedAt: now.Add(time.Second)}},
+		{name: "Disabled", previous: previous, t: Telemetry{Position: Position{Latitude: -45, Longitude: 60}, RecordedAt: now.Add(time.Hour)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rules.Check(tt.previous, tt.t, now)
+			if tt.field == "" {
+				if err != nil {
+					t.Errorf("expected the update to be plausible; got %v", err)
+				}
+				return
+			}
+
+			var fields FieldErrors
+			if !errors.As(err, &fields) || len(fields) != 1 || fields[0].Field != tt.field {
+				t.Errorf("expected an error for %s; got %v", tt.field, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+// Package odometer accumulates the distance each object has travelled.
+//
+// Distance is measured between accepted fixes along the great circle.  GPS
+// jitter makes a parked object wander around its true position, so a fix
+// only advances the odometer once it is at least the minimum movement away
+// from the position the odometer last advanced at.  Slow movement still
+// adds up, as small steps are measured together once they reach the
+// minimum.
+package odometer
+
+import (
+	"math"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// DefaultMinMovement is how far, in meters, an object must move before the
+// distance counts toward its odometer
+const DefaultMinMovement = 10
+
+// Meter keeps the odometer readings of objects.  The position the odometer
+// of an object last advanced at is carried on its telemetry, so a reading
+// only takes effect once its update is written.  A nil Meter counts every
+// movement.
+type Meter struct {
+	minMovement float64
+}
+
+func New(minMovement float64) *Meter {
+	return &Meter{minMovement: minMovement}
+}
+
+// Advance sets the odometer of t from the reading of the previous telemetry
+// of its object.  The distance from where the odometer last advanced is
+// added once it reaches the minimum movement, or the accuracy of t when
+// that is larger.  A new object starts at zero.
+func (m *Meter) Advance(previous *models.Telemetry, t *models.Telemetry) {
+	if previous == nil {
+		t.Odometer = 0
+		t.OdometerAnchor = anchor(t.Position)
+		return
+	}
+
+	t.Odometer = previous.Odometer
+	t.OdometerAnchor = previous.OdometerAnchor
+	from := previous.Position.Point()
+	threshold := 0.0
+	if m != nil {
+		if previous.OdometerAnchor != nil {
+			from = previous.OdometerAnchor.Point()
+		}
+		threshold = m.minMovement
+	}
+	if t.Accuracy != nil {
+		threshold = math.Max(threshold, *t.Accuracy)
+	}
+
+	if d := geo.Distance(from, t.Position.Point()); d > 0 && d >= threshold {
+		t.Odometer += d
+		t.OdometerAnchor = anchor(t.Position)
+	}
+}
+
+// anchor returns a copy of p to anchor an odometer at
+func anchor(p models.Position) *models.Position {
+	return &p
+}
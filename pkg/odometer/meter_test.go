@@ -0,0 +1,84 @@
+package odometer
+
+import (
+	"math"
+	"testing"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// replay advances the meter over fixes of the vehicle at each latitude and
+// returns the readings
+func replay(m *Meter, lats ...float64) []float64 {
+	var previous *models.Telemetry
+	var readings []float64
+	for _, lat := range lats {
+		t := models.Telemetry{Id: "vehicle", Position: models.Position{Latitude: lat, Longitude: -122}}
+		m.Advance(previous, &t)
+		readings = append(readings, t.Odometer)
+		previous = &t
+	}
+	return readings
+}
+
+func TestMeter(t *testing.T) {
+	// 0.00005 degrees of latitude is roughly 5.6m and 0.001 roughly 111m
+	readings := replay(New(DefaultMinMovement),
+		45,
+		// jitter around a parked position does not count
+		45.00005, 44.99995, 45.00005,
+		45.001,
+		// slow movement adds up once it reaches the minimum
+		45.00104, 45.00108, 45.00112,
+	)
+
+	if readings[0] != 0 || readings[3] != 0 {
+		t.Errorf("expected jitter to be suppressed; got %v", readings)
+	}
+	if math.Abs(readings[4]-111.2) > 0.5 {
+		t.Errorf("expected about 111m after moving 0.001 degrees; got %f", readings[4])
+	}
+	if readings[5] != readings[4] || readings[6] != readings[4] {
+		t.Errorf("expected steps below the minimum to wait; got %v", readings)
+	}
+	if math.Abs(readings[7]-readings[4]-13.3) > 0.5 {
+		t.Errorf("expected the small steps to be counted together; got %v", readings)
+	}
+}
+
+func TestMeterAccuracy(t *testing.T) {
+	m := New(DefaultMinMovement)
+	previous := &models.Telemetry{Id: "vehicle", Position: models.Position{Latitude: 45, Longitude: -122}}
+	m.Advance(nil, previous)
+
+	accuracy := 200.0
+	fix := models.Telemetry{Id: "vehicle", Accuracy: &accuracy, Position: models.Position{Latitude: 45.001, Longitude: -122}}
+	m.Advance(previous, &fix)
+	if fix.Odometer != 0 {
+		t.Errorf("expected movement within the accuracy of the fix to be ignored; got %f", fix.Odometer)
+	}
+}
+
+func TestNilMeter(t *testing.T) {
+	var m *Meter
+	readings := replay(m, 45, 45.00005, 45.0001)
+	if readings[0] != 0 || math.Abs(readings[2]-11.1) > 0.5 {
+		t.Errorf("expected a nil meter to count every movement; got %v", readings)
+	}
+}
+
+func TestMeterUnwritten(t *testing.T) {
+	m := New(DefaultMinMovement)
+	previous := &models.Telemetry{Id: "vehicle", Position: models.Position{Latitude: 45, Longitude: -122}}
+	m.Advance(nil, previous)
+
+	// an update that is measured but never written does not move the
+	// odometer of the next one
+	lost := models.Telemetry{Id: "vehicle", Position: models.Position{Latitude: 45.001, Longitude: -122}}
+	m.Advance(previous, &lost)
+	fix := lost
+	m.Advance(previous, &fix)
+	if math.Abs(fix.Odometer-111.2) > 0.5 || fix.OdometerAnchor.Latitude != 45.001 {
+		t.Errorf("expected the distance from the last written update; got %f from %+v", fix.Odometer, fix.OdometerAnchor)
+	}
+}
@@ -0,0 +1,198 @@
+// Package pubsub fans accepted telemetry updates out to live subscribers.
+package pubsub
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// DefaultBuffer is the number of updates queued for each subscriber
+const DefaultBuffer = 64
+
+var (
+	Subscribers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "stream_subscribers_current",
+			Help: "number of live telemetry stream subscribers",
+		},
+	)
+
+	Published = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "stream_messages_published_total",
+			Help: "total number of telemetry updates published to the stream",
+		},
+	)
+
+	Dropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "stream_messages_dropped_total",
+			Help: "total number of telemetry updates dropped for slow subscribers",
+		},
+	)
+)
+
+// Filter selects the updates a subscriber receives.  Empty fields match
+// every update.
+type Filter struct {
+	// Tenants limits updates to objects of these tenants; nil matches every
+	// tenant
+	Tenants  []string
+	Source   string
+	ObjectID string
+	Area     geo.Shape
+}
+
+// Match reports whether t passes the filter
+func (f Filter) Match(t models.Telemetry) bool {
+	if !t.InTenant(f.Tenants) {
+		return false
+	}
+	if f.Source != "" && f.Source != t.Source {
+		return false
+	}
+	if f.ObjectID != "" && f.ObjectID != t.ObjectID {
+		return false
+	}
+	if f.Area != nil && !f.Area.Contains(t.Position.Point()) {
+		return false
+	}
+	return true
+}
+
+// Subscription is a live feed of the updates matching its filter
+type Subscription struct {
+	filter Filter
+	ch     chan models.Telemetry
+}
+
+// C returns the channel updates are delivered on.  The channel is closed
+// once the subscription is cancelled.
+func (s *Subscription) C() <-chan models.Telemetry {
+	return s.ch
+}
+
+// deliver queues t without blocking.  When the subscriber has fallen behind,
+// the oldest queued update is dropped to make room since a newer position
+// supersedes it.
+func (s *Subscription) deliver(t models.Telemetry) {
+	select {
+	case s.ch <- t:
+		return
+	default:
+	}
+
+	select {
+	case <-s.ch:
+		Dropped.Inc()
+	default:
+	}
+
+	select {
+	case s.ch <- t:
+	default:
+		// another publisher refilled the queue first
+		Dropped.Inc()
+	}
+}
+
+// Broker publishes every accepted update to the subscribers whose filter
+// matches it.  A slow subscriber never blocks publishing.
+type Broker struct {
+	buffer int
+
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+func NewBroker(buffer int) *Broker {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	return &Broker{
+		buffer:      buffer,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a new subscription for updates matching f
+func (b *Broker) Subscribe(f Filter) *Subscription {
+	s := &Subscription{
+		filter: f,
+		ch:     make(chan models.Telemetry, b.buffer),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.ch)
+		return s
+	}
+	b.subscribers[s] = struct{}{}
+
+	Subscribers.Inc()
+	return s
+}
+
+// Unsubscribe cancels the subscription and closes its channel
+func (b *Broker) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[s]; !ok {
+		return
+	}
+	delete(b.subscribers, s)
+	close(s.ch)
+	Subscribers.Dec()
+}
+
+// Close ends every subscription so that streams finish and the server can
+// shut down.  Subscriptions made after Close are returned already closed.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subscribers {
+		delete(b.subscribers, s)
+		close(s.ch)
+		Subscribers.Dec()
+	}
+}
+
+// Len returns the number of live subscriptions
+func (b *Broker) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers)
+}
+
+// Publish delivers t to every subscription whose filter matches it
+func (b *Broker) Publish(t models.Telemetry) {
+	Published.Inc()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subscribers {
+		if s.filter.Match(t) {
+			s.deliver(t)
+		}
+	}
+}
+
+// Observe implements models.TelemetryObserver by publishing every accepted
+// update.
+func (b *Broker) Observe(previous *models.Telemetry, current models.Telemetry) {
+	b.Publish(current)
+}
+
+func init() {
+	prometheus.MustRegister(Subscribers)
+	prometheus.MustRegister(Published)
+	prometheus.MustRegister(Dropped)
+}
@@ -0,0 +1,130 @@
+package pubsub
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+func TestFilter(t *testing.T) {
+	tm := models.Telemetry{
+		Source:   "gateway-1",
+		ObjectID: "0001",
+		Tenant:   "acme",
+		Position: models.Position{Latitude: 45.5, Longitude: -122.6},
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{name: "Empty", filter: Filter{}, want: true},
+		{name: "Source", filter: Filter{Source: "gateway-1"}, want: true},
+		{name: "OtherSource", filter: Filter{Source: "gateway-2"}, want: false},
+		{name: "ObjectID", filter: Filter{Source: "gateway-1", ObjectID: "0001"}, want: true},
+		{name: "OtherObjectID", filter: Filter{ObjectID: "0002"}, want: false},
+		{name: "InsideArea", filter: Filter{Area: geo.BBox{MinLat: 45, MinLon: -123, MaxLat: 46, MaxLon: -122}}, want: true},
+		{name: "Tenant", filter: Filter{Tenants: []string{"globex", "acme"}}, want: true},
+		{name: "OtherTenant", filter: Filter{Tenants: []string{"globex"}}, want: false},
+		{name: "OutsideArea", filter: Filter{Area: geo.BBox{MinLat: 0, MinLon: 0, MaxLat: 1, MaxLon: 1}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(tm); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublish(t *testing.T) {
+	b := NewBroker(10)
+	all := b.Subscribe(Filter{})
+	one := b.Subscribe(Filter{Source: "gateway-1"})
+	if b.Len() != 2 {
+		t.Fatalf("expected 2 subscribers; got %d", b.Len())
+	}
+
+	b.Observe(nil, models.Telemetry{Source: "gateway-1"})
+	b.Observe(nil, models.Telemetry{Source: "gateway-2"})
+
+	if len(all.C()) != 2 {
+		t.Errorf("expected 2 updates for the unfiltered subscriber; got %d", len(all.C()))
+	}
+	if len(one.C()) != 1 {
+		t.Errorf("expected 1 update for the filtered subscriber; got %d", len(one.C()))
+	}
+
+	b.Unsubscribe(all)
+	b.Unsubscribe(all)
+	if b.Len() != 1 {
+		t.Errorf("expected 1 subscriber; got %d", b.Len())
+	}
+	for range all.C() {
+	}
+}
+
+func TestSlowSubscriberDropsOldest(t *testing.T) {
+	b := NewBroker(5)
+	s := b.Subscribe(Filter{})
+
+	for i := 0; i < 20; i++ {
+		b.Publish(models.Telemetry{ObjectID: fmt.Sprintf("%d", i)})
+	}
+
+	if len(s.C()) != 5 {
+		t.Fatalf("expected the queue to stay at 5 updates; got %d", len(s.C()))
+	}
+	first := <-s.C()
+	if first.ObjectID != "15" {
+		t.Errorf("expected the oldest updates to be dropped; first queued update is %s", first.ObjectID)
+	}
+}
+
+func TestConcurrentPublish(t *testing.T) {
+	b := NewBroker(1)
+	s := b.Subscribe(Filter{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				b.Publish(models.Telemetry{})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(s.C()) != 1 {
+		t.Errorf("expected a full queue of 1; got %d", len(s.C()))
+	}
+	b.Unsubscribe(s)
+}
+
+func TestClose(t *testing.T) {
+	b := NewBroker(1)
+	sub := b.Subscribe(Filter{})
+
+	b.Close()
+	if _, ok := <-sub.C(); ok {
+		t.Errorf("expected the subscription to be closed")
+	}
+	if b.Len() != 0 {
+		t.Errorf("expected no subscribers; got %d", b.Len())
+	}
+
+	// unsubscribing a closed subscription is harmless
+	b.Unsubscribe(sub)
+
+	late := b.Subscribe(Filter{})
+	if _, ok := <-late.C(); ok {
+		t.Errorf("expected subscriptions after Close to be closed")
+	}
+	b.Publish(models.Telemetry{})
+}
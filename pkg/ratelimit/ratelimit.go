@@ -0,0 +1,180 @@
+// Package ratelimit implements token-bucket rate limiting by key.
+//
+// Every key, such as a telemetry source or a client address, has a bucket
+// holding up to Burst tokens that refills at Rate tokens a second.  A request
+// is allowed when its key's bucket holds enough tokens for its cost.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets that have refilled are dropped
+const sweepInterval = time.Minute
+
+// Limit is the rate, in tokens a second, and the burst of a bucket.  A zero
+// rate does not limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Unlimited reports whether the limit allows every request
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// capacity is the most tokens a bucket of the limit holds
+func (l Limit) capacity() float64 {
+	if l.Burst < 1 {
+		return 1
+	}
+	return float64(l.Burst)
+}
+
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the bucket was last used
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.limit.capacity(), b.tokens+elapsed*b.limit.Rate)
+	}
+	b.last = now
+}
+
+// Limiter keeps a token bucket for every key it has seen recently.  It is
+// safe for concurrent use.
+type Limiter struct {
+	limit     Limit
+	overrides map[string]Limit
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// New returns a limiter applying limit to every key except those with an
+// override.
+func New(limit Limit, overrides map[string]Limit) *Limiter {
+	return &Limiter{
+		limit:     limit,
+		overrides: overrides,
+		buckets:   make(map[string]*bucket),
+	}
+}
+
+// For returns the limit applied to key
+func (l *Limiter) For(key string) Limit {
+	if limit, ok := l.overrides[key]; ok {
+		return limit
+	}
+	return l.limit
+}
+
+// Allow takes one token from the bucket of key.  When the bucket is empty it
+// returns false and how long until a token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	return l.Take(map[string]int{key: 1})
+}
+
+// Take takes cost tokens from the bucket of every key, or none at all if any
+// bucket is short, and returns how long until they would all be available.
+// A cost larger than a bucket's burst waits for the bucket to be full and
+// empties it.
+func (l *Limiter) Take(costs map[string]int) (bool, time.Duration) {
+	return l.take(costs, time.Now())
+}
+
+func (l *Limiter) take(costs map[string]int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	var wait time.Duration
+	buckets := make(map[*bucket]float64, len(costs))
+	for key, cost := range costs {
+		limit := l.For(key)
+		if limit.Unlimited() || cost <= 0 {
+			continue
+		}
+
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &bucket{limit: limit, tokens: limit.capacity(), last: now}
+			l.buckets[key] = b
+		}
+		b.refill(now)
+
+		need := math.Min(float64(cost), limit.capacity())
+		if b.tokens < need {
+			if w := time.Duration((need - b.tokens) / limit.Rate * float64(time.Second)); w > wait {
+				wait = w
+			}
+		}
+		buckets[b] = need
+	}
+	if wait > 0 {
+		return false, wait
+	}
+
+	for b, need := range buckets {
+		b.tokens -= need
+	}
+	return true, 0
+}
+
+// sweep drops the buckets that have refilled; a new bucket starts full so
+// forgetting them changes nothing.  l.mu must be held by the caller.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= b.limit.capacity() {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+// ParseLimits parses a comma separated list of key=rate:burst pairs, such as
+// "gateway-1=50:100,gateway-2=5", into per-key limits.  The burst defaults
+// to the rate rounded up.
+func ParseLimits(v string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	if strings.TrimSpace(v) == "" {
+		return limits, nil
+	}
+
+	for _, pair := range strings.Split(v, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid rate limit: %q", pair)
+		}
+
+		values := strings.SplitN(strings.TrimSpace(parts[1]), ":", 2)
+		rate, err := strconv.ParseFloat(values[0], 64)
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("invalid rate limit: %q", pair)
+		}
+		limit := Limit{Rate: rate, Burst: int(math.Ceil(rate))}
+		if len(values) == 2 {
+			burst, err := strconv.Atoi(values[1])
+			if err != nil || burst < 1 {
+				return nil, fmt.Errorf("invalid rate limit: %q", pair)
+			}
+			limit.Burst = burst
+		}
+		limits[strings.TrimSpace(parts[0])] = limit
+	}
+	return limits, nil
+}
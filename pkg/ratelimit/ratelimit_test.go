@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	l := New(Limit{Rate: 1, Burst: 2}, map[string]Limit{"fast": {Rate: 100, Burst: 100}, "free": {}})
+	now := time.Unix(1600000000, 0)
+	one := map[string]int{"slow": 1}
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.take(one, now); !ok {
+			t.Fatalf("expected request %d of the burst to be allowed", i)
+		}
+	}
+	ok, wait := l.take(one, now)
+	if ok || wait != time.Second {
+		t.Errorf("expected an empty bucket to wait 1s; got %v, %v", ok, wait)
+	}
+	if ok, _ := l.take(one, now.Add(time.Second)); !ok {
+		t.Errorf("expected the bucket to refill")
+	}
+
+	if ok, _ := l.take(map[string]int{"fast": 50}, now); !ok {
+		t.Errorf("expected the override to apply")
+	}
+	if ok, _ := l.take(map[string]int{"free": 1000}, now); !ok {
+		t.Errorf("expected a zero rate to be unlimited")
+	}
+}
+
+func TestTakeAllOrNothing(t *testing.T) {
+	l := New(Limit{Rate: 1, Burst: 5}, nil)
+	now := time.Unix(1600000000, 0)
+
+	if ok, _ := l.take(map[string]int{"a": 5}, now); !ok {
+		t.Fatal("expected a full bucket to be allowed")
+	}
+	if ok, _ := l.take(map[string]int{"a": 1, "b": 1}, now); ok {
+		t.Fatal("expected the empty bucket to reject the request")
+	}
+	if ok, _ := l.take(map[string]int{"b": 5}, now); !ok {
+		t.Errorf("expected a rejected request to take no tokens")
+	}
+}
+
+func TestTakeOverBurst(t *testing.T) {
+	l := New(Limit{Rate: 10, Burst: 10}, nil)
+	now := time.Unix(1600000000, 0)
+
+	if ok, _ := l.take(map[string]int{"a": 50}, now); !ok {
+		t.Fatal("expected a cost over the burst to be allowed from a full bucket")
+	}
+	ok, wait := l.take(map[string]int{"a": 50}, now)
+	if ok || wait != time.Second {
+		t.Errorf("expected a cost over the burst to wait for a full bucket; got %v, %v", ok, wait)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l := New(Limit{Rate: 1, Burst: 1}, nil)
+	now := time.Unix(1600000000, 0)
+
+	l.take(map[string]int{"a": 1}, now)
+	l.take(map[string]int{"b": 1}, now.Add(sweepInterval))
+	if _, ok := l.buckets["a"]; ok {
+		t.Errorf("expected the refilled bucket to be dropped")
+	}
+	if _, ok := l.buckets["b"]; !ok {
+		t.Errorf("expected the empty bucket to be kept")
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits("gateway-1=50:100, gateway-2=2.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits["gateway-1"] != (Limit{Rate: 50, Burst: 100}) {
+		t.Errorf("unexpected limit: %+v", limits["gateway-1"])
+	}
+	if limits["gateway-2"] != (Limit{Rate: 2.5, Burst: 3}) {
+		t.Errorf("unexpected limit: %+v", limits["gateway-2"])
+	}
+
+	for _, v := range []string{"gateway-1", "=5", "gateway-1=fast", "gateway-1=5:0", "gateway-1=-1"} {
+		if _, err := ParseLimits(v); err == nil {
+			t.Errorf("expected %q to be invalid", v)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/auth"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// ErrForbiddenSource is returned when the caller reports telemetry for a
+// source it does not own
+var ErrForbiddenSource = errors.New("not allowed to report for source")
+
+// authorizeSource checks that the authenticated caller of r owns source.
+// Requests are not restricted when authentication is disabled.
+func authorizeSource(r *http.Request, source string) error {
+	p, ok := auth.FromContext(r.Context())
+	if !ok || p.Owns(source) {
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrForbiddenSource, source)
+}
+
+// assignTenant stores telemetry reported by the authenticated caller of r
+// under the caller's tenant.  The tenant of the payload is kept when
+// authentication is disabled.
+func assignTenant(r *http.Request, t *models.Telemetry) {
+	if p, ok := auth.FromContext(r.Context()); ok {
+		t.Tenant = p.Tenant
+	}
+}
+
+// readableTenants returns the tenants the caller of r may read, or nil when
+// the caller may read every tenant.
+func readableTenants(r *http.Request) []string {
+	p, ok := auth.FromContext(r.Context())
+	if !ok {
+		return nil
+	}
+	return p.ReadableTenants()
+}
+
+// readableTenant reports whether the caller of r may read the objects of
+// tenant.  Requests are not restricted when authentication is disabled.
+func readableTenant(r *http.Request, tenant string) bool {
+	p, ok := auth.FromContext(r.Context())
+	return !ok || p.CanRead(tenant)
+}
+
+// readable returns the telemetry of ts the caller of r may read
+func readable(r *http.Request, ts []models.Telemetry) []models.Telemetry {
+	tenants := readableTenants(r)
+	if tenants == nil {
+		return ts
+	}
+	results := make([]models.Telemetry, 0, len(ts))
+	for _, t := range ts {
+		if t.InTenant(tenants) {
+			results = append(results, t)
+		}
+	}
+	return results
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"scbunn.org/tmp/gps-tracking-service/pkg/auth"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// withPrincipal returns r authenticated as a principal owning sources
+func withPrincipal(r *http.Request, sources ...string) *http.Request {
+	p := &auth.Principal{Subject: "test", Sources: sources}
+	return r.WithContext(auth.NewContext(r.Context(), p))
+}
+
+// withTenant returns r authenticated as a principal of tenant
+func withTenant(r *http.Request, tenant string) *http.Request {
+	p := &auth.Principal{Subject: "test", Tenant: tenant, Sources: []string{auth.AnySource}}
+	return r.WithContext(auth.NewContext(r.Context(), p))
+}
+
+func TestUpdateLocationSourceOwnership(t *testing.T) {
+	body := `{"source": "gateway-1", "objectId": "123", "position": {"latitude": 45, "longitude": -123}}`
+
+	tests := []struct {
+		name    string
+		sources []string
+		status  int
+	}{
+		{name: "Owner", sources: []string{"gateway-1"}, status: http.StatusCreated},
+		{name: "Wildcard", sources: []string{auth.AnySource}, status: http.StatusCreated},
+		{name: "OtherSource", sources: []string{"gateway-2"}, status: http.StatusForbidden},
+		{name: "NoSources", status: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := withPrincipal(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)), tt.sources...)
+
+			UpdateLocation(MockModel{}).ServeHTTP(w, r)
+			if status := w.Result().StatusCode; status != tt.status {
+				t.Errorf("expected %d status; got %d status", tt.status, status)
+			}
+		})
+	}
+}
+
+func TestBatchUpdateLocationSourceOwnership(t *testing.T) {
+	owned := `{"source": "gateway-1", "objectId": "1", "position": {"latitude": 45, "longitude": -123}}`
+	spoofed := `{"source": "gateway-2", "objectId": "2", "position": {"latitude": 45, "longitude": -123}}`
+
+	w := httptest.NewRecorder()
+	r := withPrincipal(httptest.NewRequest(http.MethodPost, "/", strings.NewReader("["+owned+","+spoofed+"]")), "gateway-1")
+
+	BatchUpdateLocation(MockModel{}).ServeHTTP(w, r)
+	var response batchResponse
+	if err := json.NewDecoder(w.Result().Body).Decode(&response); err != nil {
+		t.Fatalf("could not decode response: %s", err.Error())
+	}
+	if response.Accepted != 1 || response.Rejected != 1 || !strings.Contains(response.Results[1].Error, "gateway-2") {
+		t.Errorf("expected the spoofed update to be rejected; got %+v", response)
+	}
+}
+
+func TestUpdateLocationAssignsTenant(t *testing.T) {
+	body := `{"source": "gateway-1", "objectId": "123", "tenant": "spoofed", "position": {"latitude": 45, "longitude": -123}}`
+
+	w := httptest.NewRecorder()
+	r := withTenant(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)), "acme")
+
+	UpdateLocation(MockModel{}).ServeHTTP(w, r)
+	var response map[string]string
+	json.NewDecoder(w.Result().Body).Decode(&response)
+	if response["id"] != "acme:gateway-1-123" {
+		t.Errorf("expected the object to be keyed under the caller's tenant; got %q", response["id"])
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	mock := fixedModel{items: []models.Telemetry{
+		{Id: "acme:gw-1", Tenant: "acme"},
+		{Id: "acme:gw-2", Tenant: "acme"},
+		{Id: "globex:gw-1", Tenant: "globex"},
+	}}
+
+	w := httptest.NewRecorder()
+	GetAllLocations(mock).ServeHTTP(w, withTenant(httptest.NewRequest(http.MethodGet, "/", nil), "acme"))
+	var locations []models.Telemetry
+	json.NewDecoder(w.Result().Body).Decode(&locations)
+	if len(locations) != 2 {
+		t.Errorf("expected only the caller's tenant to be listed; got %d objects", len(locations))
+	}
+
+	w = httptest.NewRecorder()
+	r := withTenant(httptest.NewRequest(http.MethodGet, "/", nil), "acme")
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "globex:gw-1")
+	r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+	GetLocation(tenantModel{tenant: "globex"}).ServeHTTP(w, r)
+	if status := w.Result().StatusCode; status != http.StatusNotFound {
+		t.Errorf("expected objects of other tenants to be hidden; got %d status", status)
+	}
+}
+
+// tenantModel returns every object as belonging to tenant
+type tenantModel struct {
+	MockModel
+	tenant string
+}
+
+func (m tenantModel) Get(id string) (*models.Telemetry, error) {
+	t, err := m.MockModel.Get(id)
+	if err == nil {
+		t.Tenant = m.tenant
+	}
+	return t, err
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+const (
+	// MaxBatchSize is the most updates accepted in a single batch
+	MaxBatchSize = 1000
+
+	// maxBatchBytes caps the size of a batch request body
+	maxBatchBytes = 10 << 20
+)
+
+type batchResult struct {
+	Index  int                `json:"index"`
+	Id     string             `json:"id,omitempty"`
+	Error  string             `json:"error,omitempty"`
+	Fields models.FieldErrors `json:"fields,omitempty"`
+}
+
+// reject records err as the outcome of the update
+func (r *batchResult) reject(err error) {
+	r.Error = err.Error()
+	errors.As(err, &r.Fields)
+}
+
+type batchResponse struct {
+	Accepted int           `json:"accepted"`
+	Rejected int           `json:"rejected"`
+	Results  []batchResult `json:"results"`
+}
+
+// BatchUpdateLocation accepts a JSON array or an NDJSON stream of telemetry
+// updates.  Each update is decoded and validated on its own so one bad
+// update does not reject the rest of the batch; the response reports the
+// outcome of every update by its position in the batch.
+func BatchUpdateLocation(t models.TelemetryReaderWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBatchBytes)
+
+		items, err := splitBatch(r)
+		if err != nil {
+			status := http.StatusBadRequest
+			if tooLarge(err) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			renderError(w, status, fmt.Errorf("%w: %s", models.DecodeError, err))
+			return
+		}
+		if len(items) > MaxBatchSize {
+			renderError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("batch of %d updates exceeds the limit of %d", len(items), MaxBatchSize))
+			return
+		}
+
+		response := batchResponse{Results: make([]batchResult, len(items))}
+		var valid []models.Telemetry
+		var positions []int
+		for i, item := range items {
+			response.Results[i].Index = i
+
+			telemetry, err := models.DecodeTelemetry(item)
+			if err != nil {
+				response.Results[i].reject(err)
+				continue
+			}
+			if err := authorizeSource(r, telemetry.Source); err != nil {
+				response.Results[i].reject(err)
+				continue
+			}
+			assignTenant(r, &telemetry)
+			stamp(&telemetry)
+			valid = append(valid, telemetry)
+			positions = append(positions, i)
+		}
+
+		if len(valid) > 0 {
+			for j, result := range t.AddBatch(valid) {
+				i := positions[j]
+				if result.Err != nil {
+					response.Results[i].reject(result.Err)
+					continue
+				}
+				response.Results[i].Id = result.Id
+			}
+		}
+
+		for _, result := range response.Results {
+			if result.Error != "" {
+				response.Rejected++
+				continue
+			}
+			response.Accepted++
+		}
+		renderJSON(w, http.StatusOK, response)
+	}
+}
+
+// splitBatch returns the raw updates of a batch request.  A body starting
+// with '[' is decoded as a JSON array; anything else is treated as NDJSON
+// with one update per line.
+func splitBatch(r *http.Request) ([]json.RawMessage, error) {
+	body := bufio.NewReader(r.Body)
+	first, err := peekNonSpace(body)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if first == '[' && !strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-ndjson") {
+		var items []json.RawMessage
+		if err := json.NewDecoder(body).Decode(&items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+
+	var items []json.RawMessage
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxBatchBytes)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		items = append(items, json.RawMessage(append([]byte(nil), line...)))
+		if len(items) > MaxBatchSize {
+			break
+		}
+	}
+	return items, scanner.Err()
+}
+
+// tooLarge reports whether err is from reading past the size limit of a
+// request body.  Go 1.15 has no typed http.MaxBytesError, and http.MaxBytesReader
+// fails with an error created by errors.New, so it can only be told apart
+// by its message.  The message stays the same when the error is wrapped by
+// the decoders.
+func tooLarge(err error) bool {
+	return errors.Is(err, bufio.ErrTooLong) || strings.Contains(err.Error(), "http: request body too large")
+}
+
+// peekNonSpace returns the first non whitespace byte of r without
+// consuming it
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, r.UnreadByte()
+	}
+}
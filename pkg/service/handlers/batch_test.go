@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatchUpdateLocation(t *testing.T) {
+	valid := `{"source": "testing", "objectId": "123", "position": {"latitude": 45, "longitude": -123}}`
+	invalid := `{"source": "testing", "position": {"latitude": 45, "longitude": -123}}`
+
+	tests := []struct {
+		name        string
+		mock        MockModel
+		contentType string
+		body        string
+		status      int
+		accepted    int
+		rejected    int
+	}{
+		{name: "Array", body: "[" + valid + "," + valid + "]", status: http.StatusOK, accepted: 2},
+		{name: "ArrayPartiallyInvalid", body: "[" + valid + "," + invalid + ", 42]", status: http.StatusOK, accepted: 1, rejected: 2},
+		{name: "NDJSON", contentType: "application/x-ndjson", body: valid + "\n\n" + valid + "\n" + invalid + "\n{broken\n", status: http.StatusOK, accepted: 2, rejected: 2},
+		{name: "NDJSONWithoutContentType", body: valid + "\n" + valid, status: http.StatusOK, accepted: 2},
+		{name: "Empty", body: "", status: http.StatusOK},
+		{name: "EmptyArray", body: "[]", status: http.StatusOK},
+		{name: "MalformedArray", body: "[" + valid, status: http.StatusBadRequest},
+		{name: "TooLarge", body: "[" + strings.Repeat(valid+",", MaxBatchSize) + valid + "]", status: http.StatusRequestEntityTooLarge},
+		{name: "BodyTooLarge", body: "[" + valid + "," + strings.Repeat(" ", maxBatchBytes) + valid + "]", status: http.StatusRequestEntityTooLarge},
+		{name: "NDJSONBodyTooLarge", contentType: "application/x-ndjson", body: valid + "\n" + strings.Repeat(" ", maxBatchBytes) + "\n" + valid, status: http.StatusRequestEntityTooLarge},
+		{name: "OutOfRange", body: "[" + valid + "," + strings.Replace(valid, "-123", "-200", 1) + "]", status: http.StatusOK, accepted: 1, rejected: 1},
+		{name: "DatastoreError", mock: MockModel{Error: fmt.Errorf("bad thing")}, body: "[" + valid + "]", status: http.StatusOK, rejected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			BatchUpdateLocation(tt.mock).ServeHTTP(w, r)
+			rs := w.Result()
+			defer rs.Body.Close()
+			if rs.StatusCode != tt.status {
+				t.Fatalf("expected %d status; got %d status", tt.status, rs.StatusCode)
+			}
+			if rs.StatusCode != http.StatusOK {
+				return
+			}
+
+			var response batchResponse
+			if err := json.NewDecoder(rs.Body).Decode(&response); err != nil {
+				t.Fatalf("could not decode response: %s", err.Error())
+			}
+			if response.Accepted != tt.accepted || response.Rejected != tt.rejected {
+				t.Errorf("expected %d accepted and %d rejected; got %+v", tt.accepted, tt.rejected, response)
+			}
+			for i, result := range response.Results {
+				if result.Index != i {
+					t.Errorf("result %d reports index %d", i, result.Index)
+				}
+				if (result.Id == "") == (result.Error == "") {
+					t.Errorf("result %d should have exactly one of id or error: %+v", i, result)
+				}
+
+			}
+		})
+	}
+}
+
+func TestBatchReportsInvalidFields(t *testing.T) {
+	body := `[{"source": "testing", "objectId": "123", "position": {"latitude": 45, "longitude": -200}}]`
+	w := httptest.NewRecorder()
+	BatchUpdateLocation(MockModel{}).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+
+	var response batchResponse
+	if err := json.NewDecoder(w.Result().Body).Decode(&response); err != nil {
+		t.Fatalf("could not decode response: %s", err.Error())
+	}
+	fields := response.Results[0].Fields
+	if len(fields) != 1 || fields[0].Field != "position.longitude" {
+		t.Errorf("expected the invalid field to be reported; got %+v", fields)
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// wantsGeoJSON reports whether the client asked for GeoJSON, either with
+// ?format=geojson or by accepting application/geo+json
+func wantsGeoJSON(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return strings.EqualFold(format, "geojson")
+	}
+	return strings.Contains(r.Header.Get("Accept"), models.GeoJSONContentType)
+}
+
+func renderGeoJSON(w http.ResponseWriter, status int, data interface{}) {
+	render(w, status, models.GeoJSONContentType, data)
+}
+
+// The formats a track can be rendered in
+const (
+	formatJSON       = "json"
+	formatGeoJSON    = "geojson"
+	formatLineString = "linestring"
+	formatPolyline   = "polyline"
+	formatGPX        = "gpx"
+	formatKML        = "kml"
+)
+
+// trackMediaTypes are the formats of a track that can be negotiated with the
+// Accept header, by their media type
+var trackMediaTypes = []struct {
+	format    string
+	mediaType string
+}{
+	{formatGeoJSON, models.GeoJSONContentType},
+	{formatGPX, models.GPXContentType},
+	{formatKML, models.KMLContentType},
+}
+
+// trackEncoding is how the client asked a track to be rendered
+type trackEncoding struct {
+	format string
+
+	// precision is the number of decimal places kept by an encoded polyline
+	precision int
+}
+
+// trackQuery returns how the client asked a track to be rendered, either
+// with the format query parameter or by accepting the media type of a
+// format.  Tracks are rendered as JSON unless the client asks otherwise.
+func trackQuery(r *http.Request) (trackEncoding, error) {
+	params := r.URL.Query()
+	encoding := trackEncoding{format: formatJSON, precision: geo.DefaultPrecision}
+	if format := strings.ToLower(params.Get("format")); format != "" {
+		switch format {
+		case formatJSON, formatGeoJSON, formatLineString, formatPolyline, formatGPX, formatKML:
+			encoding.format = format
+		default:
+			return encoding, fmt.Errorf("invalid format: %q", format)
+		}
+	} else {
+		accept := r.Header.Get("Accept")
+		for _, t := range trackMediaTypes {
+			if strings.Contains(accept, t.mediaType) {
+				encoding.format = t.format
+				break
+			}
+		}
+	}
+
+	if v := params.Get("precision"); v != "" {
+		var err error
+		if encoding.precision, err = strconv.Atoi(v); err != nil || encoding.precision < 1 || encoding.precision > 7 {
+			return encoding, fmt.Errorf("invalid precision: %q", v)
+		}
+	}
+	return encoding, nil
+}
+
+// polylineResponse is a track as a Google encoded polyline
+type polylineResponse struct {
+	ObjectId  string `json:"objectId"`
+	Polyline  string `json:"polyline"`
+	Precision int    `json:"precision"`
+
+	// Times are the recorded times of the positions of the polyline in
+	// seconds since the Unix epoch
+	Times []int64 `json:"times"`
+}
+
+// renderTrack renders the track of the object id, ordered oldest to newest,
+// as encoding asks
+func renderTrack(w http.ResponseWriter, status int, id string, encoding trackEncoding, track []models.Telemetry) {
+	switch encoding.format {
+	case formatGeoJSON:
+		renderGeoJSON(w, status, models.NewFeatureCollection(track))
+	case formatLineString:
+		renderGeoJSON(w, status, models.NewLineString(id, track))
+	case formatPolyline:
+		points := make([]geo.Point, len(track))
+		times := make([]int64, len(track))
+		for i, t := range track {
+			points[i] = t.Position.Point()
+			times[i] = t.RecordedAt.Unix()
+		}
+		renderJSON(w, status, polylineResponse{
+			ObjectId:  id,
+			Polyline:  geo.EncodePolyline(points, encoding.precision),
+			Precision: encoding.precision,
+			Times:     times,
+		})
+	case formatGPX:
+		renderExport(w, status, models.GPXContentType, id+".gpx", models.NewGPX(id, track))
+	case formatKML:
+		renderExport(w, status, models.KMLContentType, id+".kml", models.NewKML(id, track))
+	default:
+		renderJSON(w, status, track)
+	}
+}
+
+// renderExport renders data as an XML document to be saved as filename
+func renderExport(w http.ResponseWriter, status int, contentType, filename string, data interface{}) {
+	response, err := xml.Marshal(data)
+	if err != nil {
+		renderError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	write(w, status, contentType, append([]byte(xml.Header), response...))
+}
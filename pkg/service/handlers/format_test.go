@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+func TestWantsGeoJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		accept string
+		want   bool
+	}{
+		{name: "Default", url: "/", want: false},
+		{name: "FormatParam", url: "/?format=geojson", want: true},
+		{name: "FormatParamJSON", url: "/?format=json", accept: models.GeoJSONContentType, want: false},
+		{name: "AcceptHeader", url: "/", accept: "application/geo+json, application/json;q=0.5", want: true},
+		{name: "AcceptJSON", url: "/", accept: "application/json", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			if got := wantsGeoJSON(r); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeoJSONResponses(t *testing.T) {
+	mock := MockModel{GetAllSize: 3, HistorySize: 3}
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		url      string
+		features int
+	}{
+		{name: "Location", handler: GetLocation(mock), url: "/"},
+		{name: "Listing", handler: GetAllLocations(mock), url: "/", features: 3},
+		{name: "EmptyListing", handler: GetAllLocations(MockModel{}), url: "/", features: 0},
+		{name: "Nearby", handler: GetNearbyLocations(mock), url: "/?lat=0&lon=0&radius=10", features: 3},
+		{name: "History", handler: GetLocationHistory(mock), url: "/", features: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := withID(httptest.NewRequest(http.MethodGet, tt.url, nil), "0001")
+			r.Header.Set("Accept", models.GeoJSONContentType)
+
+			tt.handler.ServeHTTP(w, r)
+			rs := w.Result()
+			defer rs.Body.Close()
+
+			if rs.StatusCode != http.StatusOK {
+				t.Fatalf("expected %d status; got %d status", http.StatusOK, rs.StatusCode)
+			}
+			if ct := rs.Header.Get("Content-Type"); ct != models.GeoJSONContentType {
+				t.Errorf("expected %s content; got %s", models.GeoJSONContentType, ct)
+			}
+
+			var doc struct {
+				Type     string           `json:"type"`
+				Features []models.Feature `json:"features"`
+				Geometry models.Geometry  `json:"geometry"`
+			}
+			if err := json.NewDecoder(rs.Body).Decode(&doc); err != nil {
+				t.Fatalf("could not decode response: %s", err.Error())
+			}
+
+			if tt.name == "Location" {
+				if doc.Type != "Feature" || doc.Geometry.Type != "Point" {
+					t.Errorf("expected a Point feature; got %+v", doc)
+				}
+				return
+			}
+			if doc.Type != "FeatureCollection" || len(doc.Features) != tt.features {
+				t.Errorf("expected a collection of %d features; got %+v", tt.features, doc)
+			}
+		})
+	}
+}
+
+func TestTrackFormats(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		accept      string
+		status      int
+		contentType string
+		contains    string
+	}{
+		{name: "Default", status: http.StatusOK, contentType: "application/json", contains: `"position"`},
+		{name: "LineString", query: "?format=linestring", status: http.StatusOK, contentType: models.GeoJSONContentType, contains: `"LineString"`},
+		{name: "Polyline", query: "?format=polyline", status: http.StatusOK, contentType: "application/json", contains: `"precision":5`},
+		{name: "Polyline6", query: "?format=polyline&precision=6", status: http.StatusOK, contentType: "application/json", contains: `"precision":6`},
+		{name: "GPX", query: "?format=gpx", status: http.StatusOK, contentType: models.GPXContentType, contains: "<trkpt"},
+		{name: "KML", query: "?format=KML", status: http.StatusOK, contentType: models.KMLContentType, contains: "<gx:coord>"},
+		{name: "AcceptGPX", accept: models.GPXContentType, status: http.StatusOK, contentType: models.GPXContentType, contains: "<gpx"},
+		{name: "AcceptKML", accept: models.KMLContentType + ", */*", status: http.StatusOK, contentType: models.KMLContentType, contains: "<kml"},
+		{name: "InvalidFormat", query: "?format=shapefile", status: http.StatusBadRequest},
+		{name: "InvalidPrecision", query: "?format=polyline&precision=12", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := withID(httptest.NewRequest(http.MethodGet, "/"+tt.query, nil), "0001")
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+
+			GetLocationHistory(MockModel{HistorySize: 3}).ServeHTTP(w, r)
+			rs := w.Result()
+			defer rs.Body.Close()
+			if rs.StatusCode != tt.status {
+				t.Fatalf("expected %d status; got %d status", tt.status, rs.StatusCode)
+			}
+			if rs.StatusCode != http.StatusOK {
+				return
+			}
+			if ct := rs.Header.Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("expected %s content; got %s", tt.contentType, ct)
+			}
+			if body := w.Body.String(); !strings.Contains(body, tt.contains) {
+				t.Errorf("expected the response to contain %s; got %s", tt.contains, body)
+			}
+		})
+	}
+}
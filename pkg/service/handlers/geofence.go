@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+func CreateGeofence(f models.GeofenceWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var fence models.Geofence
+		if err := fence.FromJSON(r); err != nil {
+			renderError(w, http.StatusBadRequest, err)
+			return
+		}
+		fence.Id = uuid.New().String()
+
+		if _, err := f.AddGeofence(fence); err != nil {
+			renderError(w, http.StatusInternalServerError, err)
+			return
+		}
+		renderJSON(w, http.StatusCreated, fence)
+	}
+}
+
+func GetGeofence(f models.GeofenceReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		fence, err := readableFence(r, f, id)
+		if err != nil {
+			renderStoreError(w, err)
+			return
+		}
+		renderJSON(w, http.StatusOK, fence)
+	}
+}
+
+func GetAllGeofences(f models.GeofenceReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var fences []models.Geofence
+		for _, fence := range f.GetAllGeofences() {
+			if fence.Tenant == "" || readableTenant(r, fence.Tenant) {
+				fences = append(fences, fence)
+			}
+		}
+		if len(fences) == 0 {
+			renderJSON(w, http.StatusOK, []string{})
+			return
+		}
+		renderJSON(w, http.StatusOK, fences)
+	}
+}
+
+func UpdateGeofence(f models.GeofenceReaderWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if _, err := f.GetGeofence(id); err != nil {
+			renderStoreError(w, err)
+			return
+		}
+
+		var fence models.Geofence
+		if err := fence.FromJSON(r); err != nil {
+			renderError(w, http.StatusBadRequest, err)
+			return
+		}
+		fence.Id = id
+
+		if _, err := f.AddGeofence(fence); err != nil {
+			renderError(w, http.StatusInternalServerError, err)
+			return
+		}
+		renderJSON(w, http.StatusOK, fence)
+	}
+}
+
+func DeleteGeofence(f models.GeofenceWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if err := f.DeleteGeofence(id); err != nil {
+			renderStoreError(w, err)
+			return
+		}
+		renderJSON(w, http.StatusOK, map[string]string{"message": "deleted", "id": id})
+	}
+}
+
+func GetGeofenceEvents(f models.GeofenceReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		q, err := historyQuery(r)
+		if err != nil {
+			renderError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if _, err := readableFence(r, f, id); err != nil {
+			renderStoreError(w, err)
+			return
+		}
+		events, err := f.GeofenceEvents(id, q)
+		if err != nil {
+			renderStoreError(w, err)
+			return
+		}
+		// shared fences are crossed by objects of every tenant
+		readable := make([]models.GeofenceEvent, 0, len(events))
+		for _, e := range events {
+			if readableTenant(r, e.Tenant) {
+				readable = append(readable, e)
+			}
+		}
+		renderJSON(w, http.StatusOK, readable)
+	}
+}
+
+// readableFence returns the fence with the passed id if the caller of r may
+// read it.  Fences of other tenants are indistinguishable from missing ones.
+func readableFence(r *http.Request, f models.GeofenceReader, id string) (*models.Geofence, error) {
+	fence, err := f.GetGeofence(id)
+	if err != nil {
+		return nil, err
+	}
+	if fence.Tenant != "" && !readableTenant(r, fence.Tenant) {
+		return nil, models.ErrNoRecord
+	}
+	return fence, nil
+}
+
+// renderStoreError renders a missing record as not found and any other
+// datastore error as an internal server error
+func renderStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, models.ErrNoRecord) {
+		renderError(w, http.StatusNotFound, err)
+		return
+	}
+	renderError(w, http.StatusInternalServerError, err)
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+type MockGeofences struct {
+	Error  error
+	Fences int
+
+	// Tenant is the tenant of the fences; events are of both acme and globex
+	Tenant string
+}
+
+func (m MockGeofences) fence(id string) models.Geofence {
+	return models.Geofence{
+		Id:     id,
+		Name:   "ci test fence",
+		Type:   models.CircleGeofence,
+		Center: &geo.Point{Lat: 45.5, Lon: -122.6},
+		Radius: 100,
+		Tenant: m.Tenant,
+	}
+}
+
+func (m MockGeofences) GetGeofence(id string) (*models.Geofence, error) {
+	if m.Error != nil {
+		return nil, m.Error
+	}
+	f := m.fence(id)
+	return &f, nil
+}
+
+func (m MockGeofences) GetAllGeofences() []models.Geofence {
+	var results []models.Geofence
+	for i := 0; i < m.Fences; i++ {
+		results = append(results, m.fence(fmt.Sprintf("%d", i)))
+	}
+	return results
+}
+
+func (m MockGeofences) GeofenceEvents(id string, q models.HistoryQuery) ([]models.GeofenceEvent, error) {
+	if m.Error != nil {
+		return nil, m.Error
+	}
+	return []models.GeofenceEvent{
+		{GeofenceId: id, ObjectId: "acme:0001", Type: models.GeofenceEnter, Tenant: "acme"},
+		{GeofenceId: id, ObjectId: "globex:0002", Type: models.GeofenceEnter, Tenant: "globex"},
+	}, nil
+}
+
+func (m MockGeofences) AddGeofence(f models.Geofence) (string, error) {
+	if m.Error != nil {
+		return "", m.Error
+	}
+	return f.Id, nil
+}
+
+func (m MockGeofences) DeleteGeofence(id string) error {
+	return m.Error
+}
+
+func (m MockGeofences) AddGeofenceEvent(e models.GeofenceEvent) error {
+	return m.Error
+}
+
+func withID(r *http.Request, id string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestCreateGeofence(t *testing.T) {
+	tests := []struct {
+		name   string
+		mock   MockGeofences
+		body   string
+		status int
+	}{
+		{name: "Circle", body: `{"name": "depot", "type": "circle", "center": {"latitude": 45.5, "longitude": -122.6}, "radius": 100}`, status: http.StatusCreated},
+		{name: "Polygon", body: `{"name": "yard", "type": "polygon", "polygon": {"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}}`, status: http.StatusCreated},
+		{name: "CircleWithoutRadius", body: `{"name": "depot", "type": "circle", "center": {"latitude": 45.5, "longitude": -122.6}}`, status: http.StatusBadRequest},
+		{name: "PolygonWithoutPolygon", body: `{"name": "yard", "type": "polygon"}`, status: http.StatusBadRequest},
+		{name: "UnknownType", body: `{"name": "yard", "type": "square"}`, status: http.StatusBadRequest},
+		{name: "InvalidJSON", body: `{"name": `, status: http.StatusBadRequest},
+		{name: "InternalError", mock: MockGeofences{Error: fmt.Errorf("bad thing")}, body: `{"name": "depot", "type": "circle", "center": {"latitude": 45.5, "longitude": -122.6}, "radius": 100}`, status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+
+			CreateGeofence(tt.mock).ServeHTTP(w, r)
+			rs := w.Result()
+			defer rs.Body.Close()
+			if rs.StatusCode != tt.status {
+				t.Errorf("expected %d status; got %d status", tt.status, rs.StatusCode)
+			}
+		})
+	}
+}
+
+func TestGeofenceByID(t *testing.T) {
+	body := `{"name": "depot", "type": "circle", "center": {"latitude": 45.5, "longitude": -122.6}, "radius": 100}`
+	tests := []struct {
+		name    string
+		handler func(MockGeofences) http.HandlerFunc
+		method  string
+		body    string
+		mock    MockGeofences
+		status  int
+	}{
+		{name: "Get", handler: func(m MockGeofences) http.HandlerFunc { return GetGeofence(m) }, method: http.MethodGet, status: http.StatusOK},
+		{name: "GetNotFound", handler: func(m MockGeofences) http.HandlerFunc { return GetGeofence(m) }, method: http.MethodGet, mock: MockGeofences{Error: models.ErrNoRecord}, status: http.StatusNotFound},
+		{name: "Update", handler: func(m MockGeofences) http.HandlerFunc { return UpdateGeofence(m) }, method: http.MethodPut, body: body, status: http.StatusOK},
+		{name: "UpdateInvalid", handler: func(m MockGeofences) http.HandlerFunc { return UpdateGeofence(m) }, method: http.MethodPut, body: `{}`, status: http.StatusBadRequest},
+		{name: "UpdateNotFound", handler: func(m MockGeofences) http.HandlerFunc { return UpdateGeofence(m) }, method: http.MethodPut, body: body, mock: MockGeofences{Error: models.ErrNoRecord}, status: http.StatusNotFound},
+		{name: "Delete", handler: func(m MockGeofences) http.HandlerFunc { return DeleteGeofence(m) }, method: http.MethodDelete, status: http.StatusOK},
+		{name: "DeleteNotFound", handler: func(m MockGeofences) http.HandlerFunc { return DeleteGeofence(m) }, method: http.MethodDelete, mock: MockGeofences{Error: models.ErrNoRecord}, status: http.StatusNotFound},
+		{name: "Events", handler: func(m MockGeofences) http.HandlerFunc { return GetGeofenceEvents(m) }, method: http.MethodGet, status: http.StatusOK},
+		{name: "EventsNotFound", handler: func(m MockGeofences) http.HandlerFunc { return GetGeofenceEvents(m) }, method: http.MethodGet, mock: MockGeofences{Error: models.ErrNoRecord}, status: http.StatusNotFound},
+		{name: "EventsInternalError", handler: func(m MockGeofences) http.HandlerFunc { return GetGeofenceEvents(m) }, method: http.MethodGet, mock: MockGeofences{Error: fmt.Errorf("bad thing")}, status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := withID(httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body)), "depot")
+
+			tt.handler(tt.mock).ServeHTTP(w, r)
+			rs := w.Result()
+			defer rs.Body.Close()
+			if rs.StatusCode != tt.status {
+				t.Errorf("expected %d status; got %d status", tt.status, rs.StatusCode)
+			}
+		})
+	}
+}
+
+func TestGetAllGeofences(t *testing.T) {
+	for _, size := range []int{0, 1, 10} {
+		t.Run(fmt.Sprintf("%dFences", size), func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			GetAllGeofences(MockGeofences{Fences: size}).ServeHTTP(w, r)
+			rs := w.Result()
+			defer rs.Body.Close()
+			if rs.StatusCode != http.StatusOK {
+				t.Errorf("expected %d status; got %d status", http.StatusOK, rs.StatusCode)
+			}
+		})
+	}
+}
+
+func TestGeofenceTenants(t *testing.T) {
+	tests := []struct {
+		name   string
+		mock   MockGeofences
+		status int
+		events []string
+	}{
+		{name: "SharedFence", status: http.StatusOK, events: []string{"acme:0001"}},
+		{name: "OwnFence", mock: MockGeofences{Tenant: "acme"}, status: http.StatusOK, events: []string{"acme:0001"}},
+		{name: "OtherTenantFence", mock: MockGeofences{Tenant: "globex"}, status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := withTenant(withID(httptest.NewRequest(http.MethodGet, "/", nil), "depot"), "acme")
+
+			GetGeofenceEvents(tt.mock).ServeHTTP(w, r)
+			rs := w.Result()
+			defer rs.Body.Close()
+			if rs.StatusCode != tt.status {
+				t.Fatalf("expected %d status; got %d status", tt.status, rs.StatusCode)
+			}
+			if rs.StatusCode != http.StatusOK {
+				return
+			}
+
+			var events []models.GeofenceEvent
+			if err := json.NewDecoder(rs.Body).Decode(&events); err != nil {
+				t.Fatalf("could not decode events: %s", err.Error())
+			}
+			if len(events) != len(tt.events) || events[0].ObjectId != tt.events[0] {
+				t.Errorf("expected only the events of the reader's tenant; got %+v", events)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		fenceTenant string
+		status      int
+		fences      int
+	}{{"acme", http.StatusOK, 2}, {"globex", http.StatusNotFound, 0}} {
+		w := httptest.NewRecorder()
+		r := withTenant(withID(httptest.NewRequest(http.MethodGet, "/", nil), "depot"), "acme")
+		GetGeofence(MockGeofences{Tenant: tt.fenceTenant}).ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s fence: expected %d status; got %d status", tt.fenceTenant, tt.status, w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		GetAllGeofences(MockGeofences{Fences: 2, Tenant: tt.fenceTenant}).ServeHTTP(w, r)
+		var fences []models.Geofence
+		json.NewDecoder(w.Body).Decode(&fences)
+		if len(fences) != tt.fences {
+			t.Errorf("%s fences: expected %d listed; got %d", tt.fenceTenant, tt.fences, len(fences))
+		}
+	}
+}
@@ -2,11 +2,15 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
 	"scbunn.org/tmp/gps-tracking-service/pkg/models"
 )
 
@@ -14,17 +18,170 @@ func GetLocation(t models.TelemetryReader) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
 		location, err := t.Get(id)
+		if err == nil && !location.InTenant(readableTenants(r)) {
+			// objects of other tenants are indistinguishable from missing ones
+			err = models.ErrNoRecord
+		}
 		if err != nil {
 			renderError(w, http.StatusNotFound, err)
 			return
 		}
+		if wantsGeoJSON(r) {
+			renderGeoJSON(w, http.StatusOK, location.Feature())
+			return
+		}
 		renderJSON(w, http.StatusOK, location)
 	}
 }
 
+func GetLocationHistory(t models.TelemetryReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		q, err := historyQuery(r)
+		if err != nil {
+			renderError(w, http.StatusBadRequest, err)
+			return
+		}
+		simplify, tolerance, err := simplifyQuery(r)
+		if err != nil {
+			renderError(w, http.StatusBadRequest, err)
+			return
+		}
+		encoding, err := trackQuery(r)
+		if err != nil {
+			renderError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		track, err := t.History(id, q)
+		if err == nil && len(track) > 0 {
+			if track = readable(r, track); len(track) == 0 {
+				err = models.ErrNoRecord
+			}
+		}
+		if err != nil {
+			renderStoreError(w, err)
+			return
+		}
+		if simplify != nil {
+			track = simplifyTrack(track, simplify, tolerance)
+		}
+		renderTrack(w, http.StatusOK, id, encoding, track)
+	}
+}
+
+// historyQuery builds a history query from the from, to, and limit query
+// parameters of the request.  Times are expected in RFC3339 format.
+func historyQuery(r *http.Request) (models.HistoryQuery, error) {
+	var q models.HistoryQuery
+	var err error
+	params := r.URL.Query()
+
+	if v := params.Get("from"); v != "" {
+		if q.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if v := params.Get("to"); v != "" {
+		if q.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 0 {
+			return q, fmt.Errorf("invalid limit: %q", v)
+		}
+	}
+	return q, nil
+}
+
+func GetNearbyLocations(t models.SpatialReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		center, radius, err := nearbyQuery(r)
+		if err != nil {
+			renderError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		results, err := t.Nearby(center, radius)
+		if err != nil {
+			renderError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if tenants := readableTenants(r); tenants != nil {
+			allowed := results[:0]
+			for _, result := range results {
+				if result.InTenant(tenants) {
+					allowed = append(allowed, result)
+				}
+			}
+			results = allowed
+		}
+		if wantsGeoJSON(r) {
+			features := make([]models.Feature, 0, len(results))
+			for _, result := range results {
+				features = append(features, result.Feature())
+			}
+			renderGeoJSON(w, http.StatusOK, models.FeatureCollection{Type: "FeatureCollection", Features: features})
+			return
+		}
+		renderJSON(w, http.StatusOK, results)
+	}
+}
+
+// nearbyQuery reads the lat, lon, and radius (in meters) query parameters
+// of the request.
+func nearbyQuery(r *http.Request) (geo.Point, float64, error) {
+	params := r.URL.Query()
+	var center geo.Point
+
+	lat, err := strconv.ParseFloat(params.Get("lat"), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return center, 0, fmt.Errorf("invalid lat: %q", params.Get("lat"))
+	}
+	lon, err := strconv.ParseFloat(params.Get("lon"), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return center, 0, fmt.Errorf("invalid lon: %q", params.Get("lon"))
+	}
+	radius, err := strconv.ParseFloat(params.Get("radius"), 64)
+	if err != nil || radius <= 0 {
+		return center, 0, fmt.Errorf("invalid radius: %q", params.Get("radius"))
+	}
+
+	center = geo.Point{Lat: lat, Lon: lon}
+	return center, radius, nil
+}
+
 func GetAllLocations(t models.TelemetryReader) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		locations := t.GetAll()
+		q, err := listQuery(r)
+		if err != nil {
+			renderError(w, http.StatusBadRequest, err)
+			return
+		}
+		q.Tenants = readableTenants(r)
+
+		page, err := t.Find(q)
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidCursor) {
+				renderError(w, http.StatusBadRequest, err)
+				return
+			}
+			renderError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if page.Next != "" {
+			next := r.URL.Query()
+			next.Set("cursor", page.Next)
+			w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, next.Encode()))
+		}
+
+		locations := page.Items
+		if wantsGeoJSON(r) {
+			renderGeoJSON(w, http.StatusOK, models.NewFeatureCollection(locations))
+			return
+		}
 		if len(locations) == 0 {
 			renderJSON(w, http.StatusOK, []string{})
 			return
@@ -33,19 +190,121 @@ func GetAllLocations(t models.TelemetryReader) http.HandlerFunc {
 	}
 }
 
+// listQuery builds a listing query from the area, source, status,
+// updatedSince, sort, limit, and cursor query parameters of the request.
+// Sorting by a field prefixed with '-' orders the results descending.
+func listQuery(r *http.Request) (models.Query, error) {
+	var q models.Query
+	var err error
+	params := r.URL.Query()
+
+	if q.Area, err = areaQuery(r); err != nil {
+		return q, err
+	}
+	q.Source = params.Get("source")
+	if v := params.Get("status"); v != "" {
+		if q.Status, err = models.ParseStatus(v); err != nil {
+			return q, fmt.Errorf("invalid status: %w", err)
+		}
+	}
+	q.Cursor = params.Get("cursor")
+
+	if v := params.Get("updatedSince"); v != "" {
+		if q.UpdatedSince, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, fmt.Errorf("invalid updatedSince: %w", err)
+		}
+	}
+
+	if v := params.Get("sort"); v != "" {
+		q.Descending = strings.HasPrefix(v, "-")
+		q.Sort = strings.TrimPrefix(v, "-")
+		if q.Sort != models.SortByID && q.Sort != models.SortByUpdated {
+			return q, fmt.Errorf("invalid sort: %q", v)
+		}
+	}
+
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 || q.Limit > models.MaxPageSize {
+			return q, fmt.Errorf("invalid limit: %q; must be between 1 and %d", v, models.MaxPageSize)
+		}
+	}
+	return q, nil
+}
+
+// areaQuery reads the optional bbox (minLat,minLon,maxLat,maxLon) or GeoJSON
+// polygon query parameter of the request.  A nil shape is returned when
+// neither is present.
+func areaQuery(r *http.Request) (geo.Shape, error) {
+	params := r.URL.Query()
+	bbox, polygon := params.Get("bbox"), params.Get("polygon")
+
+	switch {
+	case bbox != "" && polygon != "":
+		return nil, errors.New("bbox and polygon can not be combined")
+	case bbox != "":
+		return parseBBox(bbox)
+	case polygon != "":
+		return geo.ParsePolygon([]byte(polygon))
+	}
+	return nil, nil
+}
+
+func parseBBox(v string) (geo.BBox, error) {
+	var box geo.BBox
+	parts := strings.Split(v, ",")
+	if len(parts) != 4 {
+		return box, fmt.Errorf("invalid bbox: %q", v)
+	}
+
+	var values [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return box, fmt.Errorf("invalid bbox: %q", v)
+		}
+		values[i] = f
+	}
+
+	box = geo.BBox{MinLat: values[0], MinLon: values[1], MaxLat: values[2], MaxLon: values[3]}
+	if box.MinLat < -90 || box.MaxLat > 90 || box.MinLat > box.MaxLat ||
+		box.MinLon < -180 || box.MinLon > 180 || box.MaxLon < -180 || box.MaxLon > 180 {
+		return box, fmt.Errorf("invalid bbox: %q", v)
+	}
+	return box, nil
+}
+
+// maxUpdateBytes caps the size of a single update request body
+const maxUpdateBytes = 1 << 20
+
 func UpdateLocation(t models.TelemetryReaderWriter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		now := time.Now()
+		r.Body = http.MaxBytesReader(w, r.Body, maxUpdateBytes)
 		var telemetry models.Telemetry
 
 		if err := telemetry.FromJSON(r); err != nil {
-			renderError(w, http.StatusBadRequest, err)
+			if tooLarge(err) {
+				renderError(w, http.StatusRequestEntityTooLarge, err)
+				return
+			}
+			renderFieldErrors(w, http.StatusBadRequest, err)
 			return
 		}
-		telemetry.Updated = now
-		telemetry.Id = fmt.Sprintf("%s-%s", telemetry.Source, telemetry.ObjectID)
+		if err := authorizeSource(r, telemetry.Source); err != nil {
+			renderError(w, http.StatusForbidden, err)
+			return
+		}
+		assignTenant(r, &telemetry)
+		stamp(&telemetry)
 
 		id, err := t.Add(telemetry)
+		if errors.Is(err, models.ValidationError) {
+			renderFieldErrors(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		if errors.Is(err, models.ErrStaleUpdate) {
+			renderError(w, http.StatusConflict, err)
+			return
+		}
 		if err != nil {
 			renderError(w, http.StatusInternalServerError, err)
 			return
@@ -55,23 +314,62 @@ func UpdateLocation(t models.TelemetryReaderWriter) http.HandlerFunc {
 	}
 }
 
+// stamp assigns the datastore id of an update.  Objects of a tenant are
+// keyed under the tenant so fleets never collide.  The receive time is
+// stamped by the ingest pipeline once the update passes its checks.
+func stamp(t *models.Telemetry) {
+	t.Id = fmt.Sprintf("%s-%s", t.Source, t.ObjectID)
+	if t.Tenant != "" {
+		t.Id = t.Tenant + ":" + t.Id
+	}
+}
+
 func renderJSON(w http.ResponseWriter, status int, data interface{}) {
+	render(w, status, "application/json", data)
+}
+
+func render(w http.ResponseWriter, status int, contentType string, data interface{}) {
 	response, err := json.Marshal(data)
 	if err != nil {
 		renderError(w, http.StatusInternalServerError, err)
 		return
 	}
+	write(w, status, contentType, response)
+}
 
-	w.Header().Set("Content-Type", "application/json")
+func write(w http.ResponseWriter, status int, contentType string, response []byte) {
+	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept")
 	w.WriteHeader(status)
-	if _, err = w.Write(response); err != nil {
+	if _, err := w.Write(response); err != nil {
 		renderError(w, http.StatusInternalServerError, err)
 		return
 	}
 }
 
+// renderFieldErrors renders err like renderError, adding the invalid fields
+// when err is a models.FieldErrors
+func renderFieldErrors(w http.ResponseWriter, status int, err error) {
+	var fields models.FieldErrors
+	if !errors.As(err, &fields) {
+		renderError(w, status, err)
+		return
+	}
+
+	render(w, status, "application/json", struct {
+		Error   string             `json:"error"`
+		Status  string             `json:"status"`
+		Message string             `json:"message"`
+		Fields  models.FieldErrors `json:"fields"`
+	}{
+		Error:   err.Error(),
+		Status:  fmt.Sprintf("%d", status),
+		Message: "an error has occured",
+		Fields:  fields,
+	})
+}
+
 func renderError(w http.ResponseWriter, status int, err error) {
 	data := map[string]string{
 		"error":   err.Error(),
@@ -2,21 +2,25 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 
 	"github.com/go-chi/chi"
 	"github.com/google/uuid"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
 	"scbunn.org/tmp/gps-tracking-service/pkg/models"
 )
 
 type MockModel struct {
-	Error      error
-	GetAllSize int
+	Error       error
+	GetAllSize  int
+	HistorySize int
 }
 
 func (m MockModel) NewTelemetry() *models.Telemetry {
@@ -39,6 +43,14 @@ func (m MockModel) Add(t models.Telemetry) (string, error) {
 	return t.Id, nil
 }
 
+func (m MockModel) AddBatch(ts []models.Telemetry) []models.WriteResult {
+	results := make([]models.WriteResult, len(ts))
+	for i, t := range ts {
+		results[i].Id, results[i].Err = m.Add(t)
+	}
+	return results
+}
+
 func (m MockModel) Get(id string) (*models.Telemetry, error) {
 	if m.Error != nil {
 		return nil, models.ErrNoRecord
@@ -59,6 +71,52 @@ func (m MockModel) GetAll() []models.Telemetry {
 	return results
 }
 
+func (m MockModel) History(id string, q models.HistoryQuery) ([]models.Telemetry, error) {
+	if m.Error != nil {
+		return nil, m.Error
+	}
+	var results []models.Telemetry
+	for i := 0; i < m.HistorySize; i++ {
+		t := m.NewTelemetry()
+		t.Id = id
+		results = append(results, *t)
+	}
+	return q.Apply(results), nil
+}
+
+func (m MockModel) Find(q models.Query) (models.Page, error) {
+	if m.Error != nil {
+		return models.Page{}, m.Error
+	}
+	return q.Apply(m.GetAll())
+}
+
+func (m MockModel) Nearby(center geo.Point, radius float64) ([]models.Proximity, error) {
+	if m.Error != nil {
+		return nil, m.Error
+	}
+	results := []models.Proximity{}
+	for i := 0; i < m.GetAllSize; i++ {
+		t := m.NewTelemetry()
+		t.Id = uuid.New().String()
+		results = append(results, models.Proximity{Telemetry: *t, Distance: float64(i)})
+	}
+	return results, nil
+}
+
+func (m MockModel) Within(area geo.Shape) ([]models.Telemetry, error) {
+	if m.Error != nil {
+		return nil, m.Error
+	}
+	var results []models.Telemetry
+	for _, t := range m.GetAll() {
+		if area.Contains(t.Position.Point()) {
+			results = append(results, t)
+		}
+	}
+	return results, nil
+}
+
 func TestGetLocation(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -91,21 +149,137 @@ func TestGetLocation(t *testing.T) {
 
 }
 
+func TestGetLocationHistory(t *testing.T) {
+	tests := []struct {
+		name   string
+		mock   MockModel
+		query  string
+		status int
+		size   int
+	}{
+		{name: "ValidId", mock: MockModel{HistorySize: 10}, status: http.StatusOK, size: 10},
+		{name: "Limit", mock: MockModel{HistorySize: 10}, query: "?limit=3", status: http.StatusOK, size: 3},
+		{name: "TimeWindow", mock: MockModel{HistorySize: 10}, query: "?from=2020-01-01T00:00:00Z&to=2020-01-02T00:00:00Z", status: http.StatusOK, size: 0},
+		{name: "InvalidFrom", mock: MockModel{}, query: "?from=yesterday", status: http.StatusBadRequest},
+		{name: "InvalidLimit", mock: MockModel{}, query: "?limit=-1", status: http.StatusBadRequest},
+		// the mock track stays in one place, so only its ends are kept
+		{name: "DouglasPeucker", mock: MockModel{HistorySize: 10}, query: "?simplify=douglas-peucker", status: http.StatusOK, size: 2},
+		{name: "Visvalingam", mock: MockModel{HistorySize: 10}, query: "?simplify=visvalingam&tolerance=5", status: http.StatusOK, size: 2},
+		{name: "InvalidSimplify", mock: MockModel{}, query: "?simplify=fast", status: http.StatusBadRequest},
+		{name: "InvalidTolerance", mock: MockModel{}, query: "?simplify=visvalingam&tolerance=-1", status: http.StatusBadRequest},
+		{name: "IdNotFound", mock: MockModel{Error: models.ErrNoRecord}, status: http.StatusNotFound},
+		{name: "InternalError", mock: MockModel{Error: fmt.Errorf("bad thing")}, status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/"+tt.query, nil)
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", uuid.New().String())
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+
+			GetLocationHistory(tt.mock).ServeHTTP(w, r)
+			rs := w.Result()
+			defer rs.Body.Close()
+			if rs.StatusCode != tt.status {
+				t.Errorf("expected %d status; got %d status", tt.status, rs.StatusCode)
+			}
+			if rs.StatusCode != http.StatusOK {
+				return
+			}
+
+			var track []models.Telemetry
+			if err := json.NewDecoder(rs.Body).Decode(&track); err != nil {
+				t.Fatalf("could not decode response: %s", err.Error())
+			}
+			if len(track) != tt.size {
+				t.Errorf("expected %d positions; got %d", tt.size, len(track))
+			}
+		})
+	}
+}
+
+func TestGetNearbyLocations(t *testing.T) {
+	tests := []struct {
+		name   string
+		mock   MockModel
+		query  string
+		status int
+	}{
+		{name: "Valid", mock: MockModel{GetAllSize: 3}, query: "?lat=45.5&lon=-122.6&radius=5000", status: http.StatusOK},
+		{name: "Equator", mock: MockModel{}, query: "?lat=0&lon=0&radius=1", status: http.StatusOK},
+		{name: "MissingLat", mock: MockModel{}, query: "?lon=-122.6&radius=5000", status: http.StatusBadRequest},
+		{name: "InvalidLon", mock: MockModel{}, query: "?lat=45.5&lon=-200&radius=5000", status: http.StatusBadRequest},
+		{name: "NegativeRadius", mock: MockModel{}, query: "?lat=45.5&lon=-122.6&radius=-1", status: http.StatusBadRequest},
+		{name: "InternalError", mock: MockModel{Error: fmt.Errorf("bad thing")}, query: "?lat=45.5&lon=-122.6&radius=5000", status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/"+tt.query, nil)
+
+			GetNearbyLocations(tt.mock).ServeHTTP(w, r)
+			rs := w.Result()
+			defer rs.Body.Close()
+			if rs.StatusCode != tt.status {
+				t.Errorf("expected %d status; got %d status", tt.status, rs.StatusCode)
+			}
+			if rs.StatusCode != http.StatusOK {
+				return
+			}
+
+			var results []map[string]interface{}
+			if err := json.NewDecoder(rs.Body).Decode(&results); err != nil {
+				t.Fatalf("could not decode response: %s", err.Error())
+			}
+			if len(results) != tt.mock.GetAllSize {
+				t.Fatalf("expected %d results; got %d", tt.mock.GetAllSize, len(results))
+			}
+			for _, result := range results {
+				if _, ok := result["distance"]; !ok {
+					t.Errorf("result is missing its distance: %v", result)
+				}
+			}
+		})
+	}
+}
+
 func TestGetAllLocations(t *testing.T) {
 	tests := []struct {
 		name   string
 		mock   MockModel
+		query  string
 		status int
 	}{
 		{name: "EmptyDatabase", mock: MockModel{}, status: http.StatusOK},
 		{name: "OneItem", mock: MockModel{GetAllSize: 1}, status: http.StatusOK},
 		{name: "HundredItems", mock: MockModel{GetAllSize: 100}, status: http.StatusOK},
+		{name: "BBox", mock: MockModel{GetAllSize: 10}, query: "?bbox=-90,-180,90,0", status: http.StatusOK},
+		{name: "BBoxEmpty", mock: MockModel{GetAllSize: 10}, query: "?bbox=0,0,10,10", status: http.StatusOK},
+		{name: "BBoxInvalid", mock: MockModel{}, query: "?bbox=0,0,10", status: http.StatusBadRequest},
+		{name: "BBoxInverted", mock: MockModel{}, query: "?bbox=10,0,0,10", status: http.StatusBadRequest},
+		{name: "Polygon", mock: MockModel{GetAllSize: 10}, query: "?polygon=" + url.QueryEscape(`{"type":"Polygon","coordinates":[[[-130,0],[-120,0],[-120,10],[-130,10],[-130,0]]]}`), status: http.StatusOK},
+		{name: "PolygonInvalid", mock: MockModel{}, query: "?polygon=" + url.QueryEscape(`{"type":"Point","coordinates":[0,0]}`), status: http.StatusBadRequest},
+		{name: "BBoxAndPolygon", mock: MockModel{}, query: "?bbox=0,0,10,10&polygon=x", status: http.StatusBadRequest},
+		{name: "InternalError", mock: MockModel{Error: fmt.Errorf("bad thing")}, query: "?bbox=0,0,10,10", status: http.StatusInternalServerError},
+		{name: "Filters", mock: MockModel{GetAllSize: 10}, query: "?source=gateway&status=moving&updatedSince=2020-01-01T00:00:00Z", status: http.StatusOK},
+		{name: "StatusIgnoresCase", mock: MockModel{GetAllSize: 10}, query: "?status=Moving", status: http.StatusOK},
+		{name: "UnknownStatus", mock: MockModel{}, query: "?status=parked", status: http.StatusBadRequest},
+		{name: "SortDescending", mock: MockModel{GetAllSize: 10}, query: "?sort=-updated", status: http.StatusOK},
+		{name: "InvalidSort", mock: MockModel{}, query: "?sort=name", status: http.StatusBadRequest},
+		{name: "InvalidLimit", mock: MockModel{}, query: "?limit=0", status: http.StatusBadRequest},
+		{name: "LimitTooLarge", mock: MockModel{}, query: fmt.Sprintf("?limit=%d", models.MaxPageSize+1), status: http.StatusBadRequest},
+		{name: "InvalidUpdatedSince", mock: MockModel{}, query: "?updatedSince=yesterday", status: http.StatusBadRequest},
+		{name: "InvalidCursor", mock: MockModel{GetAllSize: 10}, query: "?cursor=garbage", status: http.StatusBadRequest},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			w := httptest.NewRecorder()
-			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r := httptest.NewRequest(http.MethodGet, "/"+tt.query, nil)
 
 			GetAllLocations(tt.mock).ServeHTTP(w, r)
 			rs := w.Result()
@@ -119,16 +293,71 @@ func TestGetAllLocations(t *testing.T) {
 
 }
 
+// fixedModel serves Find from a fixed set of objects so that pages stay
+// stable between requests.
+type fixedModel struct {
+	MockModel
+	items []models.Telemetry
+}
+
+func (m fixedModel) Find(q models.Query) (models.Page, error) {
+	return q.Apply(m.items)
+}
+
+func TestGetAllLocationsPagination(t *testing.T) {
+	mock := fixedModel{items: MockModel{GetAllSize: 5}.GetAll()}
+	next := "/api/v1/location/?limit=2"
+	seen := 0
+
+	for pages := 1; next != ""; pages++ {
+		if pages > 3 {
+			t.Fatalf("expected 3 pages; still paging at %s", next)
+		}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, next, nil)
+
+		GetAllLocations(mock).ServeHTTP(w, r)
+		rs := w.Result()
+		defer rs.Body.Close()
+
+		var locations []models.Telemetry
+		if err := json.NewDecoder(rs.Body).Decode(&locations); err != nil {
+			t.Fatalf("unable to decode page %d: %s", pages, err.Error())
+		}
+		seen += len(locations)
+
+		next = ""
+		if link := rs.Header.Get("Link"); link != "" {
+			if !strings.HasPrefix(link, "</api/v1/location/?") || !strings.HasSuffix(link, `>; rel="next"`) {
+				t.Fatalf("unexpected Link header: %s", link)
+			}
+			next = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+		}
+	}
+
+	if seen != len(mock.items) {
+		t.Errorf("expected %d locations across all pages; got %d", len(mock.items), seen)
+	}
+}
+
 func TestUpdateLocation(t *testing.T) {
 	tests := []struct {
 		name   string
 		mock   MockModel
 		body   string
 		status int
+		fields []string
 	}{
-		{name: "InvalidJSON", mock: MockModel{}, body: `{"foo": "bar"}`, status: http.StatusBadRequest},
-		{name: "ValidRequest", mock: MockModel{}, body: `{"source": "testing", "objectId": "123", "position": {"latitude": 123, "longitude": -123}}`, status: http.StatusCreated},
-		{name: "InternalError", mock: MockModel{Error: fmt.Errorf("bad thing")}, body: `{"source": "testing", "objectId": "123", "position": {"latitude": 123, "longitude": -123}}`, status: http.StatusInternalServerError},
+		{name: "InvalidJSON", mock: MockModel{}, body: `{"foo": "bar"}`, status: http.StatusBadRequest, fields: []string{"position", "source", "objectId"}},
+		{name: "MalformedJSON", mock: MockModel{}, body: `{"foo": `, status: http.StatusBadRequest},
+		{name: "ValidRequest", mock: MockModel{}, body: `{"source": "testing", "objectId": "123", "position": {"latitude": 45, "longitude": -123}}`, status: http.StatusCreated},
+		{name: "NullIsland", mock: MockModel{}, body: `{"source": "testing", "objectId": "123", "position": {"latitude": 0, "longitude": 0}}`, status: http.StatusCreated},
+		{name: "LatitudeOutOfRange", mock: MockModel{}, body: `{"source": "testing", "objectId": "123", "position": {"latitude": 95, "longitude": -123}}`, status: http.StatusBadRequest, fields: []string{"position.latitude"}},
+		{name: "LongitudeOutOfRange", mock: MockModel{}, body: `{"source": "testing", "objectId": "123", "position": {"latitude": 45, "longitude": 500}}`, status: http.StatusBadRequest, fields: []string{"position.longitude"}},
+		{name: "MissingFields", mock: MockModel{}, body: `{"source": "testing", "position": {"longitude": 0}}`, status: http.StatusBadRequest, fields: []string{"position.latitude", "objectId"}},
+		{name: "Implausible", mock: MockModel{Error: models.FieldErrors{{Field: "position", Message: "implies a speed"}}}, body: `{"source": "testing", "objectId": "123", "position": {"latitude": 45, "longitude": -123}}`, status: http.StatusUnprocessableEntity, fields: []string{"position"}},
+		{name: "Stale", mock: MockModel{Error: models.ErrStaleUpdate}, body: `{"source": "testing", "objectId": "123", "recordedAt": "2020-01-01T00:00:00Z", "position": {"latitude": 45, "longitude": -123}}`, status: http.StatusConflict},
+		{name: "InternalError", mock: MockModel{Error: fmt.Errorf("bad thing")}, body: `{"source": "testing", "objectId": "123", "position": {"latitude": 45, "longitude": -123}}`, status: http.StatusInternalServerError},
 	}
 
 	for _, tt := range tests {
@@ -149,6 +378,19 @@ func TestUpdateLocation(t *testing.T) {
 			if rs.StatusCode != tt.status {
 				t.Errorf("expected %d status; got %d status", tt.status, rs.StatusCode)
 			}
+
+			var response struct {
+				Fields models.FieldErrors `json:"fields"`
+			}
+			json.Unmarshal(body, &response)
+			if len(response.Fields) != len(tt.fields) {
+				t.Fatalf("expected errors for fields %v; got %+v", tt.fields, response.Fields)
+			}
+			for i, field := range tt.fields {
+				if response.Fields[i].Field != field {
+					t.Errorf("expected an error for %s; got %+v", field, response.Fields[i])
+				}
+			}
 		})
 	}
 }
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// odometerResponse is the distance an object travelled during a window
+type odometerResponse struct {
+	ObjectId string `json:"objectId"`
+
+	// Start and End are the odometer readings, in meters, at the start and
+	// end of the window
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+
+	// Distance is how far, in meters, the object travelled in the window
+	Distance float64 `json:"distance"`
+}
+
+// ErrOutsideRetention is returned for odometer windows reaching past the
+// stored track
+var ErrOutsideRetention = errors.New("window reaches past the stored history")
+
+// GetOdometer returns the distance an object travelled between the from and
+// to times of the request.  The window starts at the last reading before
+// from, so the leg into the window is counted, and ends at the last reading
+// before to.  Readings are taken from the stored track of the object and its
+// latest telemetry, so a window without from starts at zero and a window
+// without to ends at the latest reading however little track is kept.
+// Windows ending before they start or reaching past the stored track are
+// rejected.
+func GetOdometer(t models.TelemetryReader, retention models.Retention) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		q, err := historyQuery(r)
+		if err != nil {
+			renderError(w, http.StatusBadRequest, err)
+			return
+		}
+		if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+			renderFieldErrors(w, http.StatusBadRequest, models.FieldErrors{{Field: "to", Message: "must not be before from"}})
+			return
+		}
+		now := time.Now()
+		if (!q.From.IsZero() && !retention.Covers(q.From, now)) || (!q.To.IsZero() && !retention.Covers(q.To, now)) {
+			renderError(w, http.StatusBadRequest, ErrOutsideRetention)
+			return
+		}
+
+		var track []models.Telemetry
+		if !q.From.IsZero() || !q.To.IsZero() {
+			if track, err = t.History(id, models.HistoryQuery{}); err != nil && !errors.Is(err, models.ErrNoRecord) {
+				renderStoreError(w, err)
+				return
+			}
+		}
+		readings := track
+		latest, err := t.Get(id)
+		switch {
+		case err == nil && (len(readings) == 0 || latest.Updated.After(readings[len(readings)-1].Updated)):
+			readings = append(readings, *latest)
+		case err != nil && !errors.Is(err, models.ErrNoRecord):
+			renderStoreError(w, err)
+			return
+		}
+		if len(readings) == 0 || !readableTenant(r, readings[len(readings)-1].Tenant) {
+			// objects of other tenants are indistinguishable from missing ones
+			renderStoreError(w, models.ErrNoRecord)
+			return
+		}
+
+		// before counts the readings updated at or before ts
+		before := func(ts time.Time) int {
+			return sort.Search(len(readings), func(i int) bool {
+				return readings[i].Updated.After(ts)
+			})
+		}
+		odometer := odometerResponse{ObjectId: id}
+		if !q.From.IsZero() {
+			switch i := before(q.From); {
+			case i > 0:
+				odometer.Start = readings[i-1].Odometer
+			case len(track) >= retention.Size:
+				// the readings before from were dropped from the track
+				renderError(w, http.StatusBadRequest, ErrOutsideRetention)
+				return
+			default:
+				// the object was first seen inside the window
+				odometer.Start = readings[0].Odometer
+			}
+		}
+		end := len(readings)
+		if !q.To.IsZero() {
+			end = before(q.To)
+		}
+		odometer.End = odometer.Start
+		if end > 0 {
+			odometer.End = readings[end-1].Odometer
+		}
+		odometer.Distance = odometer.End - odometer.Start
+		renderJSON(w, http.StatusOK, odometer)
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+var trackStart = time.Now().Add(-30 * time.Minute).Truncate(time.Minute)
+
+// MockTrack serves a track of the vehicle with a fix every minute, each
+// reading 100m further on the odometer.  The latest telemetry of the
+// vehicle is its last fix.
+type MockTrack struct {
+	MockModel
+	Tenant string
+
+	// NoHistory serves the latest telemetry without a track
+	NoHistory bool
+}
+
+func (m MockTrack) track() []models.Telemetry {
+	var track []models.Telemetry
+	for i := 0; i < 10; i++ {
+		track = append(track, models.Telemetry{
+			Id:       "vehicle",
+			Updated:  trackStart.Add(time.Duration(i) * time.Minute),
+			Odometer: float64(i * 100),
+			Tenant:   m.Tenant,
+		})
+	}
+	return track
+}
+
+func (m MockTrack) Get(id string) (*models.Telemetry, error) {
+	if m.Error != nil {
+		return nil, m.Error
+	}
+	track := m.track()
+	return &track[len(track)-1], nil
+}
+
+func (m MockTrack) History(id string, q models.HistoryQuery) ([]models.Telemetry, error) {
+	if m.Error != nil {
+		return nil, m.Error
+	}
+	if m.NoHistory {
+		return nil, models.ErrNoRecord
+	}
+	return q.Apply(m.track()), nil
+}
+
+// window returns the odometer query between minutes after the start of the
+// track, leaving out the bounds that are nil
+func window(from, to *int) string {
+	q := "?"
+	if from != nil {
+		q += "from=" + trackStart.Add(time.Duration(*from)*time.Minute+30*time.Second).Format(time.RFC3339) + "&"
+	}
+	if to != nil {
+		q += "to=" + trackStart.Add(time.Duration(*to)*time.Minute+30*time.Second).Format(time.RFC3339)
+	}
+	return q
+}
+
+func minute(m int) *int {
+	return &m
+}
+
+func TestGetOdometer(t *testing.T) {
+	retention := models.Retention{Size: 100, Age: time.Hour}
+	tests := []struct {
+		name      string
+		mock      MockTrack
+		retention models.Retention
+		query     string
+		tenant    string
+		status    int
+		field     string
+		start     float64
+		distance  float64
+	}{
+		{name: "WholeTrack", status: http.StatusOK, start: 0, distance: 900},
+		{name: "Window", query: window(minute(2), minute(5)), status: http.StatusOK, start: 200, distance: 300},
+		{name: "From", query: window(minute(8), nil), status: http.StatusOK, start: 800, distance: 100},
+		{name: "To", query: window(nil, minute(3)), status: http.StatusOK, start: 0, distance: 300},
+		{name: "Empty", query: window(minute(20), nil), status: http.StatusOK, start: 900, distance: 0},
+		{name: "FirstSeenInWindow", query: window(minute(-5), nil), status: http.StatusOK, start: 0, distance: 900},
+		{name: "NoHistory", mock: MockTrack{NoHistory: true}, retention: models.Retention{}, status: http.StatusOK, start: 0, distance: 900},
+		{name: "NoHistoryWindow", mock: MockTrack{NoHistory: true}, retention: models.Retention{}, query: window(minute(2), nil), status: http.StatusBadRequest},
+		{name: "OlderThanHistory", query: window(minute(-60), nil), status: http.StatusBadRequest},
+		{name: "TrimmedHistory", retention: models.Retention{Size: 10, Age: time.Hour}, query: window(minute(-5), nil), status: http.StatusBadRequest},
+		{name: "InvalidQuery", query: "?to=soon", status: http.StatusBadRequest},
+		{name: "Reversed", query: window(minute(5), minute(2)), status: http.StatusBadRequest, field: "to"},
+		{name: "NotFound", mock: MockTrack{MockModel: MockModel{Error: models.ErrNoRecord}}, status: http.StatusNotFound},
+		{name: "InternalError", mock: MockTrack{MockModel: MockModel{Error: fmt.Errorf("bad thing")}}, query: window(minute(2), nil), status: http.StatusInternalServerError},
+		{name: "OtherTenant", mock: MockTrack{Tenant: "globex"}, tenant: "acme", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := withID(httptest.NewRequest(http.MethodGet, "/"+tt.query, nil), "vehicle")
+			if tt.tenant != "" {
+				r = withTenant(r, tt.tenant)
+			}
+			if tt.retention == (models.Retention{}) && !tt.mock.NoHistory {
+				tt.retention = retention
+			}
+
+			GetOdometer(tt.mock, tt.retention).ServeHTTP(w, r)
+			rs := w.Result()
+			defer rs.Body.Close()
+			if rs.StatusCode != tt.status {
+				t.Fatalf("expected %d status; got %d status", tt.status, rs.StatusCode)
+			}
+			if tt.field != "" {
+				var invalid struct {
+					Fields models.FieldErrors `json:"fields"`
+				}
+				if err := json.NewDecoder(rs.Body).Decode(&invalid); err != nil || len(invalid.Fields) != 1 || invalid.Fields[0].Field != tt.field {
+					t.Errorf("expected an error for %s; got %+v", tt.field, invalid.Fields)
+				}
+			}
+			if rs.StatusCode != http.StatusOK {
+				return
+			}
+
+			var odometer odometerResponse
+			if err := json.NewDecoder(rs.Body).Decode(&odometer); err != nil {
+				t.Fatalf("could not decode odometer: %s", err.Error())
+			}
+			if odometer.ObjectId != "vehicle" || odometer.Start != tt.start || odometer.Distance != tt.distance {
+				t.Errorf("expected %fm from %f; got %+v", tt.distance, tt.start, odometer)
+			}
+		})
+	}
+}
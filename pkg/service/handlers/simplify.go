@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// DefaultTolerance is how far, in meters, a simplified track may stray from
+// the positions it leaves out when the request does not say
+const DefaultTolerance = 10
+
+// simplifiers are the track simplification algorithms by name
+var simplifiers = map[string]func([]geo.Point, float64) []int{
+	"douglas-peucker": geo.DouglasPeucker,
+	"visvalingam":     geo.Visvalingam,
+}
+
+// simplifyQuery returns the simplification algorithm and tolerance, in
+// meters, from the simplify and tolerance query parameters of the request.
+// The algorithm is nil when the track should be returned as stored.
+func simplifyQuery(r *http.Request) (func([]geo.Point, float64) []int, float64, error) {
+	params := r.URL.Query()
+	name := params.Get("simplify")
+	if name == "" {
+		return nil, 0, nil
+	}
+	simplify, ok := simplifiers[name]
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid simplify: %q", name)
+	}
+
+	tolerance := float64(DefaultTolerance)
+	if v := params.Get("tolerance"); v != "" {
+		var err error
+		if tolerance, err = strconv.ParseFloat(v, 64); err != nil || tolerance < 0 {
+			return nil, 0, fmt.Errorf("invalid tolerance: %q", v)
+		}
+	}
+	return simplify, tolerance, nil
+}
+
+// simplifyTrack returns the positions of track, ordered oldest to newest,
+// kept by simplify
+func simplifyTrack(track []models.Telemetry, simplify func([]geo.Point, float64) []int, tolerance float64) []models.Telemetry {
+	points := make([]geo.Point, len(track))
+	for i, t := range track {
+		points[i] = t.Position.Point()
+	}
+	kept := simplify(points, tolerance)
+	results := make([]models.Telemetry, len(kept))
+	for i, k := range kept {
+		results[i] = track[k]
+	}
+	return results
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+func GetStatusEvents(s models.StatusEventReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		q, err := historyQuery(r)
+		if err != nil {
+			renderError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		events, err := s.StatusEvents(id, q)
+		if err == nil && len(events) > 0 && !readableTenant(r, events[0].Tenant) {
+			// objects of other tenants are indistinguishable from missing ones
+			err = models.ErrNoRecord
+		}
+		if err != nil {
+			renderStoreError(w, err)
+			return
+		}
+		renderJSON(w, http.StatusOK, events)
+	}
+}
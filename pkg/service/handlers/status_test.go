@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+type MockStatusEvents struct {
+	Error  error
+	Tenant string
+}
+
+func (m MockStatusEvents) StatusEvents(id string, q models.HistoryQuery) ([]models.StatusEvent, error) {
+	if m.Error != nil {
+		return nil, m.Error
+	}
+	return []models.StatusEvent{
+		{ObjectId: id, To: models.StatusIdle, Tenant: m.Tenant},
+		{ObjectId: id, From: models.StatusIdle, To: models.StatusMoving, Tenant: m.Tenant},
+	}, nil
+}
+
+func TestGetStatusEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		mock   MockStatusEvents
+		query  string
+		tenant string
+		status int
+		events int
+	}{
+		{name: "Events", status: http.StatusOK, events: 2},
+		{name: "InvalidQuery", query: "?limit=-1", status: http.StatusBadRequest},
+		{name: "NotFound", mock: MockStatusEvents{Error: models.ErrNoRecord}, status: http.StatusNotFound},
+		{name: "InternalError", mock: MockStatusEvents{Error: fmt.Errorf("bad thing")}, status: http.StatusInternalServerError},
+		{name: "Tenant", mock: MockStatusEvents{Tenant: "acme"}, tenant: "acme", status: http.StatusOK, events: 2},
+		{name: "OtherTenant", mock: MockStatusEvents{Tenant: "globex"}, tenant: "acme", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := withID(httptest.NewRequest(http.MethodGet, "/"+tt.query, nil), "vehicle")
+			if tt.tenant != "" {
+				r = withTenant(r, tt.tenant)
+			}
+
+			GetStatusEvents(tt.mock).ServeHTTP(w, r)
+			rs := w.Result()
+			defer rs.Body.Close()
+			if rs.StatusCode != tt.status {
+				t.Fatalf("expected %d status; got %d status", tt.status, rs.StatusCode)
+			}
+			if rs.StatusCode != http.StatusOK {
+				return
+			}
+
+			var events []models.StatusEvent
+			if err := json.NewDecoder(rs.Body).Decode(&events); err != nil {
+				t.Fatalf("could not decode events: %s", err.Error())
+			}
+			if len(events) != tt.events {
+				t.Errorf("expected %d events; got %d", tt.events, len(events))
+			}
+		})
+	}
+}
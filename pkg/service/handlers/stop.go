@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+	"scbunn.org/tmp/gps-tracking-service/pkg/stops"
+)
+
+func GetStops(s models.StopReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		q, err := historyQuery(r)
+		if err != nil {
+			renderError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		found, err := s.Stops(id, q)
+		if err == nil && len(found) > 0 && !readableTenant(r, found[0].Tenant) {
+			// objects of other tenants are indistinguishable from missing ones
+			err = models.ErrNoRecord
+		}
+		if err != nil {
+			renderStoreError(w, err)
+			return
+		}
+		renderJSON(w, http.StatusOK, found)
+	}
+}
+
+// stopsResponse is the fleet's stops along with the hotspots they form
+type stopsResponse struct {
+	Stops    []models.Stop    `json:"stops"`
+	Hotspots []models.Hotspot `json:"hotspots"`
+}
+
+// MaxFleetStops is the most stops of the fleet returned and clustered at
+// once; the most recent stops are kept
+const MaxFleetStops = 1000
+
+// FindStops returns the stops of the fleet in the bbox or polygon of the
+// request and clusters them into hotspots of stops within the cluster
+// radius, in meters, of each other.  Only stops of the tenants the caller
+// may read are returned, up to the limit of the request or MaxFleetStops.
+func FindStops(s models.StopReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := historyQuery(r)
+		if err != nil {
+			renderError(w, http.StatusBadRequest, err)
+			return
+		}
+		area, err := areaQuery(r)
+		if err != nil {
+			renderError(w, http.StatusBadRequest, err)
+			return
+		}
+		radius := float64(stops.DefaultClusterRadius)
+		if v := r.URL.Query().Get("cluster"); v != "" {
+			if radius, err = strconv.ParseFloat(v, 64); err != nil || radius <= 0 {
+				renderError(w, http.StatusBadRequest, fmt.Errorf("invalid cluster: %q", v))
+				return
+			}
+		}
+
+		if q.Limit == 0 || q.Limit > MaxFleetStops {
+			q.Limit = MaxFleetStops
+		}
+
+		found, err := s.FindStops(area, readableTenants(r), q)
+		if err != nil {
+			renderError(w, http.StatusInternalServerError, err)
+			return
+		}
+		renderJSON(w, http.StatusOK, stopsResponse{Stops: found, Hotspots: stops.Cluster(found, radius)})
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+type MockStops struct {
+	Error  error
+	Tenant string
+}
+
+func (m MockStops) Stops(id string, q models.HistoryQuery) ([]models.Stop, error) {
+	if m.Error != nil {
+		return nil, m.Error
+	}
+	return []models.Stop{
+		{Id: "1", ObjectId: id, Duration: 600, Tenant: m.Tenant},
+		{Id: "2", ObjectId: id, Duration: 300, InProgress: true, Tenant: m.Tenant},
+	}, nil
+}
+
+func (m MockStops) FindStops(area geo.Shape, tenants []string, q models.HistoryQuery) ([]models.Stop, error) {
+	if m.Error != nil {
+		return nil, m.Error
+	}
+	if q.Limit != MaxFleetStops {
+		return nil, fmt.Errorf("expected the fleet query to be capped; got a limit of %d", q.Limit)
+	}
+	var found []models.Stop
+	for _, s := range []models.Stop{
+		{Id: "1", ObjectId: "truck", Position: models.Position{Latitude: 45, Longitude: -122}, Duration: 600, Tenant: "acme"},
+		{Id: "1", ObjectId: "van", Position: models.Position{Latitude: 45.001, Longitude: -122}, Duration: 300, Tenant: "acme"},
+		{Id: "1", ObjectId: "car", Position: models.Position{Latitude: 46, Longitude: -122}, Duration: 900, Tenant: "globex"},
+	} {
+		if s.InTenant(tenants) {
+			found = append(found, s)
+		}
+	}
+	return found, nil
+}
+
+func TestGetStops(t *testing.T) {
+	tests := []struct {
+		name   string
+		mock   MockStops
+		query  string
+		tenant string
+		status int
+		stops  int
+	}{
+		{name: "Stops", status: http.StatusOK, stops: 2},
+		{name: "InvalidQuery", query: "?from=yesterday", status: http.StatusBadRequest},
+		{name: "NotFound", mock: MockStops{Error: models.ErrNoRecord}, status: http.StatusNotFound},
+		{name: "InternalError", mock: MockStops{Error: fmt.Errorf("bad thing")}, status: http.StatusInternalServerError},
+		{name: "OtherTenant", mock: MockStops{Tenant: "globex"}, tenant: "acme", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := withID(httptest.NewRequest(http.MethodGet, "/"+tt.query, nil), "vehicle")
+			if tt.tenant != "" {
+				r = withTenant(r, tt.tenant)
+			}
+
+			GetStops(tt.mock).ServeHTTP(w, r)
+			rs := w.Result()
+			defer rs.Body.Close()
+			if rs.StatusCode != tt.status {
+				t.Fatalf("expected %d status; got %d status", tt.status, rs.StatusCode)
+			}
+			if rs.StatusCode != http.StatusOK {
+				return
+			}
+
+			var stops []models.Stop
+			if err := json.NewDecoder(rs.Body).Decode(&stops); err != nil {
+				t.Fatalf("could not decode stops: %s", err.Error())
+			}
+			if len(stops) != tt.stops {
+				t.Errorf("expected %d stops; got %d", tt.stops, len(stops))
+			}
+		})
+	}
+}
+
+func TestFindStops(t *testing.T) {
+	tests := []struct {
+		name     string
+		mock     MockStops
+		query    string
+		tenant   string
+		status   int
+		stops    int
+		hotspots int
+	}{
+		{name: "Fleet", status: http.StatusOK, stops: 3, hotspots: 2},
+		{name: "BBox", query: "?bbox=44,-123,47,-121", status: http.StatusOK, stops: 3, hotspots: 2},
+		{name: "WideCluster", query: "?cluster=200000", status: http.StatusOK, stops: 3, hotspots: 1},
+		{name: "Tenant", tenant: "acme", status: http.StatusOK, stops: 2, hotspots: 1},
+		{name: "LimitCapped", query: "?limit=100000", status: http.StatusOK, stops: 3, hotspots: 2},
+		{name: "InvalidBBox", query: "?bbox=0,0,10", status: http.StatusBadRequest},
+		{name: "InvalidCluster", query: "?cluster=-1", status: http.StatusBadRequest},
+		{name: "InvalidQuery", query: "?from=yesterday", status: http.StatusBadRequest},
+		{name: "InternalError", mock: MockStops{Error: fmt.Errorf("bad thing")}, status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/"+tt.query, nil)
+			if tt.tenant != "" {
+				r = withTenant(r, tt.tenant)
+			}
+
+			FindStops(tt.mock).ServeHTTP(w, r)
+			rs := w.Result()
+			defer rs.Body.Close()
+			if rs.StatusCode != tt.status {
+				t.Fatalf("expected %d status; got %d status", tt.status, rs.StatusCode)
+			}
+			if rs.StatusCode != http.StatusOK {
+				return
+			}
+
+			var body stopsResponse
+			if err := json.NewDecoder(rs.Body).Decode(&body); err != nil {
+				t.Fatalf("could not decode stops: %s", err.Error())
+			}
+			if len(body.Stops) != tt.stops || len(body.Hotspots) != tt.hotspots {
+				t.Errorf("expected %d stops in %d hotspots; got %d in %d", tt.stops, tt.hotspots, len(body.Stops), len(body.Hotspots))
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/pubsub"
+)
+
+// streamHeartbeat is how often an idle stream sends a comment to keep
+// proxies from closing the connection
+const streamHeartbeat = 15 * time.Second
+
+// StreamLocations pushes every accepted telemetry update matching the
+// source, objectId, bbox, or polygon query parameters to the client as
+// server-sent events.  The stream is closed after maxDuration so it ends
+// before the server write timeout; EventSource clients reconnect on their
+// own after the advertised retry delay.
+func StreamLocations(b *pubsub.Broker, maxDuration time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			renderError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+			return
+		}
+
+		area, err := areaQuery(r)
+		if err != nil {
+			renderError(w, http.StatusBadRequest, err)
+			return
+		}
+		params := r.URL.Query()
+		sub := b.Subscribe(pubsub.Filter{
+			Tenants:  readableTenants(r),
+			Source:   params.Get("source"),
+			ObjectID: params.Get("objectId"),
+			Area:     area,
+		})
+		defer b.Unsubscribe(sub)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "retry: 1000\n\n")
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		deadline := time.NewTimer(maxDuration)
+		defer deadline.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-deadline.C:
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			case t, ok := <-sub.C():
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(t)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: telemetry\ndata: %s\n\n", data)
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+	"scbunn.org/tmp/gps-tracking-service/pkg/pubsub"
+)
+
+func TestStreamLocations(t *testing.T) {
+	b := pubsub.NewBroker(10)
+	srv := httptest.NewServer(StreamLocations(b, 5*time.Second))
+	defer srv.Close()
+
+	rs, err := http.Get(srv.URL + "/?source=gateway-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	if rs.StatusCode != http.StatusOK {
+		t.Fatalf("expected %d status; got %d status", http.StatusOK, rs.StatusCode)
+	}
+	if ct := rs.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected an event stream; got %q", ct)
+	}
+
+	for b.Len() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	b.Publish(models.Telemetry{Source: "gateway-2", ObjectID: "filtered"})
+	b.Publish(models.Telemetry{Source: "gateway-1", ObjectID: "0001"})
+
+	scanner := bufio.NewScanner(rs.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var got models.Telemetry
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &got); err != nil {
+			t.Fatalf("could not decode event: %s", err.Error())
+		}
+		if got.ObjectID != "0001" {
+			t.Errorf("expected the gateway-1 update; got %+v", got)
+		}
+		return
+	}
+	t.Errorf("stream closed before an event was received: %v", scanner.Err())
+}
+
+func TestStreamLocationsEndsAfterMaxDuration(t *testing.T) {
+	b := pubsub.NewBroker(10)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	StreamLocations(b, 10*time.Millisecond).ServeHTTP(w, r)
+
+	if !strings.HasPrefix(w.Body.String(), "retry: ") {
+		t.Errorf("expected the stream to advertise a retry delay; got %q", w.Body.String())
+	}
+	if b.Len() != 0 {
+		t.Errorf("expected the subscription to be cancelled; got %d subscribers", b.Len())
+	}
+}
+
+func TestStreamLocationsInvalidArea(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/?bbox=1,2", nil)
+
+	StreamLocations(pubsub.NewBroker(10), time.Second).ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d status; got %d status", http.StatusBadRequest, w.Code)
+	}
+}
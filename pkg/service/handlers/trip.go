@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+func GetTrips(t models.TripReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		q, err := historyQuery(r)
+		if err != nil {
+			renderError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		trips, err := t.Trips(id, q)
+		if err == nil && len(trips) > 0 && !readableTenant(r, trips[0].Tenant) {
+			// objects of other tenants are indistinguishable from missing ones
+			err = models.ErrNoRecord
+		}
+		if err != nil {
+			renderStoreError(w, err)
+			return
+		}
+		renderJSON(w, http.StatusOK, trips)
+	}
+}
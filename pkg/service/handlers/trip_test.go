@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+type MockTrips struct {
+	Error  error
+	Tenant string
+}
+
+func (m MockTrips) Trips(id string, q models.HistoryQuery) ([]models.Trip, error) {
+	if m.Error != nil {
+		return nil, m.Error
+	}
+	return []models.Trip{
+		{Id: "1", ObjectId: id, Distance: 1200, Tenant: m.Tenant},
+		{Id: "2", ObjectId: id, Distance: 800, InProgress: true, Tenant: m.Tenant},
+	}, nil
+}
+
+func TestGetTrips(t *testing.T) {
+	tests := []struct {
+		name   string
+		mock   MockTrips
+		query  string
+		tenant string
+		status int
+		trips  int
+	}{
+		{name: "Trips", status: http.StatusOK, trips: 2},
+		{name: "InvalidQuery", query: "?from=yesterday", status: http.StatusBadRequest},
+		{name: "NotFound", mock: MockTrips{Error: models.ErrNoRecord}, status: http.StatusNotFound},
+		{name: "InternalError", mock: MockTrips{Error: fmt.Errorf("bad thing")}, status: http.StatusInternalServerError},
+		{name: "OtherTenant", mock: MockTrips{Tenant: "globex"}, tenant: "acme", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := withID(httptest.NewRequest(http.MethodGet, "/"+tt.query, nil), "vehicle")
+			if tt.tenant != "" {
+				r = withTenant(r, tt.tenant)
+			}
+
+			GetTrips(tt.mock).ServeHTTP(w, r)
+			rs := w.Result()
+			defer rs.Body.Close()
+			if rs.StatusCode != tt.status {
+				t.Fatalf("expected %d status; got %d status", tt.status, rs.StatusCode)
+			}
+			if rs.StatusCode != http.StatusOK {
+				return
+			}
+
+			var trips []models.Trip
+			if err := json.NewDecoder(rs.Body).Decode(&trips); err != nil {
+				t.Fatalf("could not decode trips: %s", err.Error())
+			}
+			if len(trips) != tt.trips {
+				t.Errorf("expected %d trips; got %d", tt.trips, len(trips))
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/auth"
+)
+
+// Authenticate rejects requests that do not authenticate with one of the
+// authenticators and stores the principal of those that do in the request
+// context.  Authenticators are tried in order; the first that recognises the
+// request's credentials decides.
+func Authenticate(authenticators ...auth.Authenticator) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			// CORS preflight requests never carry credentials
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			for _, a := range authenticators {
+				p, err := a.Authenticate(r)
+				if errors.Is(err, auth.ErrNoCredentials) {
+					continue
+				}
+				if err != nil {
+					unauthorized(w, err)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), p)))
+				return
+			}
+			unauthorized(w, auth.ErrNoCredentials)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// RequireScope rejects requests whose authenticated principal was not
+// granted scope.  Requests are not restricted when authentication is
+// disabled.
+func RequireScope(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if p, ok := auth.FromContext(r.Context()); ok && !p.HasScope(scope) {
+				writeError(w, http.StatusForbidden, fmt.Errorf("missing scope %q", scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func unauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer, ApiKey, %s`, auth.HMACScheme))
+	writeError(w, http.StatusUnauthorized, err)
+}
+
+// writeError renders err in the same shape as the handlers' error responses
+func writeError(w http.ResponseWriter, status int, err error) {
+	response, _ := json.Marshal(map[string]string{
+		"error":   err.Error(),
+		"status":  fmt.Sprintf("%d", status),
+		"message": "an error has occured",
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
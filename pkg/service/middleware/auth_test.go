@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/auth"
+)
+
+func TestAuthenticate(t *testing.T) {
+	keys := auth.NewAPIKeys(map[string]auth.Principal{"k1": {Subject: "gateway-1"}})
+	jwt := auth.NewJWT([]byte("secret"), "", "")
+
+	tests := []struct {
+		name    string
+		method  string
+		header  string
+		value   string
+		status  int
+		subject string
+	}{
+		{name: "APIKey", header: auth.APIKeyHeader, value: "k1", status: http.StatusOK, subject: "gateway-1"},
+		{name: "InvalidAPIKey", header: auth.APIKeyHeader, value: "guess", status: http.StatusUnauthorized},
+		{name: "InvalidBearer", header: "Authorization", value: "Bearer a.b.c", status: http.StatusUnauthorized},
+		{name: "Missing", status: http.StatusUnauthorized},
+		{name: "Preflight", method: http.MethodOptions, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var subject string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if p, ok := auth.FromContext(r.Context()); ok {
+					subject = p.Subject
+				}
+				w.Write([]byte("OK"))
+			})
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(method, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+
+			Authenticate(keys, jwt)(next).ServeHTTP(rr, r)
+			rs := rr.Result()
+			if rs.StatusCode != tt.status {
+				t.Errorf("expected %d status; got %d", tt.status, rs.StatusCode)
+			}
+			if rs.StatusCode == http.StatusUnauthorized && rs.Header.Get("WWW-Authenticate") == "" {
+				t.Errorf("expected a WWW-Authenticate challenge")
+			}
+			if subject != tt.subject {
+				t.Errorf("expected subject %q; got %q", tt.subject, subject)
+			}
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		status    int
+	}{
+		{name: "Granted", principal: &auth.Principal{Scopes: []string{auth.ScopeLocationRead}}, status: http.StatusOK},
+		{name: "Admin", principal: &auth.Principal{Scopes: []string{auth.ScopeAdmin}}, status: http.StatusOK},
+		{name: "Missing", principal: &auth.Principal{Scopes: []string{auth.ScopeLocationWrite}}, status: http.StatusForbidden},
+		{name: "AuthDisabled", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("OK"))
+			})
+
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.principal != nil {
+				r = r.WithContext(auth.NewContext(r.Context(), tt.principal))
+			}
+
+			RequireScope(auth.ScopeLocationRead)(next).ServeHTTP(rr, r)
+			if status := rr.Result().StatusCode; status != tt.status {
+				t.Errorf("expected %d status; got %d", tt.status, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/auth"
+	"scbunn.org/tmp/gps-tracking-service/pkg/ratelimit"
+)
+
+// maxPeekBytes caps how much of a request body is read to find its sources
+const maxPeekBytes = 10 << 20
+
+// ErrRateLimited is returned to requests rejected by a rate limit
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// LimitClients rate limits requests by the address of the client.  Requests
+// are not limited when l is nil.
+func LimitClients(l *ratelimit.Limiter) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if throttle(w, r, l, "client", map[string]int{clientIP(r): 1}) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// LimitSources rate limits telemetry updates by the source they report.
+// Every update of a batch costs its source a token, and a batch is only
+// accepted when each of its sources can afford it.  Updates for sources the
+// authenticated caller does not own are charged to the caller instead, so
+// a caller can not spend the quota of another source.  Requests with no
+// readable source are charged to the authenticated caller, or to the address
+// of the client when there is none.  Requests are not limited when l is nil.
+func LimitSources(l *ratelimit.Limiter) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxPeekBytes))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			// the handler reads the body again, including anything past the
+			// peeked bytes
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+
+			costs := chargeable(r, sourceCosts(data))
+			if len(costs) == 0 {
+				costs[requester(r)] = 1
+			}
+			if throttle(w, r, l, "source", costs) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// callerPrefix marks the bucket of an authenticated caller apart from the
+// buckets of sources
+const callerPrefix = "caller:"
+
+// chargeable moves the costs of sources the authenticated caller of r does
+// not own onto the caller.  Such updates are refused by the handler, and
+// must not use up the quota of the source they claim.
+func chargeable(r *http.Request, costs map[string]int) map[string]int {
+	p, ok := auth.FromContext(r.Context())
+	if !ok {
+		return costs
+	}
+	charged := make(map[string]int, len(costs))
+	for source, cost := range costs {
+		if !p.Owns(source) {
+			source = callerPrefix + p.Subject
+		}
+		charged[source] += cost
+	}
+	return charged
+}
+
+// requester returns the bucket of the authenticated caller of r, or of the
+// address of the client when r is not authenticated
+func requester(r *http.Request) string {
+	if p, ok := auth.FromContext(r.Context()); ok {
+		return callerPrefix + p.Subject
+	}
+	return clientIP(r)
+}
+
+// throttle takes the costs from l and, when they can not be afforded,
+// rejects the request and reports true.
+func throttle(w http.ResponseWriter, r *http.Request, l *ratelimit.Limiter, limit string, costs map[string]int) bool {
+	ok, wait := l.Take(costs)
+	if ok {
+		return false
+	}
+
+	RequestsThrottled.WithLabelValues(r.URL.Path, r.Method, limit).Inc()
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", retryAfter(wait)))
+	writeError(w, http.StatusTooManyRequests, ErrRateLimited)
+	return true
+}
+
+// retryAfter rounds wait up to whole seconds, as Retry-After is sent in
+func retryAfter(wait time.Duration) int {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+// clientIP returns the address of the client without its port
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// sourceCosts counts the updates of each source in a single update, a JSON
+// array of updates, or an NDJSON stream of updates.  Decoding stops at the
+// first malformed update; the handler reports it.
+func sourceCosts(data []byte) map[string]int {
+	costs := make(map[string]int)
+	var update struct {
+		Source string `json:"source"`
+	}
+	count := func() {
+		if update.Source != "" {
+			costs[update.Source]++
+		}
+		update.Source = ""
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if _, err := dec.Token(); err != nil {
+			return costs
+		}
+		for dec.More() {
+			if err := dec.Decode(&update); err != nil {
+				return costs
+			}
+			count()
+		}
+		return costs
+	}
+
+	for {
+		if err := dec.Decode(&update); err != nil {
+			return costs
+		}
+		count()
+	}
+}
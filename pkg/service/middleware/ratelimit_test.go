@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/auth"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+	"scbunn.org/tmp/gps-tracking-service/pkg/ratelimit"
+)
+
+func TestLimitClients(t *testing.T) {
+	handler := LimitClients(ratelimit.New(ratelimit.Limit{Rate: 1, Burst: 1}, nil))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+
+	tests := []struct {
+		name       string
+		addr       string
+		status     int
+		retryAfter string
+	}{
+		{name: "First", addr: "10.0.0.1:4000", status: http.StatusOK},
+		{name: "SameClient", addr: "10.0.0.1:4001", status: http.StatusTooManyRequests, retryAfter: "1"},
+		{name: "OtherClient", addr: "10.0.0.2:4000", status: http.StatusOK},
+		{name: "RealIP", addr: "10.0.0.2", status: http.StatusTooManyRequests, retryAfter: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/clients", nil)
+			r.RemoteAddr = tt.addr
+
+			handler.ServeHTTP(rr, r)
+			if status := rr.Result().StatusCode; status != tt.status {
+				t.Errorf("expected %d status; got %d", tt.status, status)
+			}
+			if got := rr.Result().Header.Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("expected Retry-After of %q; got %q", tt.retryAfter, got)
+			}
+		})
+	}
+
+	throttled, _ := models.GetCounterValue(RequestsThrottled, "/clients", http.MethodGet, "client")
+	if throttled != 2 {
+		t.Errorf("expected 2 throttled requests; got %f", throttled)
+	}
+}
+
+func TestLimitSources(t *testing.T) {
+	var bodies []string
+	handler := LimitSources(ratelimit.New(ratelimit.Limit{Rate: 1, Burst: 3}, nil))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.Write([]byte("OK"))
+	}))
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "Single", body: `{"source": "gateway-1"}`, status: http.StatusOK},
+		{name: "Array", body: `[{"source": "gateway-1"}, {"source": "gateway-2"}]`, status: http.StatusOK},
+		{name: "NDJSON", body: "{\"source\": \"gateway-2\"}\n{\"source\": \"gateway-2\"}\n", status: http.StatusOK},
+		{name: "Exhausted", body: `{"source": "gateway-1"}`, status: http.StatusOK},
+		{name: "Throttled", body: `{"source": "gateway-1"}`, status: http.StatusTooManyRequests},
+		{name: "BatchOverQuota", body: `[{"source": "gateway-3"}, {"source": "gateway-2"}]`, status: http.StatusTooManyRequests},
+		{name: "RejectedBatchIsFree", body: `{"source": "gateway-3"}`, status: http.StatusOK},
+		{name: "Malformed", body: `{"source": `, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bodies = nil
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/sources", strings.NewReader(tt.body))
+
+			handler.ServeHTTP(rr, r)
+			if status := rr.Result().StatusCode; status != tt.status {
+				t.Errorf("expected %d status; got %d", tt.status, status)
+			}
+			if tt.status == http.StatusOK && (len(bodies) != 1 || bodies[0] != tt.body) {
+				t.Errorf("expected the handler to read the whole body; got %q", bodies)
+			}
+		})
+	}
+}
+
+func TestLimitSpoofedSources(t *testing.T) {
+	handler := LimitSources(ratelimit.New(ratelimit.Limit{Rate: 1, Burst: 2}, nil))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	send := func(p *auth.Principal, source string) int {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/spoofed", strings.NewReader(`{"source": "`+source+`"}`))
+		r = r.WithContext(auth.NewContext(r.Context(), p))
+		handler.ServeHTTP(rr, r)
+		return rr.Result().StatusCode
+	}
+
+	attacker := &auth.Principal{Subject: "attacker", Sources: []string{"gateway-2"}}
+	victim := &auth.Principal{Subject: "victim", Sources: []string{"gateway-1"}}
+	for i := 0; i < 3; i++ {
+		send(attacker, "gateway-1")
+	}
+	if status := send(attacker, "gateway-1"); status != http.StatusTooManyRequests {
+		t.Errorf("expected the spoofing caller to be throttled; got %d", status)
+	}
+	for i := 0; i < 2; i++ {
+		if status := send(victim, "gateway-1"); status != http.StatusOK {
+			t.Errorf("update %d: expected the victim's quota to be untouched; got %d", i, status)
+		}
+	}
+}
+
+func TestLimitUnreadableSources(t *testing.T) {
+	handler := LimitSources(ratelimit.New(ratelimit.Limit{Rate: 1, Burst: 2}, nil))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	send := func(p *auth.Principal) int {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/unreadable", strings.NewReader(`{broken`))
+		r = r.WithContext(auth.NewContext(r.Context(), p))
+		handler.ServeHTTP(rr, r)
+		return rr.Result().StatusCode
+	}
+
+	// callers behind the same address each have their own bucket
+	first := &auth.Principal{Subject: "first"}
+	second := &auth.Principal{Subject: "second"}
+	for i := 0; i < 2; i++ {
+		send(first)
+	}
+	if status := send(first); status != http.StatusTooManyRequests {
+		t.Errorf("expected the caller sending unreadable bodies to be throttled; got %d", status)
+	}
+	if status := send(second); status != http.StatusOK {
+		t.Errorf("expected another caller at the same address to be untouched; got %d", status)
+	}
+}
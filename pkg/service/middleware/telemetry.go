@@ -27,6 +27,14 @@ var (
 		[]string{"handler", "verb", "status"},
 	)
 
+	RequestsThrottled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_request_throttled_total",
+			Help: "total number of HTTP requests rejected by a rate limit",
+		},
+		[]string{"handler", "verb", "limit"},
+	)
+
 	RequestSize = prometheus.NewSummary(
 		prometheus.SummaryOpts{
 			Name:       "http_request_size_bytes",
@@ -47,6 +55,7 @@ var (
 func RegisterMetrics() {
 	prometheus.MustRegister(RequestDuration)
 	prometheus.MustRegister(RequestErrors)
+	prometheus.MustRegister(RequestsThrottled)
 	prometheus.MustRegister(RequestSize)
 	prometheus.MustRegister(ResponseSize)
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/auth"
 	"scbunn.org/tmp/gps-tracking-service/pkg/service/handlers"
 	"scbunn.org/tmp/gps-tracking-service/pkg/service/middleware"
 )
@@ -32,7 +33,7 @@ func (s *Service) Routes() http.Handler {
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", auth.APIKeyHeader, auth.TimestampHeader},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		Debug:            true,
@@ -44,14 +45,52 @@ func (s *Service) Routes() http.Handler {
 	r.Handle("/metrics", promhttp.Handler())
 
 	r.Route("/health", func(r chi.Router) {
-		r.Get("/liveness", handlers.Liveness(s.telemetry))
-		r.Get("/readiness", handlers.Ready(s.telemetry))
+		r.Get("/liveness", handlers.Liveness(s.health))
+		r.Get("/readiness", handlers.Ready(s.health))
 	})
 
 	r.Route("/api/v1", func(r chi.Router) {
-		r.Get("/location/", handlers.GetAllLocations(s.telemetry))
-		r.Post("/location/", handlers.UpdateLocation(s.telemetry))
-		r.Get("/location/{id}", handlers.GetLocation(s.telemetry))
+		if len(s.auth) > 0 {
+			r.Use(middleware.Authenticate(s.auth...))
+		}
+		read := middleware.RequireScope(auth.ScopeLocationRead)
+		write := middleware.RequireScope(auth.ScopeLocationWrite)
+		admin := middleware.RequireScope(auth.ScopeAdmin)
+		readLimit := middleware.LimitClients(s.clients)
+		writeLimit := middleware.LimitSources(s.sources)
+
+		r.With(read, readLimit).Get("/location/", handlers.GetAllLocations(s.telemetry))
+		r.With(write, writeLimit).Post("/location/", handlers.UpdateLocation(s.telemetry))
+		r.With(write, writeLimit).Post("/location/batch", handlers.BatchUpdateLocation(s.telemetry))
+		r.With(read, readLimit).Get("/location/nearby", handlers.GetNearbyLocations(s.telemetry))
+		if s.broker != nil {
+			r.With(read, readLimit).Get("/location/stream", handlers.StreamLocations(s.broker, s.streamTTL))
+		}
+		r.With(read, readLimit).Get("/location/{id}", handlers.GetLocation(s.telemetry))
+		r.With(read, readLimit).Get("/location/{id}/history", handlers.GetLocationHistory(s.telemetry))
+		if s.statuses != nil {
+			r.With(read, readLimit).Get("/location/{id}/status", handlers.GetStatusEvents(s.statuses))
+		}
+
+		r.With(read, readLimit).Get("/objects/{id}/odometer", handlers.GetOdometer(s.telemetry, s.retention))
+		if s.trips != nil {
+			r.With(read, readLimit).Get("/objects/{id}/trips", handlers.GetTrips(s.trips))
+		}
+		if s.stops != nil {
+			r.With(read, readLimit).Get("/objects/{id}/stops", handlers.GetStops(s.stops))
+			r.With(read, readLimit).Get("/stops", handlers.FindStops(s.stops))
+		}
+
+		if s.geofences != nil {
+			r.Route("/geofences", func(r chi.Router) {
+				r.With(read, readLimit).Get("/", handlers.GetAllGeofences(s.geofences))
+				r.With(admin).Post("/", handlers.CreateGeofence(s.geofences))
+				r.With(read, readLimit).Get("/{id}", handlers.GetGeofence(s.geofences))
+				r.With(admin).Put("/{id}", handlers.UpdateGeofence(s.geofences))
+				r.With(admin).Delete("/{id}", handlers.DeleteGeofence(s.geofences))
+				r.With(read, readLimit).Get("/{id}/events", handlers.GetGeofenceEvents(s.geofences))
+			})
+		}
 	})
 
 	return r
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models/inmem"
+	"scbunn.org/tmp/gps-tracking-service/pkg/ratelimit"
+)
+
+func TestRoutesBodyLimits(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	s := New(":0", inmem.New(&logger), &logger,
+		// the source limit peeks into the body before the handler reads it
+		WithRateLimits(ratelimit.New(ratelimit.Limit{Rate: 1000, Burst: 1000}, nil), nil),
+	)
+	routes := s.Routes()
+
+	valid := `{"source": "gateway", "objectId": "truck", "position": {"latitude": 45, "longitude": -122}}`
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{name: "Update", path: "/api/v1/location/", body: valid, status: http.StatusCreated},
+		{name: "UpdateTooLarge", path: "/api/v1/location/", body: valid + strings.Repeat(" ", 2<<20), status: http.StatusRequestEntityTooLarge},
+		{name: "Batch", path: "/api/v1/location/batch", body: "[" + valid + "]", status: http.StatusOK},
+		{name: "BatchTooLarge", path: "/api/v1/location/batch", body: "[" + valid + "," + strings.Repeat(" ", 11<<20) + valid + "]", status: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			routes.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("expected %d status; got %d status: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -1,20 +1,116 @@
 package service
 
 import (
+	"time"
+
 	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/auth"
 	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+	"scbunn.org/tmp/gps-tracking-service/pkg/pubsub"
+	"scbunn.org/tmp/gps-tracking-service/pkg/ratelimit"
 )
 
 type Service struct {
 	address   string
 	telemetry models.TelemetryReaderWriterChecker
+	health    models.HealthChecker
+	geofences models.GeofenceReaderWriter
+	statuses  models.StatusEventReader
+	trips     models.TripReader
+	stops     models.StopReader
+	retention models.Retention
+	auth      []auth.Authenticator
+	sources   *ratelimit.Limiter
+	clients   *ratelimit.Limiter
+	broker    *pubsub.Broker
+	streamTTL time.Duration
 	logger    *zerolog.Logger
 }
 
-func New(addr string, telemetry models.TelemetryReaderWriterChecker, log *zerolog.Logger) *Service {
-	return &Service{
+// Option configures optional features of the service
+type Option func(*Service)
+
+// WithGeofences enables the geofence routes backed by the passed datastore
+func WithGeofences(geofences models.GeofenceReaderWriter) Option {
+	return func(s *Service) {
+		s.geofences = geofences
+	}
+}
+
+// WithStatusEvents enables the status event route backed by the passed
+// datastore
+func WithStatusEvents(statuses models.StatusEventReader) Option {
+	return func(s *Service) {
+		s.statuses = statuses
+	}
+}
+
+// WithTrips enables the trip route backed by the passed datastore
+func WithTrips(trips models.TripReader) Option {
+	return func(s *Service) {
+		s.trips = trips
+	}
+}
+
+// WithStops enables the stop routes backed by the passed datastore
+func WithStops(stops models.StopReader) Option {
+	return func(s *Service) {
+		s.stops = stops
+	}
+}
+
+// WithHistory tells the service how much of each track the datastore keeps,
+// so queries reaching past it are rejected rather than answered short.
+// Without it no track is assumed to be kept.
+func WithHistory(size int, age time.Duration) Option {
+	return func(s *Service) {
+		s.retention = models.Retention{Size: size, Age: age}
+	}
+}
+
+// WithAuth requires every API request to authenticate with one of the
+// authenticators.  The health and metrics routes stay open.
+func WithAuth(authenticators ...auth.Authenticator) Option {
+	return func(s *Service) {
+		s.auth = authenticators
+	}
+}
+
+// WithRateLimits limits telemetry updates by their source and reads by the
+// address of the client.  A nil limiter leaves those requests unlimited.
+func WithRateLimits(sources, clients *ratelimit.Limiter) Option {
+	return func(s *Service) {
+		s.sources = sources
+		s.clients = clients
+	}
+}
+
+// WithHealthChecker answers the health routes with hc instead of the
+// telemetry datastore.
+func WithHealthChecker(hc models.HealthChecker) Option {
+	return func(s *Service) {
+		s.health = hc
+	}
+}
+
+// WithStream enables the live location stream fed by the passed broker.
+// Each stream is closed after maxDuration and left to the client to resume.
+func WithStream(broker *pubsub.Broker, maxDuration time.Duration) Option {
+	return func(s *Service) {
+		s.broker = broker
+		s.streamTTL = maxDuration
+	}
+}
+
+func New(addr string, telemetry models.TelemetryReaderWriterChecker, log *zerolog.Logger, opts ...Option) *Service {
+	s := &Service{
 		address:   addr,
 		telemetry: telemetry,
+		health:    telemetry,
 		logger:    log,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
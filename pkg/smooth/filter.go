@@ -0,0 +1,95 @@
+// Package smooth removes GPS noise from the positions reported by sources.
+//
+// Each object has a Kalman filter estimating its position.  A fix pulls the
+// estimate toward it by how precise the fix is compared with the estimate,
+// and the estimate grows less certain with the time since the last fix at
+// the speed objects of its source are expected to move.  No road network is
+// involved, so the filter works for any kind of object.
+package smooth
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// DefaultAccuracy is the accuracy, in meters, assumed of fixes that do not
+// report one
+const DefaultAccuracy = 10
+
+// Filter smooths the positions of the objects of the sources it is turned
+// on for.  The estimate of an object is its stored position along with the
+// variance carried on its telemetry, so an estimate only takes effect once
+// its update is written and goes away with its object.  A nil Filter
+// smooths nothing.
+type Filter struct {
+	// speeds is how fast, in meters a second, objects of each source are
+	// expected to move
+	speeds map[string]float64
+}
+
+// New returns a filter smoothing the positions of each source in speeds,
+// by the speed, in meters a second, its objects are expected to move.  A
+// lower speed smooths more.
+func New(speeds map[string]float64) *Filter {
+	return &Filter{speeds: speeds}
+}
+
+// Smooth replaces the position of t with the estimate of its object once
+// t is taken into account.  The first fix of an object is taken as is, and
+// updates of other sources are left alone.
+func (f *Filter) Smooth(previous *models.Telemetry, t *models.Telemetry) {
+	t.PositionVariance = nil
+	if f == nil {
+		return
+	}
+	speed, ok := f.speeds[t.Source]
+	if !ok {
+		return
+	}
+
+	accuracy := float64(DefaultAccuracy)
+	if t.Accuracy != nil && *t.Accuracy > 0 {
+		accuracy = *t.Accuracy
+	}
+	measured := accuracy * accuracy
+	if previous == nil || previous.PositionVariance == nil {
+		t.PositionVariance = &measured
+		return
+	}
+
+	variance := *previous.PositionVariance
+	if elapsed := t.RecordedAt.Sub(previous.RecordedAt).Seconds(); elapsed > 0 {
+		variance += elapsed * speed * speed
+	}
+	gain := variance / (variance + measured)
+	t.Position.Latitude = previous.Position.Latitude + gain*(t.Position.Latitude-previous.Position.Latitude)
+	t.Position.Longitude = previous.Position.Longitude + gain*(t.Position.Longitude-previous.Position.Longitude)
+	variance *= 1 - gain
+	t.PositionVariance = &variance
+}
+
+// ParseSpeeds parses a comma separated list of source=speed pairs, such as
+// "gateway-1=3,gateway-2=30", into the speed, in meters a second, objects
+// of each source are expected to move.
+func ParseSpeeds(v string) (map[string]float64, error) {
+	speeds := make(map[string]float64)
+	if strings.TrimSpace(v) == "" {
+		return speeds, nil
+	}
+
+	for _, pair := range strings.Split(v, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid smoothing: %q", pair)
+		}
+		speed, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || speed <= 0 {
+			return nil, fmt.Errorf("invalid smoothing: %q", pair)
+		}
+		speeds[strings.TrimSpace(parts[0])] = speed
+	}
+	return speeds, nil
+}
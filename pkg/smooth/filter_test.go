@@ -0,0 +1,112 @@
+package smooth
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+var start0 = time.Date(2020, 12, 15, 10, 0, 0, 0, time.UTC)
+
+// replay smooths fixes of the vehicle at each latitude, a second apart, and
+// returns the smoothed latitudes
+func replay(f *Filter, source string, lats ...float64) []float64 {
+	var previous *models.Telemetry
+	var smoothed []float64
+	for i, lat := range lats {
+		t := models.Telemetry{
+			Id:         "vehicle",
+			Source:     source,
+			RecordedAt: start0.Add(time.Duration(i) * time.Second),
+			Position:   models.Position{Latitude: lat, Longitude: -122},
+		}
+		f.Smooth(previous, &t)
+		smoothed = append(smoothed, t.Position.Latitude)
+		previous = &t
+	}
+	return smoothed
+}
+
+func TestFilter(t *testing.T) {
+	f := New(map[string]float64{"gateway": 1})
+
+	// about 20m of noise around a parked vehicle
+	smoothed := replay(f, "gateway", 45, 45.0002, 44.9998, 45.0002, 44.9998)
+	if smoothed[0] != 45 {
+		t.Errorf("expected the first fix to be taken as is; got %f", smoothed[0])
+	}
+	for i, lat := range smoothed[1:] {
+		if math.Abs(lat-45) >= 0.00015 {
+			t.Errorf("fix %d: expected the noise to be damped; got %f", i+1, lat)
+		}
+	}
+
+	// other sources are left alone
+	if raw := replay(f, "other", 45, 45.0002); raw[1] != 45.0002 {
+		t.Errorf("expected other sources to be left alone; got %v", raw)
+	}
+}
+
+func TestFilterFollowsMovement(t *testing.T) {
+	f := New(map[string]float64{"gateway": 30})
+
+	// a vehicle moving about 11m a second
+	smoothed := replay(f, "gateway", 45, 45.0001, 45.0002, 45.0003, 45.0004)
+	if lag := 45.0004 - smoothed[4]; lag < 0 || lag > 0.00002 {
+		t.Errorf("expected the estimate to keep up with a fast source; got %v", smoothed)
+	}
+}
+
+func TestFilterState(t *testing.T) {
+	f := New(map[string]float64{"gateway": 1})
+	previous := &models.Telemetry{Id: "vehicle", Source: "gateway", RecordedAt: start0, Position: models.Position{Latitude: 45}}
+	f.Smooth(nil, previous)
+
+	// an update that is smoothed but never written does not move the
+	// estimate of the next one
+	fix := func() models.Telemetry {
+		return models.Telemetry{Id: "vehicle", Source: "gateway", RecordedAt: start0.Add(time.Second), Position: models.Position{Latitude: 45.0002}}
+	}
+	lost, kept := fix(), fix()
+	f.Smooth(previous, &lost)
+	f.Smooth(previous, &kept)
+	if kept.Position != lost.Position || *kept.PositionVariance != *lost.PositionVariance {
+		t.Errorf("expected the same estimate from the last written update; got %+v and %+v", lost, kept)
+	}
+
+	// an object seen anew starts over
+	fresh := fix()
+	f.Smooth(nil, &fresh)
+	if fresh.Position.Latitude != 45.0002 || *fresh.PositionVariance != DefaultAccuracy*DefaultAccuracy {
+		t.Errorf("expected a new object to be taken as is; got %+v", fresh)
+	}
+
+	// a variance reported by a source is replaced
+	variance := 1.0
+	other := models.Telemetry{Id: "vehicle", Source: "other", PositionVariance: &variance}
+	f.Smooth(previous, &other)
+	if other.PositionVariance != nil {
+		t.Errorf("expected the reported variance to be dropped; got %f", *other.PositionVariance)
+	}
+}
+
+func TestNilFilter(t *testing.T) {
+	var f *Filter
+	if raw := replay(f, "gateway", 45, 45.0002); raw[1] != 45.0002 {
+		t.Errorf("expected a nil filter to smooth nothing; got %v", raw)
+	}
+}
+
+func TestParseSpeeds(t *testing.T) {
+	speeds, err := ParseSpeeds("gateway-1=3, gateway-2 = 30")
+	if err != nil || speeds["gateway-1"] != 3 || speeds["gateway-2"] != 30 {
+		t.Errorf("unexpected speeds: %v (%v)", speeds, err)
+	}
+	for _, v := range []string{"gateway", "=3", "gateway=fast", "gateway=0"} {
+		if _, err := ParseSpeeds(v); err == nil {
+			t.Errorf("expected %q to be invalid", v)
+		}
+	}
+}
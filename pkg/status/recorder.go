@@ -0,0 +1,47 @@
+// Package status records the status changes of objects.
+package status
+
+import (
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// Recorder compares each accepted update against the previous status of the
+// object and records an event when the status changed.
+type Recorder struct {
+	events models.StatusEventWriter
+	log    *zerolog.Logger
+}
+
+func NewRecorder(events models.StatusEventWriter, logger *zerolog.Logger) *Recorder {
+	return &Recorder{
+		events: events,
+		log:    logger,
+	}
+}
+
+// Observe implements models.TelemetryObserver.  The first status of an
+// object is recorded as a change from no status.
+func (rec *Recorder) Observe(previous *models.Telemetry, current models.Telemetry) {
+	var from models.Status
+	if previous != nil {
+		from = previous.Status
+	}
+	if current.Status == "" || current.Status == from {
+		return
+	}
+
+	event := models.StatusEvent{
+		ObjectId: current.Id,
+		From:     from,
+		To:       current.Status,
+		Position: current.Position,
+		Time:     current.RecordedAt,
+		Tenant:   current.Tenant,
+	}
+	if err := rec.events.AddStatusEvent(event); err != nil {
+		rec.log.Error().Err(err).Str("obj", current.Id).Msg("unable to record status event")
+		return
+	}
+	rec.log.Debug().Str("obj", current.Id).Str("from", string(from)).Str("to", string(current.Status)).Msg("status event")
+}
@@ -0,0 +1,58 @@
+package status
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models/inmem"
+)
+
+func TestRecorder(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := inmem.New(&logger)
+	recorder := NewRecorder(db, &logger)
+
+	now := time.Now()
+	at := func(status models.Status) models.Telemetry {
+		return models.Telemetry{Id: "vehicle", Status: status, RecordedAt: now}
+	}
+	idle, moving, none := at(models.StatusIdle), at(models.StatusMoving), at("")
+
+	tests := []struct {
+		name     string
+		previous *models.Telemetry
+		current  models.Telemetry
+		from, to models.Status
+	}{
+		{name: "NewObjectWithoutStatus", previous: nil, current: none},
+		{name: "NewObject", previous: nil, current: idle, to: models.StatusIdle},
+		{name: "Unchanged", previous: &idle, current: idle},
+		{name: "Changed", previous: &idle, current: moving, from: models.StatusIdle, to: models.StatusMoving},
+		{name: "FirstStatus", previous: &none, current: moving, to: models.StatusMoving},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, _ := db.StatusEvents("vehicle", models.HistoryQuery{})
+			recorder.Observe(tt.previous, tt.current)
+			after, _ := db.StatusEvents("vehicle", models.HistoryQuery{})
+
+			if tt.to == "" {
+				if len(after) != len(before) {
+					t.Errorf("expected no event; got %v", after[len(after)-1])
+				}
+				return
+			}
+			if len(after) != len(before)+1 {
+				t.Fatalf("expected a change to %s; got none", tt.to)
+			}
+			e := after[len(after)-1]
+			if e.From != tt.from || e.To != tt.to || e.ObjectId != "vehicle" || !e.Time.Equal(now) {
+				t.Errorf("expected a change from %q to %s; got %+v", tt.from, tt.to, e)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package stops
+
+import (
+	"sort"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// DefaultClusterRadius is how far, in meters, a stop may be from the center
+// of a hotspot to be part of it
+const DefaultClusterRadius = 200
+
+type cluster struct {
+	hotspot models.Hotspot
+	objects map[string]bool
+}
+
+// Cluster groups stops into hotspots of stops within radius meters of the
+// hotspot's center.  The longest stops seed the hotspots, and hotspots are
+// returned with the longest combined dwell first.
+func Cluster(stops []models.Stop, radius float64) []models.Hotspot {
+	ordered := make([]models.Stop, len(stops))
+	copy(ordered, stops)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Duration > ordered[j].Duration
+	})
+
+	var clusters []*cluster
+	for _, s := range ordered {
+		var joined *cluster
+		for _, c := range clusters {
+			if geo.Distance(c.hotspot.Position.Point(), s.Position.Point()) <= radius {
+				joined = c
+				break
+			}
+		}
+		if joined == nil {
+			joined = &cluster{objects: make(map[string]bool)}
+			clusters = append(clusters, joined)
+		}
+
+		h := &joined.hotspot
+		h.Stops++
+		n := float64(h.Stops)
+		h.Position.Latitude += (s.Position.Latitude - h.Position.Latitude) / n
+		h.Position.Longitude += (s.Position.Longitude - h.Position.Longitude) / n
+		h.TotalDwell += s.Duration
+		h.AverageDwell = h.TotalDwell / n
+		joined.objects[s.ObjectId] = true
+		h.Objects = len(joined.objects)
+	}
+
+	hotspots := make([]models.Hotspot, len(clusters))
+	for i, c := range clusters {
+		hotspots[i] = c.hotspot
+	}
+	sort.SliceStable(hotspots, func(i, j int) bool {
+		return hotspots[i].TotalDwell > hotspots[j].TotalDwell
+	})
+	return hotspots
+}
@@ -0,0 +1,34 @@
+package stops
+
+import (
+	"testing"
+
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+func TestCluster(t *testing.T) {
+	stop := func(object string, lat float64, duration float64) models.Stop {
+		return models.Stop{ObjectId: object, Position: models.Position{Latitude: lat, Longitude: -122}, Duration: duration}
+	}
+	hotspots := Cluster([]models.Stop{
+		stop("truck", 45, 600),
+		stop("van", 45.001, 300),
+		stop("truck", 45.0005, 300),
+		stop("car", 46, 900),
+	}, DefaultClusterRadius)
+
+	if len(hotspots) != 2 {
+		t.Fatalf("expected 2 hotspots; got %+v", hotspots)
+	}
+	depot := hotspots[0]
+	if depot.Stops != 3 || depot.Objects != 2 || depot.TotalDwell != 1200 || depot.AverageDwell != 400 {
+		t.Errorf("unexpected hotspot: %+v", depot)
+	}
+	if hotspots[1].Stops != 1 || hotspots[1].Position.Latitude != 46 {
+		t.Errorf("unexpected hotspot: %+v", hotspots[1])
+	}
+
+	if hotspots := Cluster(nil, DefaultClusterRadius); len(hotspots) != 0 {
+		t.Errorf("expected no hotspots without stops; got %+v", hotspots)
+	}
+}
@@ -0,0 +1,218 @@
+// Package stops detects where objects stay put and for how long.
+//
+// An object is stopped once its fixes have stayed within a radius of their
+// center for at least the minimum duration.  The stop lasts until a fix
+// lands outside the radius.
+package stops
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+const (
+	// DefaultRadius is how far, in meters, an object may wander and still
+	// be stopped
+	DefaultRadius = 50
+
+	// DefaultDuration is how long an object must stay put to be stopped
+	DefaultDuration = 5 * time.Minute
+
+	// SweepInterval is how often Sweep should be called to forget objects
+	// that stopped reporting
+	SweepInterval = time.Minute
+
+	// forgetAfter is how long an object may not report before its stop is
+	// ended at its last fix and it is forgotten
+	forgetAfter = 24 * time.Hour
+)
+
+// candidate is the place an object may be stopped at
+type candidate struct {
+	stop models.Stop
+
+	// fixes is the number of fixes the center is the mean of
+	fixes int
+
+	// confirmed reports whether the object stayed long enough to be stopped
+	confirmed bool
+
+	// seen is when the latest fix of the object was received
+	seen time.Time
+}
+
+// add takes the fix t into the center of the candidate
+func (c *candidate) add(t models.Telemetry) {
+	c.fixes++
+	n := float64(c.fixes)
+	c.stop.Position.Latitude += (t.Position.Latitude - c.stop.Position.Latitude) / n
+	c.stop.Position.Longitude += (t.Position.Longitude - c.stop.Position.Longitude) / n
+	c.stop.Departed = t.RecordedAt
+	c.stop.Duration = c.stop.Departed.Sub(c.stop.Arrived).Seconds()
+}
+
+// object is the stop state of an object.  Its lock is held across the
+// store calls for the object, so the stops of one object are saved in order
+// without blocking other objects.
+type object struct {
+	mu        sync.Mutex
+	candidate *candidate
+
+	// removed is set once the object is forgotten; a caller that looked it
+	// up before then has to look it up again
+	removed bool
+}
+
+// Detector watches the fixes of every object for stops and saves each stop
+// as it grows.  It is safe for concurrent use.
+type Detector struct {
+	stops    models.StopReaderWriter
+	radius   float64
+	duration time.Duration
+	log      *zerolog.Logger
+
+	// mu guards objects only and is never held across store calls
+	mu      sync.Mutex
+	objects map[string]*object
+}
+
+func NewDetector(stops models.StopReaderWriter, radius float64, duration time.Duration, logger *zerolog.Logger) *Detector {
+	return &Detector{
+		stops:    stops,
+		radius:   radius,
+		duration: duration,
+		log:      logger,
+		objects:  make(map[string]*object),
+	}
+}
+
+// Observe implements models.TelemetryObserver
+func (d *Detector) Observe(previous *models.Telemetry, current models.Telemetry) {
+	d.observe(current, time.Now())
+}
+
+// Sweep ends the stops of objects that have not reported for a long time
+// and forgets them.  It should be called every SweepInterval.
+func (d *Detector) Sweep() {
+	d.sweep(time.Now())
+}
+
+func (d *Detector) observe(current models.Telemetry, now time.Time) {
+	for {
+		o := d.lookup(current.Id)
+		o.mu.Lock()
+		if o.removed {
+			o.mu.Unlock()
+			continue
+		}
+		d.track(o, current, now)
+		o.mu.Unlock()
+		return
+	}
+}
+
+// lookup returns the state of the object with the passed id, following it
+// if it was not already
+func (d *Detector) lookup(id string) *object {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	o, ok := d.objects[id]
+	if !ok {
+		o = &object{}
+		d.objects[id] = o
+	}
+	return o
+}
+
+// track grows the stop the object of current may be at, or starts a new
+// candidate once the object leaves it.  o.mu must be held by the caller.
+func (d *Detector) track(o *object, current models.Telemetry, now time.Time) {
+	c := o.candidate
+	if c == nil {
+		c = d.resume(current.Id)
+	}
+	if c != nil && geo.Distance(c.stop.Position.Point(), current.Position.Point()) > d.radius {
+		d.finish(current.Id, c)
+		c = nil
+	}
+	if c == nil {
+		c = &candidate{stop: models.Stop{
+			Id:         strconv.FormatInt(current.RecordedAt.UnixNano(), 10),
+			ObjectId:   current.Id,
+			Tenant:     current.Tenant,
+			Arrived:    current.RecordedAt,
+			InProgress: true,
+		}}
+	}
+	o.candidate = c
+
+	c.add(current)
+	c.seen = now
+	if !c.confirmed && c.stop.Departed.Sub(c.stop.Arrived) >= d.duration {
+		c.confirmed = true
+	}
+	if c.confirmed {
+		d.save(c.stop)
+	}
+}
+
+// resume picks up the stop in progress of an object from the store
+func (d *Detector) resume(id string) *candidate {
+	stops, err := d.stops.Stops(id, models.HistoryQuery{Limit: 1})
+	if err != nil || len(stops) == 0 || !stops[0].InProgress {
+		return nil
+	}
+	// the center is weighted as a single fix
+	return &candidate{stop: stops[0], fixes: 1, confirmed: true}
+}
+
+// finish ends the stop of the candidate at its last fix and saves it, if
+// the object stayed long enough to be stopped
+func (d *Detector) finish(id string, c *candidate) {
+	if !c.confirmed {
+		return
+	}
+	c.stop.InProgress = false
+	d.save(c.stop)
+	d.log.Debug().Str("obj", id).Str("stop", c.stop.Id).Float64("duration", c.stop.Duration).Msg("stop ended")
+}
+
+// sweep ends the stops of objects that have not reported for a long time
+// and forgets them
+func (d *Detector) sweep(now time.Time) {
+	d.mu.Lock()
+	objects := make(map[string]*object, len(d.objects))
+	for id, o := range d.objects {
+		objects[id] = o
+	}
+	d.mu.Unlock()
+
+	for id, o := range objects {
+		o.mu.Lock()
+		if !o.removed && (o.candidate == nil || now.Sub(o.candidate.seen) >= forgetAfter) {
+			if o.candidate != nil {
+				d.finish(id, o.candidate)
+				o.candidate = nil
+			}
+			d.mu.Lock()
+			o.removed = true
+			if d.objects[id] == o {
+				delete(d.objects, id)
+			}
+			d.mu.Unlock()
+		}
+		o.mu.Unlock()
+	}
+}
+
+func (d *Detector) save(s models.Stop) {
+	if err := d.stops.SaveStop(s); err != nil {
+		d.log.Error().Err(err).Str("obj", s.ObjectId).Str("stop", s.Id).Msg("unable to save stop")
+	}
+}
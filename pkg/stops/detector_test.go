@@ -0,0 +1,147 @@
+package stops
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models/inmem"
+)
+
+var start0 = time.Date(2020, 12, 15, 10, 0, 0, 0, time.UTC)
+
+// fix returns a fix of the vehicle at latitude lat, minutes after start0
+func fix(lat float64, minutes int) models.Telemetry {
+	return models.Telemetry{
+		Id:         "vehicle",
+		RecordedAt: start0.Add(time.Duration(minutes) * time.Minute),
+		Position:   models.Position{Latitude: lat, Longitude: -122},
+	}
+}
+
+// replay feeds the fixes to the detector in order, as the pipeline would
+func replay(d *Detector, fixes ...models.Telemetry) {
+	for _, f := range fixes {
+		d.observe(f, f.RecordedAt)
+	}
+}
+
+func TestDetector(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := inmem.New(&logger)
+	d := NewDetector(db, 50, 5*time.Minute, &logger)
+
+	replay(d,
+		fix(45.00, 0),
+		// passing through is not a stop
+		fix(45.01, 2),
+		fix(45.0101, 4),
+		fix(45.02, 6),
+		// jitter within the radius keeps the object at the stop
+		fix(45.0201, 8),
+		fix(45.0199, 10),
+	)
+	if _, err := db.Stops("vehicle", models.HistoryQuery{}); err == nil {
+		t.Fatalf("expected no stop before the duration is reached")
+	}
+
+	replay(d, fix(45.0200, 11))
+	stops, err := db.Stops("vehicle", models.HistoryQuery{})
+	if err != nil || len(stops) != 1 {
+		t.Fatalf("expected a stop; got %v (%v)", stops, err)
+	}
+	if !stops[0].InProgress || stops[0].Duration != 300 || !stops[0].Arrived.Equal(start0.Add(6*time.Minute)) {
+		t.Errorf("expected a stop in progress since minute 6; got %+v", stops[0])
+	}
+	if math.Abs(stops[0].Position.Latitude-45.02) > 1e-9 {
+		t.Errorf("expected the stop centered on its fixes; got %+v", stops[0].Position)
+	}
+
+	replay(d, fix(45.03, 15))
+	stops, _ = db.Stops("vehicle", models.HistoryQuery{})
+	if len(stops) != 1 || stops[0].InProgress || stops[0].Duration != 300 {
+		t.Errorf("expected the stop to end when the object left; got %+v", stops)
+	}
+}
+
+func TestDetectorResume(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := inmem.New(&logger)
+	replay(NewDetector(db, 50, 5*time.Minute, &logger), fix(45, 0), fix(45, 5))
+
+	// a restarted detector picks up the stop in progress
+	d := NewDetector(db, 50, 5*time.Minute, &logger)
+	replay(d, fix(45, 10), fix(46, 12))
+
+	stops, _ := db.Stops("vehicle", models.HistoryQuery{})
+	if len(stops) != 1 || stops[0].InProgress || stops[0].Duration != 600 {
+		t.Errorf("expected the resumed stop to end; got %+v", stops)
+	}
+}
+
+func TestDetectorSweep(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := inmem.New(&logger)
+	d := NewDetector(db, 50, 5*time.Minute, &logger)
+
+	replay(d, fix(45, 0), fix(45, 10))
+	d.sweep(start0.Add(time.Hour))
+	if _, ok := d.objects["vehicle"]; !ok {
+		t.Fatalf("expected a recent object to be remembered")
+	}
+	d.sweep(start0.Add(10*time.Minute + forgetAfter))
+	if _, ok := d.objects["vehicle"]; ok {
+		t.Errorf("expected a silent object to be forgotten")
+	}
+
+	stops, _ := db.Stops("vehicle", models.HistoryQuery{})
+	if len(stops) != 1 || stops[0].InProgress || stops[0].Duration != 600 {
+		t.Errorf("expected the stop of a forgotten object to end at its last fix; got %+v", stops)
+	}
+}
+
+// blockingStops holds the saves of the vehicle until release is closed
+type blockingStops struct {
+	models.StopReaderWriter
+	saving  chan struct{}
+	release chan struct{}
+}
+
+func (b blockingStops) SaveStop(s models.Stop) error {
+	if s.ObjectId == "vehicle" {
+		b.saving <- struct{}{}
+		<-b.release
+	}
+	return b.StopReaderWriter.SaveStop(s)
+}
+
+func TestDetectorSlowStore(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := blockingStops{StopReaderWriter: inmem.New(&logger), saving: make(chan struct{}), release: make(chan struct{})}
+	d := NewDetector(db, 50, 0, &logger)
+
+	go replay(d, fix(45, 0))
+	<-db.saving
+
+	// a slow save of one object does not hold up the others
+	other := fix(10, 0)
+	other.Id = "other"
+	done := make(chan struct{})
+	go func() {
+		replay(d, other)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected other objects to be tracked while a save is in flight")
+	}
+	close(db.release)
+
+	if stops, _ := db.Stops("other", models.HistoryQuery{}); len(stops) != 1 {
+		t.Errorf("expected a stop of the other object; got %+v", stops)
+	}
+}
@@ -0,0 +1,259 @@
+// Package trips splits the position stream of each object into trips.
+//
+// A trip starts when an object gets under way, which is when its ignition
+// is switched on or, for objects that do not report their ignition, when
+// its status becomes moving.  The trip ends once the object has been at
+// rest for the dwell time, so short stops such as traffic lights do not
+// split a journey.  Trips are summarised incrementally as fixes arrive.
+package trips
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/geo"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+)
+
+// DefaultDwell is how long an object must be at rest before its trip ends
+const DefaultDwell = 5 * time.Minute
+
+// SweepInterval is how often Sweep should be called to end the trips of
+// objects that stopped reporting
+const SweepInterval = time.Minute
+
+// Moving reports whether t shows its object under way: its ignition is on
+// or, when the ignition is not reported, its status is moving
+func Moving(t models.Telemetry) bool {
+	if t.Ignition != nil {
+		return *t.Ignition
+	}
+	return t.Status == models.StatusMoving
+}
+
+// progress is the trip an object is on
+type progress struct {
+	trip models.Trip
+
+	// stop is the trip as it was when the object came to rest, or nil
+	// while it is under way
+	stop *models.Trip
+
+	// seen is when the latest fix of the object was received
+	seen time.Time
+}
+
+// current returns the trip as it stands; a trip at rest ends where the
+// object came to rest
+func (p *progress) current() models.Trip {
+	if p.stop != nil {
+		return *p.stop
+	}
+	return p.trip
+}
+
+// object is the trip state of an object.  Its lock is held across the
+// store calls for the object, so the trips of one object are saved in order
+// without blocking other objects.
+type object struct {
+	mu sync.Mutex
+
+	// trip is the trip the object is on, or nil when it is on none
+	trip *progress
+
+	// removed is set once the object is no longer followed; a caller that
+	// looked it up before then has to look it up again
+	removed bool
+}
+
+// Detector follows the trip of every object under way and saves its
+// summary each time a fix extends it.  It is safe for concurrent use.
+type Detector struct {
+	trips models.TripReaderWriter
+	dwell time.Duration
+	log   *zerolog.Logger
+
+	// mu guards objects only and is never held across store calls
+	mu      sync.Mutex
+	objects map[string]*object
+}
+
+func NewDetector(trips models.TripReaderWriter, dwell time.Duration, logger *zerolog.Logger) *Detector {
+	return &Detector{
+		trips:   trips,
+		dwell:   dwell,
+		log:     logger,
+		objects: make(map[string]*object),
+	}
+}
+
+// Observe implements models.TelemetryObserver
+func (d *Detector) Observe(previous *models.Telemetry, current models.Telemetry) {
+	d.observe(previous, current, time.Now())
+}
+
+// Sweep ends the trips of objects that have not reported for the dwell
+// time.  It should be called every SweepInterval.
+func (d *Detector) Sweep() {
+	d.sweep(time.Now())
+}
+
+func (d *Detector) observe(previous *models.Telemetry, current models.Telemetry, now time.Time) {
+	for {
+		o := d.lookup(current.Id)
+		o.mu.Lock()
+		if o.removed {
+			o.mu.Unlock()
+			continue
+		}
+		d.track(o, previous, current, now)
+		if o.trip == nil {
+			d.remove(current.Id, o)
+		}
+		o.mu.Unlock()
+		return
+	}
+}
+
+// lookup returns the state of the object with the passed id, following it
+// if it was not already
+func (d *Detector) lookup(id string) *object {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	o, ok := d.objects[id]
+	if !ok {
+		o = &object{}
+		d.objects[id] = o
+	}
+	return o
+}
+
+// remove stops following the object.  o.mu must be held by the caller.
+func (d *Detector) remove(id string, o *object) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	o.removed = true
+	if d.objects[id] == o {
+		delete(d.objects, id)
+	}
+}
+
+// track extends the trip of the object of current, starting or ending it
+// as the object gets under way or has been at rest for the dwell time.
+// o.mu must be held by the caller.
+func (d *Detector) track(o *object, previous *models.Telemetry, current models.Telemetry, now time.Time) {
+	moving := Moving(current)
+	if o.trip == nil && previous != nil && Moving(*previous) {
+		// the object was under way before the detector last started
+		o.trip = d.resume(current.Id)
+	}
+	if o.trip == nil {
+		if !moving {
+			return
+		}
+		o.trip = &progress{trip: start(current)}
+	} else {
+		extend(&o.trip.trip, previous, current)
+	}
+	p := o.trip
+	p.seen = now
+
+	switch {
+	case moving:
+		p.stop = nil
+	case p.stop == nil:
+		stop := p.trip
+		p.stop = &stop
+	case current.RecordedAt.Sub(p.stop.End.Time) >= d.dwell:
+		d.finish(current.Id, o)
+		return
+	}
+	d.save(p.current())
+}
+
+// resume picks up the trip in progress of an object from the store
+func (d *Detector) resume(id string) *progress {
+	trips, err := d.trips.Trips(id, models.HistoryQuery{Limit: 1})
+	if err != nil || len(trips) == 0 || !trips[0].InProgress {
+		return nil
+	}
+	return &progress{trip: trips[0]}
+}
+
+// finish saves the trip of the object as ended.  o.mu must be held by the
+// caller.
+func (d *Detector) finish(id string, o *object) {
+	trip := o.trip.current()
+	trip.InProgress = false
+	d.save(trip)
+	o.trip = nil
+	d.log.Debug().Str("obj", id).Str("trip", trip.Id).Float64("distance", trip.Distance).Msg("trip ended")
+}
+
+// sweep ends the trips of objects that have not reported for the dwell
+// time
+func (d *Detector) sweep(now time.Time) {
+	d.mu.Lock()
+	objects := make(map[string]*object, len(d.objects))
+	for id, o := range d.objects {
+		objects[id] = o
+	}
+	d.mu.Unlock()
+
+	for id, o := range objects {
+		o.mu.Lock()
+		if !o.removed && o.trip != nil && now.Sub(o.trip.seen) >= d.dwell {
+			d.finish(id, o)
+			d.remove(id, o)
+		}
+		o.mu.Unlock()
+	}
+}
+
+func (d *Detector) save(trip models.Trip) {
+	if err := d.trips.SaveTrip(trip); err != nil {
+		d.log.Error().Err(err).Str("obj", trip.ObjectId).Str("trip", trip.Id).Msg("unable to save trip")
+	}
+}
+
+// start returns a trip starting at the fix t
+func start(t models.Telemetry) models.Trip {
+	at := models.TripPoint{Time: t.RecordedAt, Position: t.Position}
+	trip := models.Trip{
+		Id:         strconv.FormatInt(t.RecordedAt.UnixNano(), 10),
+		ObjectId:   t.Id,
+		Tenant:     t.Tenant,
+		Start:      at,
+		End:        at,
+		InProgress: true,
+	}
+	if t.Speed != nil {
+		trip.MaxSpeed = *t.Speed
+	}
+	return trip
+}
+
+// extend adds the leg from the previous fix to the current one to trip.
+// The reported speed of a fix is preferred over the speed of its leg.
+func extend(trip *models.Trip, previous *models.Telemetry, current models.Telemetry) {
+	if previous != nil {
+		leg := geo.Distance(previous.Position.Point(), current.Position.Point())
+		trip.Distance += leg
+		if elapsed := current.RecordedAt.Sub(previous.RecordedAt).Seconds(); current.Speed == nil && elapsed > 0 && leg/elapsed > trip.MaxSpeed {
+			trip.MaxSpeed = leg / elapsed
+		}
+	}
+	if current.Speed != nil && *current.Speed > trip.MaxSpeed {
+		trip.MaxSpeed = *current.Speed
+	}
+
+	trip.End = models.TripPoint{Time: current.RecordedAt, Position: current.Position}
+	trip.Duration = trip.End.Time.Sub(trip.Start.Time).Seconds()
+	if trip.Duration > 0 {
+		trip.AverageSpeed = trip.Distance / trip.Duration
+	}
+}
@@ -0,0 +1,187 @@
+package trips
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models"
+	"scbunn.org/tmp/gps-tracking-service/pkg/models/inmem"
+)
+
+var start0 = time.Date(2020, 12, 15, 10, 0, 0, 0, time.UTC)
+
+// fix returns a fix of the vehicle at latitude lat, minutes after start0
+func fix(lat float64, minutes int, ignition bool) models.Telemetry {
+	return models.Telemetry{
+		Id:         "vehicle",
+		Ignition:   &ignition,
+		RecordedAt: start0.Add(time.Duration(minutes) * time.Minute),
+		Position:   models.Position{Latitude: lat, Longitude: -122},
+	}
+}
+
+// replay feeds the fixes to the detector in order, as the pipeline would
+func replay(d *Detector, fixes ...models.Telemetry) {
+	var previous *models.Telemetry
+	for _, f := range fixes {
+		f := f
+		d.observe(previous, f, f.RecordedAt)
+		previous = &f
+	}
+}
+
+func TestDetector(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := inmem.New(&logger)
+	d := NewDetector(db, 5*time.Minute, &logger)
+
+	replay(d,
+		fix(45.00, 0, false),
+		fix(45.00, 1, true),
+		fix(45.01, 2, true),
+		fix(45.02, 3, false),
+		// a short stop does not split the trip
+		fix(45.02, 4, true),
+		fix(45.03, 5, false),
+		fix(45.03, 6, false),
+	)
+
+	trips, err := db.Trips("vehicle", models.HistoryQuery{})
+	if err != nil || len(trips) != 1 {
+		t.Fatalf("expected a trip; got %v (%v)", trips, err)
+	}
+	if !trips[0].InProgress || !trips[0].End.Time.Equal(start0.Add(5*time.Minute)) {
+		t.Errorf("expected a trip at rest since its last stop; got %+v", trips[0])
+	}
+
+	replay(d, fix(45.03, 11, false))
+	trips, _ = db.Trips("vehicle", models.HistoryQuery{})
+	trip := trips[0]
+	if len(trips) != 1 || trip.InProgress {
+		t.Fatalf("expected the trip to end after the dwell time; got %+v", trips)
+	}
+	if !trip.Start.Time.Equal(start0.Add(time.Minute)) || trip.Start.Position.Latitude != 45 {
+		t.Errorf("unexpected start: %+v", trip.Start)
+	}
+	if !trip.End.Time.Equal(start0.Add(5*time.Minute)) || trip.End.Position.Latitude != 45.03 {
+		t.Errorf("unexpected end: %+v", trip.End)
+	}
+
+	// 0.03 degrees of latitude is roughly 3336m
+	if math.Abs(trip.Distance-3336) > 5 {
+		t.Errorf("expected a distance of about 3336m; got %f", trip.Distance)
+	}
+	if trip.Duration != 240 {
+		t.Errorf("expected a duration of 240s; got %f", trip.Duration)
+	}
+	if math.Abs(trip.AverageSpeed-trip.Distance/240) > 1e-9 || math.Abs(trip.MaxSpeed-1112/60.0) > 1 {
+		t.Errorf("unexpected speeds: average %f, max %f", trip.AverageSpeed, trip.MaxSpeed)
+	}
+
+	// the next journey is a new trip
+	replay(d, fix(45.03, 20, true), fix(45.04, 21, true))
+	if trips, _ := db.Trips("vehicle", models.HistoryQuery{}); len(trips) != 2 || !trips[1].InProgress {
+		t.Errorf("expected a second trip in progress; got %+v", trips)
+	}
+}
+
+func TestDetectorStatus(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := inmem.New(&logger)
+	d := NewDetector(db, time.Minute, &logger)
+
+	at := func(status models.Status, minutes int) models.Telemetry {
+		return models.Telemetry{Id: "vehicle", Status: status, RecordedAt: start0.Add(time.Duration(minutes) * time.Minute)}
+	}
+	replay(d, at(models.StatusIdle, 0), at(models.StatusMoving, 1), at(models.StatusStopped, 2), at(models.StatusStopped, 3))
+
+	trips, err := db.Trips("vehicle", models.HistoryQuery{})
+	if err != nil || len(trips) != 1 || trips[0].InProgress || trips[0].Duration != 60 {
+		t.Errorf("expected a minute long trip; got %+v (%v)", trips, err)
+	}
+}
+
+func TestDetectorSweep(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := inmem.New(&logger)
+	d := NewDetector(db, 5*time.Minute, &logger)
+
+	replay(d, fix(45, 0, true), fix(45.01, 1, true))
+
+	d.sweep(start0.Add(5 * time.Minute))
+	if trips, _ := db.Trips("vehicle", models.HistoryQuery{}); len(trips) != 1 || !trips[0].InProgress {
+		t.Fatalf("expected the trip to go on within the dwell time; got %+v", trips)
+	}
+
+	// a sweep once the vehicle has been silent for the dwell time ends its
+	// trip
+	d.sweep(start0.Add(10 * time.Minute))
+	trips, _ := db.Trips("vehicle", models.HistoryQuery{})
+	if len(trips) != 1 || trips[0].InProgress || !trips[0].End.Time.Equal(start0.Add(time.Minute)) {
+		t.Errorf("expected the trip of a silent vehicle to end at its last fix; got %+v", trips)
+	}
+	if len(d.objects) != 0 {
+		t.Errorf("expected the vehicle to no longer be followed; got %d objects", len(d.objects))
+	}
+}
+
+// blockingTrips holds the saves of the vehicle until release is closed
+type blockingTrips struct {
+	models.TripReaderWriter
+	saving  chan struct{}
+	release chan struct{}
+}
+
+func (b blockingTrips) SaveTrip(trip models.Trip) error {
+	if trip.ObjectId == "vehicle" {
+		b.saving <- struct{}{}
+		<-b.release
+	}
+	return b.TripReaderWriter.SaveTrip(trip)
+}
+
+func TestDetectorSlowStore(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := blockingTrips{TripReaderWriter: inmem.New(&logger), saving: make(chan struct{}), release: make(chan struct{})}
+	d := NewDetector(db, 5*time.Minute, &logger)
+
+	go replay(d, fix(45, 0, true))
+	<-db.saving
+
+	// a slow save of one object does not hold up the others
+	other := fix(10, 0, true)
+	other.Id = "other"
+	done := make(chan struct{})
+	go func() {
+		replay(d, other)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected other objects to be tracked while a save is in flight")
+	}
+	close(db.release)
+
+	if trips, _ := db.Trips("other", models.HistoryQuery{}); len(trips) != 1 {
+		t.Errorf("expected a trip of the other object; got %+v", trips)
+	}
+}
+
+func TestDetectorResume(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+	db := inmem.New(&logger)
+
+	first, second := fix(45, 0, true), fix(45.01, 1, true)
+	replay(NewDetector(db, 5*time.Minute, &logger), first)
+
+	// a restarted detector picks up the trip in progress
+	NewDetector(db, 5*time.Minute, &logger).observe(&first, second, second.RecordedAt)
+	trips, _ := db.Trips("vehicle", models.HistoryQuery{})
+	if len(trips) != 1 || trips[0].Distance < 1000 || !trips[0].Start.Time.Equal(start0) {
+		t.Errorf("expected the trip to be resumed; got %+v", trips)
+	}
+}
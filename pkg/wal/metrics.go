@@ -0,0 +1,25 @@
+package wal
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	Appended = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "wal_records_appended_total",
+			Help: "total number of records appended to the write-ahead log",
+		},
+	)
+
+	SyncDuration = prometheus.NewSummary(
+		prometheus.SummaryOpts{
+			Name:       "wal_sync_duration_seconds",
+			Help:       "the duration of write-ahead log syncs to stable storage",
+			Objectives: map[float64]float64{0.5: 0.05, 0.75: 0.05, 0.95: 0.05, 0.99: 0.05},
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(Appended)
+	prometheus.MustRegister(SyncDuration)
+}
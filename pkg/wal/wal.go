@@ -0,0 +1,394 @@
+// Package wal implements an append-only, segment-rotated write-ahead log.
+//
+// Records are appended to the newest segment file in the log directory.  Each
+// record is framed by its length and a CRC-32C checksum so a write torn by a
+// crash is detected and skipped on replay.  Segments are named by an
+// increasing sequence number and a new segment is started whenever the
+// current one grows past the configured size, or when Rotate is called.
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultSegmentSize is the size a segment grows to before a new one is
+	// started
+	DefaultSegmentSize = 64 << 20
+
+	// DefaultSyncInterval is how often the log is synced with SyncInterval
+	DefaultSyncInterval = time.Second
+
+	// MaxRecordSize is the largest record the log accepts
+	MaxRecordSize = 16 << 20
+
+	segmentExt = ".wal"
+	headerSize = 8
+)
+
+// SyncMode controls when appended records are flushed to stable storage
+type SyncMode int
+
+const (
+	// SyncAlways syncs before every append returns
+	SyncAlways SyncMode = iota
+	// SyncInterval syncs in the background every sync interval
+	SyncInterval
+	// SyncNone leaves syncing to the operating system
+	SyncNone
+)
+
+func (m SyncMode) String() string {
+	switch m {
+	case SyncAlways:
+		return "always"
+	case SyncInterval:
+		return "interval"
+	case SyncNone:
+		return "none"
+	}
+	return fmt.Sprintf("SyncMode(%d)", int(m))
+}
+
+// ParseSyncMode parses the name of a sync mode: always, interval, or none
+func ParseSyncMode(v string) (SyncMode, error) {
+	for _, m := range []SyncMode{SyncAlways, SyncInterval, SyncNone} {
+		if strings.EqualFold(v, m.String()) {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid wal sync mode: %q", v)
+}
+
+var (
+	// ErrClosed is returned when appending to a closed log
+	ErrClosed = errors.New("wal: log is closed")
+
+	// ErrRecordTooLarge is returned when appending a record larger than
+	// MaxRecordSize
+	ErrRecordTooLarge = errors.New("wal: record too large")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Options configures a log.  Zero values select the defaults.
+type Options struct {
+	SegmentSize  int64
+	Sync         SyncMode
+	SyncInterval time.Duration
+}
+
+// Log is a write-ahead log stored in a directory of segment files.  It is
+// safe for concurrent use.
+type Log struct {
+	dir  string
+	opts Options
+	log  *zerolog.Logger
+
+	mu      sync.Mutex
+	seq     uint64
+	file    *os.File
+	w       *bufio.Writer
+	size    int64
+	dirty   bool
+	closed  bool
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// Open opens the log in dir, creating the directory if needed.  Appends go
+// to a new segment that follows any existing ones, so a torn record left by
+// a crash is never followed by new records.
+func Open(dir string, opts Options, logger *zerolog.Logger) (*Log, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	l := &Log{dir: dir, opts: opts, log: logger}
+	segments, err := l.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		l.seq = segments[len(segments)-1]
+	}
+	if err := l.next(); err != nil {
+		return nil, err
+	}
+
+	if opts.Sync == SyncInterval {
+		l.stop = make(chan struct{})
+		l.stopped = make(chan struct{})
+		go l.syncEvery(opts.SyncInterval)
+	}
+	return l, nil
+}
+
+func segmentName(seq uint64) string {
+	return fmt.Sprintf("%020d%s", seq, segmentExt)
+}
+
+// segments returns the sequence numbers of the segments in the log directory
+// in ascending order
+func (l *Log) segments() ([]uint64, error) {
+	files, err := ioutil.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var seqs []uint64
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+// next closes the current segment and starts the following one.  l.mu must
+// be held by the caller once the log is open.
+func (l *Log) next() error {
+	if l.file != nil {
+		if err := l.flush(true); err != nil {
+			return err
+		}
+		if err := l.file.Close(); err != nil {
+			return err
+		}
+	}
+
+	l.seq++
+	f, err := os.OpenFile(filepath.Join(l.dir, segmentName(l.seq)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	l.file, l.w, l.size = f, bufio.NewWriter(f), 0
+	return nil
+}
+
+// Append writes the records to the log.  With SyncAlways the records are on
+// stable storage when Append returns.
+func (l *Log) Append(records ...[]byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	var header [headerSize]byte
+	for _, data := range records {
+		if len(data) > MaxRecordSize {
+			return ErrRecordTooLarge
+		}
+		if l.size >= l.opts.SegmentSize {
+			if err := l.next(); err != nil {
+				return err
+			}
+		}
+
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(data)))
+		binary.BigEndian.PutUint32(header[4:8], crc32.Checksum(data, crcTable))
+		if _, err := l.w.Write(header[:]); err != nil {
+			return err
+		}
+		if _, err := l.w.Write(data); err != nil {
+			return err
+		}
+		l.size += int64(headerSize + len(data))
+		l.dirty = true
+		Appended.Inc()
+	}
+
+	// records always reach the operating system so they survive the process
+	// crashing; only SyncAlways waits for them to reach stable storage
+	return l.flush(l.opts.Sync == SyncAlways)
+}
+
+// flush writes buffered records to the segment file, syncing it when sync
+// is set.  l.mu must be held by the caller.
+func (l *Log) flush(sync bool) error {
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	if !sync || !l.dirty {
+		return nil
+	}
+
+	start := time.Now()
+	err := l.file.Sync()
+	SyncDuration.Observe(time.Since(start).Seconds())
+	if err == nil {
+		l.dirty = false
+	}
+	return err
+}
+
+// Sync flushes and syncs every appended record to stable storage
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	return l.flush(true)
+}
+
+func (l *Log) syncEvery(interval time.Duration) {
+	defer close(l.stopped)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-tick.C:
+			if err := l.Sync(); err != nil && !errors.Is(err, ErrClosed) {
+				l.log.Error().Err(err).Msg("unable to sync wal")
+			}
+		}
+	}
+}
+
+// Rotate starts a new segment and returns its sequence number.  Every record
+// appended before Rotate is in an earlier segment and can be discarded with
+// TruncateBefore once it is persisted elsewhere.
+func (l *Log) Rotate() (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrClosed
+	}
+	if err := l.next(); err != nil {
+		return 0, err
+	}
+	return l.seq, nil
+}
+
+// TruncateBefore removes every segment older than seq
+func (l *Log) TruncateBefore(seq uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	segments, err := l.segments()
+	if err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if s >= seq || s == l.seq {
+			continue
+		}
+		if err := os.Remove(filepath.Join(l.dir, segmentName(s))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replay calls fn with every record in the log, oldest first.  A torn or
+// corrupt record ends the replay of its segment; the records that follow it
+// in that segment are skipped and replay continues with the next segment.
+// Replay stops at the first error returned by fn.
+func (l *Log) Replay(fn func(data []byte) error) error {
+	l.mu.Lock()
+	segments, err := l.segments()
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, seq := range segments {
+		if err := l.replaySegment(seq, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Log) replaySegment(seq uint64, fn func(data []byte) error) error {
+	f, err := os.Open(filepath.Join(l.dir, segmentName(seq)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var header [headerSize]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err != io.EOF {
+				l.log.Warn().Uint64("segment", seq).Msg("skipping torn wal record")
+			}
+			return nil
+		}
+
+		size := binary.BigEndian.Uint32(header[0:4])
+		if size > MaxRecordSize {
+			l.log.Warn().Uint64("segment", seq).Msg("skipping corrupt wal record")
+			return nil
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			l.log.Warn().Uint64("segment", seq).Msg("skipping torn wal record")
+			return nil
+		}
+		if crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+			l.log.Warn().Uint64("segment", seq).Msg("skipping corrupt wal record")
+			return nil
+		}
+
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+}
+
+// Close syncs and closes the log
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	err := l.flush(true)
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.mu.Unlock()
+
+	if l.stop != nil {
+		close(l.stop)
+		<-l.stopped
+	}
+	return err
+}
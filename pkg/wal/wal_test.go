@@ -0,0 +1,174 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func replay(t *testing.T, l *Log) []string {
+	var records []string
+	if err := l.Replay(func(data []byte) error {
+		records = append(records, string(data))
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected replay error: %s", err.Error())
+	}
+	return records
+}
+
+func TestAppendReplay(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	dir := tempDir(t)
+
+	for _, mode := range []SyncMode{SyncAlways, SyncInterval, SyncNone} {
+		t.Run(mode.String(), func(t *testing.T) {
+			dir := filepath.Join(dir, mode.String())
+			l, err := Open(dir, Options{Sync: mode}, &logger)
+			if err != nil {
+				t.Fatalf("could not open log: %s", err.Error())
+			}
+			l.Append([]byte("one"))
+			l.Append([]byte("two"), []byte("three"))
+			if err := l.Close(); err != nil {
+				t.Fatalf("could not close log: %s", err.Error())
+			}
+
+			// reopening continues in a new segment after the old ones
+			l, _ = Open(dir, Options{Sync: mode}, &logger)
+			defer l.Close()
+			l.Append([]byte("four"))
+
+			want := []string{"one", "two", "three", "four"}
+			if got := replay(t, l); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSegmentRotation(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	dir := tempDir(t)
+
+	l, _ := Open(dir, Options{SegmentSize: 32, Sync: SyncNone}, &logger)
+	defer l.Close()
+	var want []string
+	for i := 0; i < 10; i++ {
+		record := fmt.Sprintf("record-%d", i)
+		l.Append([]byte(record))
+		want = append(want, record)
+	}
+
+	segments, _ := l.segments()
+	if len(segments) < 3 {
+		t.Errorf("expected the log to rotate; got %d segments", len(segments))
+	}
+	if got := replay(t, l); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestTruncateBefore(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	dir := tempDir(t)
+
+	l, _ := Open(dir, Options{Sync: SyncNone}, &logger)
+	defer l.Close()
+	l.Append([]byte("old"))
+	seq, err := l.Rotate()
+	if err != nil {
+		t.Fatalf("could not rotate: %s", err.Error())
+	}
+	l.Append([]byte("new"))
+
+	if err := l.TruncateBefore(seq); err != nil {
+		t.Fatalf("could not truncate: %s", err.Error())
+	}
+	if got := replay(t, l); !reflect.DeepEqual(got, []string{"new"}) {
+		t.Errorf("expected only records after the rotation; got %v", got)
+	}
+}
+
+func TestTornRecord(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	dir := tempDir(t)
+
+	l, _ := Open(dir, Options{Sync: SyncAlways}, &logger)
+	l.Append([]byte("kept"), []byte("torn"))
+	l.Close()
+
+	// simulate a crash part way through writing the last record
+	path := filepath.Join(dir, segmentName(1))
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-2); err != nil {
+		t.Fatal(err)
+	}
+
+	l, _ = Open(dir, Options{Sync: SyncAlways}, &logger)
+	defer l.Close()
+	l.Append([]byte("after"))
+
+	if got := replay(t, l); !reflect.DeepEqual(got, []string{"kept", "after"}) {
+		t.Errorf("expected the torn record to be skipped; got %v", got)
+	}
+}
+
+func TestCorruptRecord(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	dir := tempDir(t)
+
+	l, _ := Open(dir, Options{Sync: SyncAlways}, &logger)
+	l.Append([]byte("kept"), []byte("corrupt"))
+	l.Close()
+
+	path := filepath.Join(dir, segmentName(1))
+	data, _ := ioutil.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	ioutil.WriteFile(path, data, 0o644)
+
+	l, _ = Open(dir, Options{Sync: SyncAlways}, &logger)
+	defer l.Close()
+	if got := replay(t, l); !reflect.DeepEqual(got, []string{"kept"}) {
+		t.Errorf("expected the corrupt record to be skipped; got %v", got)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	l, _ := Open(tempDir(t), Options{}, &logger)
+	l.Close()
+
+	if err := l.Append([]byte("late")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed; got %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Errorf("expected a second close to succeed; got %v", err)
+	}
+}
+
+func TestParseSyncMode(t *testing.T) {
+	for _, mode := range []SyncMode{SyncAlways, SyncInterval, SyncNone} {
+		if got, err := ParseSyncMode(mode.String()); err != nil || got != mode {
+			t.Errorf("could not parse %s: %v", mode, err)
+		}
+	}
+	if _, err := ParseSyncMode("sometimes"); err == nil {
+		t.Errorf("expected an invalid mode to be rejected")
+	}
+}